@@ -0,0 +1,167 @@
+// Package v1 is lnkit's stable API: the types lnk's JSON output is made of, for
+// provisioners, GUIs, and other tools that drive lnk or read its reports.
+//
+// Nothing exported here is removed, renamed, or changes meaning within v1, and
+// neither do the JSON field names or the string values of EntryStatus, ReasonCode,
+// and ActionKind. New fields, statuses, reasons, and action kinds may be added, so
+// consumers should ignore unknown JSON fields and treat unknown values as opaque.
+// Incompatible changes get a new package path, lnkit/api/v2.
+package v1
+
+import "time"
+
+// Version is the API version, written to every report and plan.
+const Version = 1
+
+// EntryStatus is the outcome of processing a single link path.
+type EntryStatus string
+
+const (
+	StatusLinked    EntryStatus = "linked"    // A link was created
+	StatusUnchanged EntryStatus = "unchanged" // The correct link was already in place
+	StatusSkipped   EntryStatus = "skipped"   // The entry was deliberately not applied
+	StatusFailed    EntryStatus = "failed"    // Applying the entry was attempted and failed
+	StatusUnlinked  EntryStatus = "unlinked"  // A link was removed
+	StatusRenamed   EntryStatus = "renamed"   // A link was moved along with its renamed target
+	StatusPending   EntryStatus = "pending"   // A dry run would create a link
+	StatusConflict  EntryStatus = "conflict"  // A dry run found something in the way of a link
+)
+
+// ReasonCode is a stable, machine-readable reason attached to every skipped or failed entry.
+type ReasonCode string
+
+const (
+	ReasonIgnoredPattern    ReasonCode = "IGNORED_PATTERN"    // Matched an ignore pattern
+	ReasonConflictModified  ReasonCode = "CONFLICT_MODIFIED"  // A file with different content is in the way
+	ReasonConflictMislinked ReasonCode = "CONFLICT_MISLINKED" // A symlink pointing outside the source is in the way
+	ReasonPermissionDenied  ReasonCode = "PERMISSION_DENIED"  // The filesystem refused the operation
+	ReasonLinkFailed        ReasonCode = "LINK_FAILED"        // Any other failure creating the link
+	ReasonUnmanagedLink     ReasonCode = "UNMANAGED_LINK"     // The link wasn't created by lnkit
+	ReasonReadOnly          ReasonCode = "READ_ONLY"          // Not attempted because of --read-only
+	ReasonNoTarget          ReasonCode = "NO_TARGET"          // An exception has no target for this OS
+	ReasonShadowed          ReasonCode = "SHADOWED"           // A base repo file is overridden by the personal repo
+	ReasonImmutable         ReasonCode = "IMMUTABLE"          // The path or its directory is flagged immutable
+	ReasonCollision         ReasonCode = "COLLISION"          // Another source with a higher priority is linked to the same place
+	ReasonOutsideRoot       ReasonCode = "OUTSIDE_ROOT"       // Linking outside the link root wasn't confirmed, or in a plan, will have to be
+	ReasonFetchFailed       ReasonCode = "FETCH_FAILED"       // A remote file couldn't be fetched, or didn't match its pinned hash
+	ReasonFetchPending      ReasonCode = "FETCH_PENDING"      // In a plan, a remote file isn't cached yet and will be fetched
+)
+
+// Entry records what happened to a single link path during a run.
+type Entry struct {
+	LinkPath   string      `json:"link_path"`
+	TargetPath string      `json:"target_path"`
+	State      string      `json:"state"`
+	Status     EntryStatus `json:"status"`
+	Reason     ReasonCode  `json:"reason,omitempty"`
+	Message    string      `json:"message,omitempty"`
+	Backup     string      `json:"backup,omitempty"` // Where what was at LinkPath was moved to, if anything
+
+	CreatedDirs []string `json:"created_dirs,omitempty"` // Parent directories created for the link
+	RenamedFrom string   `json:"renamed_from,omitempty"` // The old link replaced by this one, if renamed
+	OldTarget   string   `json:"old_target,omitempty"`   // Where the symlink this one replaced pointed, if any
+
+	Detect time.Duration `json:"detect_ns,omitempty"` // How long working out the entry's state took, e.g. hashing files to compare
+	Apply  time.Duration `json:"apply_ns,omitempty"`  // How long acting on it took
+}
+
+// Stats counts entries by status.
+type Stats struct {
+	Linked    int `json:"linked"`
+	Unchanged int `json:"unchanged"`
+	Skipped   int `json:"skipped"`
+	Ignored   int `json:"ignored"` // Skipped because they matched an ignore pattern, not counted in Skipped
+	Failed    int `json:"failed"`
+	Unlinked  int `json:"unlinked"`
+	Renamed   int `json:"renamed"`
+	Pending   int `json:"pending"`
+	Conflict  int `json:"conflict"`
+}
+
+// CountStats counts entries by status.
+func CountStats(entries []Entry) Stats {
+	var s Stats
+	for _, e := range entries {
+		switch {
+		case e.Reason == ReasonIgnoredPattern:
+			s.Ignored++
+		case e.Status == StatusLinked:
+			s.Linked++
+		case e.Status == StatusUnchanged:
+			s.Unchanged++
+		case e.Status == StatusSkipped:
+			s.Skipped++
+		case e.Status == StatusFailed:
+			s.Failed++
+		case e.Status == StatusUnlinked:
+			s.Unlinked++
+		case e.Status == StatusRenamed:
+			s.Renamed++
+		case e.Status == StatusPending:
+			s.Pending++
+		case e.Status == StatusConflict:
+			s.Conflict++
+		}
+	}
+	return s
+}
+
+// Report is the result of applying a run, as written by --json.
+type Report struct {
+	Version   int      `json:"version"`
+	Entries   []Entry  `json:"entries"`
+	Stats     Stats    `json:"stats"`
+	FollowUps []string `json:"follow_ups,omitempty"` // Things the user may need to do next
+}
+
+// NewReport returns the report of a run that processed entries.
+func NewReport(entries []Entry, followUps []string) Report {
+	if entries == nil {
+		entries = []Entry{}
+	}
+	return Report{Version: Version, Entries: entries, Stats: CountStats(entries), FollowUps: followUps}
+}
+
+// ActionKind is what applying a plan would do to a link path.
+type ActionKind string
+
+const (
+	ActionCreate  ActionKind = "create"  // Create a link where there is nothing
+	ActionReplace ActionKind = "replace" // Something is in the way and needs resolving first
+	ActionKeep    ActionKind = "keep"    // The correct link is already in place
+	ActionSkip    ActionKind = "skip"    // The path is left alone, see Reason
+)
+
+// Action is a single step of a plan.
+type Action struct {
+	Kind       ActionKind `json:"kind"`
+	LinkPath   string     `json:"link_path"`
+	TargetPath string     `json:"target_path"`
+	State      string     `json:"state"`
+	Reason     ReasonCode `json:"reason,omitempty"`
+}
+
+// Plan is what a run would do, without having done it.
+type Plan struct {
+	Version int      `json:"version"`
+	Actions []Action `json:"actions"`
+	Stats   Stats    `json:"stats"`
+}
+
+// NewPlan returns the plan made of the entries of a dry run.
+func NewPlan(entries []Entry) Plan {
+	plan := Plan{Version: Version, Actions: make([]Action, 0, len(entries)), Stats: CountStats(entries)}
+	for _, e := range entries {
+		kind := ActionSkip
+		switch e.Status {
+		case StatusPending:
+			kind = ActionCreate
+		case StatusConflict:
+			kind = ActionReplace
+		case StatusUnchanged:
+			kind = ActionKeep
+		}
+		plan.Actions = append(plan.Actions, Action{Kind: kind, LinkPath: e.LinkPath, TargetPath: e.TargetPath, State: e.State, Reason: e.Reason})
+	}
+	return plan
+}
@@ -0,0 +1,43 @@
+package v1
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewPlan(t *testing.T) {
+	plan := NewPlan([]Entry{
+		{LinkPath: "/h/.bashrc", TargetPath: "/d/.bashrc", State: "LMissing", Status: StatusPending},
+		{LinkPath: "/h/.vimrc", TargetPath: "/d/.vimrc", State: "LExistsModified", Status: StatusConflict, Reason: ReasonConflictModified},
+		{LinkPath: "/h/.zshrc", TargetPath: "/d/.zshrc", State: "LAlreadyLinked", Status: StatusUnchanged},
+		{LinkPath: "/h/README.md", TargetPath: "/d/README.md", Status: StatusSkipped, Reason: ReasonIgnoredPattern},
+	})
+
+	require.Equal(t, Version, plan.Version)
+	kinds := make([]ActionKind, len(plan.Actions))
+	for i, a := range plan.Actions {
+		kinds[i] = a.Kind
+	}
+	require.Equal(t, []ActionKind{ActionCreate, ActionReplace, ActionKeep, ActionSkip}, kinds)
+	require.Equal(t, Stats{Pending: 1, Conflict: 1, Unchanged: 1, Ignored: 1}, plan.Stats)
+}
+
+// The JSON form is part of the API: these field names must never change within v1.
+func TestReportJSON(t *testing.T) {
+	data, err := json.Marshal(NewReport([]Entry{
+		{LinkPath: "/h/.bashrc", TargetPath: "/d/.bashrc", State: "LMissing", Status: StatusLinked, CreatedDirs: []string{"/h"}},
+	}, []string{"Restart your shell"}))
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"version": 1,
+		"entries": [{"link_path": "/h/.bashrc", "target_path": "/d/.bashrc", "state": "LMissing", "status": "linked", "created_dirs": ["/h"]}],
+		"stats": {"linked": 1, "unchanged": 0, "skipped": 0, "ignored": 0, "failed": 0, "unlinked": 0, "renamed": 0, "pending": 0, "conflict": 0},
+		"follow_ups": ["Restart your shell"]
+	}`, string(data))
+
+	data, err = json.Marshal(NewReport(nil, nil))
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"entries":[]`)
+}
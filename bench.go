@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"lnkit/fileutil"
+	"lnkit/manifest"
+	"lnkit/stringutil"
+	"lnkit/ymlfs"
+
+	"github.com/spf13/cobra"
+)
+
+// benchPhase is the measurement of one step of a benchmark run.
+type benchPhase struct {
+	Phase     string        `json:"phase"`
+	Entries   int           `json:"entries"`
+	Duration  time.Duration `json:"duration_ns"`
+	PerSecond float64       `json:"entries_per_second"`
+	Allocs    uint64        `json:"allocs"`
+	Bytes     uint64        `json:"alloc_bytes"`
+}
+
+// measure runs fn and records how long it took and how much it allocated.
+func measure(phase string, fn func() (*Report, error)) (benchPhase, error) {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+
+	report, err := fn()
+
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+	if err != nil {
+		return benchPhase{}, fmt.Errorf("%s: %w", phase, err)
+	}
+
+	p := benchPhase{
+		Phase:    phase,
+		Entries:  len(report.Entries),
+		Duration: elapsed,
+		Allocs:   after.Mallocs - before.Mallocs,
+		Bytes:    after.TotalAlloc - before.TotalAlloc,
+	}
+	if elapsed > 0 {
+		p.PerSecond = float64(p.Entries) / elapsed.Seconds()
+	}
+	return p, nil
+}
+
+// runBench generates a synthetic source tree in a temp dir, then plans, applies,
+// re-checks, and removes links to it, measuring each phase.
+func runBench(files, depth int, seed int64) ([]benchPhase, error) {
+	if err := fileutil.CheckWritable("generate a benchmark tree in", os.TempDir()); err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp("", "lnkit-bench-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	linkRoot := filepath.Join(dir, "home")
+	targetRoot := filepath.Join(dir, "dotfiles")
+	if err := os.MkdirAll(targetRoot, 0755); err != nil {
+		return nil, err
+	}
+
+	data, err := ymlfs.RandomYml(seed, files, depth)
+	if err != nil {
+		return nil, err
+	}
+	if err := ymlfs.FromYml(targetRoot, data); err != nil {
+		return nil, fmt.Errorf("failed to generate tree: %w", err)
+	}
+
+	// The bench keeps its manifest with the tree so it never touches the user's state
+	m, err := manifest.Load(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	opts := linkOptions{recursive: true, createDirs: true, linkStyle: LinkAbsolute}
+	dryRun := opts
+	dryRun.dryRun = true
+
+	steps := []struct {
+		phase string
+		fn    func() (*Report, error)
+	}{
+		{"plan", func() (*Report, error) { return createSymlinks(linkRoot, targetRoot, dryRun) }},
+		{"apply", func() (*Report, error) { return createSymlinks(linkRoot, targetRoot, opts) }},
+		{"status", func() (*Report, error) { return createSymlinks(linkRoot, targetRoot, dryRun) }},
+		{"unlink", func() (*Report, error) { return removeSymlinks(linkRoot, targetRoot, opts, true, m) }},
+	}
+
+	phases := make([]benchPhase, 0, len(steps))
+	for _, step := range steps {
+		p, err := measure(step.phase, step.fn)
+		if err != nil {
+			return phases, err
+		}
+		phases = append(phases, p)
+	}
+	return phases, nil
+}
+
+func NewBenchCmd() *cobra.Command {
+	var files, depth int
+	var seed int64
+	var jsonOut bool
+
+	cmd := &cobra.Command{
+		Use:   "bench",
+		Short: "Measure linking throughput and allocations on a synthetic source tree",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if files <= 0 || depth < 0 {
+				return fmt.Errorf("--files must be positive and --depth can't be negative")
+			}
+
+			// Per-entry logging would dominate the measurements
+			quietLogs("Error")
+
+			phases, err := runBench(files, depth, seed)
+			if err != nil {
+				return err
+			}
+
+			if jsonOut {
+				enc := json.NewEncoder(cmd.OutOrStdout())
+				enc.SetIndent("", "  ")
+				return enc.Encode(phases)
+			}
+
+			rows := make([][2]string, len(phases))
+			for i, p := range phases {
+				rows[i] = [2]string{p.Phase, fmt.Sprintf("%d entries in %s (%.0f/s), %d allocs, %d KiB",
+					p.Entries, p.Duration.Round(time.Microsecond), p.PerSecond, p.Allocs, p.Bytes/1024)}
+			}
+			stringutil.PrintDotTable(rows)
+			return nil
+		},
+		Example: `
+			lnk bench
+			lnk bench --files 50000 --depth 6 --json
+		`,
+	}
+	cmd.Flags().IntVar(&files, "files", 1000, "Number of files in the generated tree")
+	cmd.Flags().IntVar(&depth, "depth", 4, "Maximum directory depth of the generated tree")
+	cmd.Flags().Int64Var(&seed, "seed", 1, "Seed for generating the tree, to compare runs")
+	cmd.Flags().BoolVar(&jsonOut, "json", false, "Print the measurements as JSON")
+
+	return cmd
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// CleanSource is the require_clean_source option: what forced operations do when
+// the source git repo has uncommitted changes. In TOML it is a bool (true refuses)
+// or one of "off", "warn", and "refuse".
+type CleanSource string
+
+const (
+	CleanSourceOff    CleanSource = "off"    // Don't check
+	CleanSourceWarn   CleanSource = "warn"   // Warn, then go ahead
+	CleanSourceRefuse CleanSource = "refuse" // Refuse to go ahead
+)
+
+func (c *CleanSource) UnmarshalTOML(data any) error {
+	switch v := data.(type) {
+	case bool:
+		*c = CleanSourceOff
+		if v {
+			*c = CleanSourceRefuse
+		}
+	case string:
+		switch CleanSource(v) {
+		case CleanSourceOff, CleanSourceWarn, CleanSourceRefuse:
+			*c = CleanSource(v)
+		default:
+			return fmt.Errorf("unknown require_clean_source %q, expected true, false, %q, %q, or %q", v, CleanSourceOff, CleanSourceWarn, CleanSourceRefuse)
+		}
+	default:
+		return fmt.Errorf("require_clean_source must be a bool or string, got %T", data)
+	}
+	return nil
+}
+
+// uncommittedChanges returns the paths git reports as changed or untracked in the
+// repo at dir, or false if dir isn't in a git repo or git isn't installed.
+func uncommittedChanges(dir string) ([]string, bool) {
+	out, err := exec.Command("git", "-C", dir, "status", "--porcelain").Output()
+	if err != nil {
+		sugar.Debugf("Not checking %s for uncommitted changes: %v", dir, err)
+		return nil, false
+	}
+	var changes []string
+	for _, line := range strings.Split(strings.TrimRight(string(out), "\n"), "\n") {
+		if line != "" {
+			changes = append(changes, strings.TrimSpace(line))
+		}
+	}
+	return changes, true
+}
+
+// checkCleanSource applies the require_clean_source policy before a forced
+// operation on targetRoot, so nothing is relinked against a half-edited repo.
+func checkCleanSource(targetRoot string, policy CleanSource) error {
+	if policy == CleanSourceOff || policy == "" {
+		return nil
+	}
+	changes, ok := uncommittedChanges(targetRoot)
+	if !ok || len(changes) == 0 {
+		return nil
+	}
+
+	msg := fmt.Sprintf("source repo %s has %d uncommitted changes (first: %s)", targetRoot, len(changes), changes[0])
+	if policy == CleanSourceWarn {
+		sugar.Warnf("%s, forcing anyway", msg)
+		return nil
+	}
+	return fmt.Errorf("%s; commit or stash them, or set require_clean_source = \"warn\"", msg)
+}
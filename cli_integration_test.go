@@ -2,10 +2,26 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/exec"
+	"os/user"
 	"path/filepath"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
+	api "lnkit/api/v1"
+	"lnkit/fileutil"
+	"lnkit/manifest"
+	"lnkit/stringutil"
 	"lnkit/ymlfs"
 
 	"github.com/spf13/cobra"
@@ -13,9 +29,16 @@ import (
 )
 
 func buildRootCmd() *cobra.Command {
-	rootCmd := &cobra.Command{Use: "lnk"}
-	rootCmd.AddCommand(NewLinkCmd())
-	return rootCmd
+	return NewRootCmd()
+}
+
+// newTestDir quiets logging, points lnkit's state at a throwaway directory, and
+// returns a temp dir to build fixtures in.
+func newTestDir(t *testing.T) string {
+	t.Helper()
+	InitLogger("Fatal")
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	return t.TempDir()
 }
 
 func runCommand(t *testing.T, cmd *cobra.Command, args ...string) string {
@@ -30,6 +53,19 @@ func runCommand(t *testing.T, cmd *cobra.Command, args ...string) string {
 	return out.String()
 }
 
+// runCommandStdout runs cmd and returns only what it wrote to stdout, e.g. a
+// JSON report without the per-entry lines written to stderr.
+func runCommandStdout(t *testing.T, cmd *cobra.Command, args ...string) (string, error) {
+	t.Helper()
+	var out, errOut bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetErr(&errOut)
+	cmd.SetArgs(args)
+
+	err := cmd.Execute()
+	return out.String(), err
+}
+
 func assertSymlink(t *testing.T, linkPath, expectedTarget string) {
 	t.Helper()
 
@@ -43,10 +79,9 @@ func assertSymlink(t *testing.T, linkPath, expectedTarget string) {
 }
 
 func testLinkCommand(t *testing.T, initialYAML, expectedYAML []byte, cmdName, linkPath, targetPath string, args ...string) {
-	InitLogger("Fatal")
 
 	// Put into a temp dir--relativize the link and target path
-	tmpDir := t.TempDir()
+	tmpDir := newTestDir(t)
 	linkPath = filepath.Join(tmpDir, linkPath)
 	targetPath = filepath.Join(tmpDir, targetPath)
 
@@ -177,15 +212,26 @@ targetfile: null
 }
 
 func TestLink_NoTarget(t *testing.T) {
-	initial := []byte(`
+	tmpDir := newTestDir(t)
+	err := ymlfs.FromYml(tmpDir, []byte(`
 targetfile: null
-`)
+`))
+	require.NoError(t, err)
 
-	expected := []byte(`
+	// The failure is reported as an entry, not as an exit status
+	var report Report
+	out, err := runCommandStdout(t, buildRootCmd(), "link", filepath.Join(tmpDir, "link"), filepath.Join(tmpDir, "nonexistentfile"), "--json")
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal([]byte(out), &report))
+	require.Len(t, report.Entries, 1)
+	require.Equal(t, StatusFailed, report.Entries[0].Status)
+	require.Equal(t, ReasonLinkFailed, report.Entries[0].Reason)
+
+	matched, err := ymlfs.AssertStructure(tmpDir, `
 targetfile: null
 `)
-
-	testLinkCommand(t, initial, expected, "link", "link", "nonexistentfile")
+	require.NoError(t, err)
+	require.True(t, matched)
 }
 
 func TestLink_LinkDirectory(t *testing.T) {
@@ -288,3 +334,1739 @@ home:
 
 	testLinkCommand(t, initial, expected, "link", "./home", "./home/.dotfiles", "--rec")
 }
+
+func TestLink_BatchBackup(t *testing.T) {
+	initial := []byte(`
+home:
+  a: {type: file, content: "old a"}
+  b: {type: file, content: "old b"}
+dots:
+  a: {type: file, content: "new a"}
+  b: {type: file, content: "new b"}
+`)
+
+	expected := []byte(`
+home:
+  a: {type: symlink, target: ../dots/a}
+  a.bak: {type: file, content: "old a"}
+  b: {type: symlink, target: ../dots/b}
+  b.bak: {type: file, content: "old b"}
+dots:
+  a: {type: file, content: "new a"}
+  b: {type: file, content: "new b"}
+`)
+
+	// One decision covers both conflicts
+	stringutil.SetInput(strings.NewReader("b\n"))
+	defer stringutil.SetInput(os.Stdin)
+
+	testLinkCommand(t, initial, expected, "link", "home", "dots", "--rec", "--batch", "5")
+}
+
+func TestLink_GroupConflicts(t *testing.T) {
+	initial := []byte(`
+home:
+  .config:
+    oldapp:
+      a: {type: file, content: "old a"}
+      nested:
+        b: {type: file, content: "old b"}
+  c: {type: file, content: "old c"}
+dots:
+  .config:
+    oldapp:
+      a: {type: file, content: "new a"}
+      nested:
+        b: {type: file, content: "new b"}
+  c: {type: file, content: "new c"}
+`)
+
+	expected := []byte(`
+home:
+  .config:
+    oldapp:
+      a: {type: symlink, target: ../../../dots/.config/oldapp/a}
+      nested:
+        b: {type: symlink, target: ../../../../dots/.config/oldapp/nested/b}
+  c: {type: file, content: "old c"}
+dots:
+  .config:
+    oldapp:
+      a: {type: file, content: "new a"}
+      nested:
+        b: {type: file, content: "new b"}
+  c: {type: file, content: "new c"}
+`)
+
+	// Groups are sorted by directory: home/ (skip), then home/.config/oldapp/ (overwrite)
+	stringutil.SetInput(strings.NewReader("s\no\n"))
+	defer stringutil.SetInput(os.Stdin)
+
+	testLinkCommand(t, initial, expected, "link", "home", "dots", "--rec", "--group")
+}
+
+func TestLink_JSONReasonCodes(t *testing.T) {
+	tmpDir := newTestDir(t)
+	err := ymlfs.FromYml(tmpDir, []byte(`
+home:
+  a: {type: file, content: "old a"}
+dots:
+  .git:
+    HEAD: {type: file, content: "ref"}
+  a: {type: file, content: "new a"}
+  b: {type: file, content: "b"}
+`))
+	require.NoError(t, err)
+
+	stringutil.SetInput(strings.NewReader("n\nn\n"))
+	defer stringutil.SetInput(os.Stdin)
+
+	out := runCommand(t, buildRootCmd(), "link", filepath.Join(tmpDir, "home"), filepath.Join(tmpDir, "dots"), "--rec", "--json")
+
+	var report Report
+	require.NoError(t, json.Unmarshal([]byte(out), &report))
+
+	reasons := map[string]ReasonCode{}
+	statuses := map[string]EntryStatus{}
+	for _, e := range report.Entries {
+		rel, _ := filepath.Rel(filepath.Join(tmpDir, "home"), e.LinkPath)
+		reasons[rel] = e.Reason
+		statuses[rel] = e.Status
+	}
+
+	require.Equal(t, ReasonIgnoredPattern, reasons[".git"])
+	require.Equal(t, ReasonConflictModified, reasons["a"])
+	require.Equal(t, StatusSkipped, statuses["a"])
+	require.Equal(t, StatusLinked, statuses["b"])
+}
+
+func TestLink_RelativeLinkStyle(t *testing.T) {
+	tmpDir := newTestDir(t)
+	err := ymlfs.FromYml(tmpDir, []byte(`
+home:
+dots:
+  a: {type: file, content: "a"}
+`))
+	require.NoError(t, err)
+
+	runCommand(t, buildRootCmd(), "link", filepath.Join(tmpDir, "home"), filepath.Join(tmpDir, "dots"), "--rec", "--link-style", "relative")
+	assertSymlink(t, filepath.Join(tmpDir, "home", "a"), "../dots/a")
+
+	// A relative link is recognized as already linked on the next run
+	out := runCommand(t, buildRootCmd(), "link", filepath.Join(tmpDir, "home"), filepath.Join(tmpDir, "dots"), "--rec", "--json")
+	require.Contains(t, out, `"status": "unchanged"`)
+}
+
+func TestLink_ConfigExceptionLinkStyle(t *testing.T) {
+	tmpDir := newTestDir(t)
+	err := ymlfs.FromYml(tmpDir, []byte(`
+home:
+dots:
+  lnkit.toml:
+    type: file
+    content: |
+      [options]
+      link_style = "relative"
+
+      [exceptions]
+      "nvim" = { target = ".config/nvim", link_style = "absolute" }
+  a: {type: file, content: "a"}
+  nvim:
+    init.lua: {type: file, content: "-- init"}
+`))
+	require.NoError(t, err)
+
+	home := filepath.Join(tmpDir, "home")
+	dots := filepath.Join(tmpDir, "dots")
+	runCommand(t, buildRootCmd(), "link", home, dots, "--rec")
+
+	assertSymlink(t, filepath.Join(home, "a"), "../dots/a")
+	assertSymlink(t, filepath.Join(home, ".config", "nvim"), filepath.Join(dots, "nvim"))
+	require.NoFileExists(t, filepath.Join(home, "lnkit.toml"))
+	require.NoDirExists(t, filepath.Join(home, "nvim"))
+}
+
+func TestUnlink_OnlyManaged(t *testing.T) {
+	tmpDir := newTestDir(t)
+	err := ymlfs.FromYml(tmpDir, []byte(`
+home:
+dots:
+  a: {type: file, content: "a"}
+  b: {type: file, content: "b"}
+`))
+	require.NoError(t, err)
+
+	home := filepath.Join(tmpDir, "home")
+	dots := filepath.Join(tmpDir, "dots")
+
+	// lnkit links a, the user links b by hand
+	require.NoError(t, os.Symlink(filepath.Join(dots, "b"), filepath.Join(home, "b")))
+	runCommand(t, buildRootCmd(), "link", home, dots, "--rec")
+
+	runCommand(t, buildRootCmd(), "unlink", home, dots)
+	matched, err := ymlfs.AssertStructure(home, `b: {type: symlink, target: ../dots/b}`)
+	require.NoError(t, err)
+	require.True(t, matched)
+
+	runCommand(t, buildRootCmd(), "unlink", home, dots, "--all")
+	matched, err = ymlfs.AssertStructure(home, `{}`)
+	require.NoError(t, err)
+	require.True(t, matched)
+}
+
+func TestState_ExportImport(t *testing.T) {
+	tmpDir := newTestDir(t)
+	err := ymlfs.FromYml(tmpDir, []byte(`
+home:
+  b: {type: file, content: "old b"}
+dots:
+  a: {type: file, content: "a"}
+  b: {type: file, content: "new b"}
+`))
+	require.NoError(t, err)
+
+	home := filepath.Join(tmpDir, "home")
+	dots := filepath.Join(tmpDir, "dots")
+
+	stringutil.SetInput(strings.NewReader("b\n"))
+	defer stringutil.SetInput(os.Stdin)
+	runCommand(t, buildRootCmd(), "link", home, dots, "--rec", "--batch", "1")
+
+	exported := runCommand(t, buildRootCmd(), "state", "export", home, dots)
+	require.Contains(t, exported, filepath.Join(home, "a"))
+	require.Contains(t, exported, filepath.Join(home, "b.bak"))
+
+	// On a "new machine", the imported manifest lets unlink recognize the links as managed
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	stateFile := filepath.Join(tmpDir, "state.json")
+	require.NoError(t, os.WriteFile(stateFile, []byte(exported), 0644))
+	runCommand(t, buildRootCmd(), "state", "import", stateFile)
+
+	runCommand(t, buildRootCmd(), "unlink", home, dots)
+	matched, err := ymlfs.AssertStructure(home, `b.bak: {type: file, content: "old b"}`)
+	require.NoError(t, err)
+	require.True(t, matched)
+}
+
+func TestRepo_LinkAndStatusAcrossRepos(t *testing.T) {
+	tmpDir := newTestDir(t)
+	err := ymlfs.FromYml(tmpDir, []byte(`
+home:
+  .gitconfig: {type: file, content: "mine"}
+dotfiles:
+  lnkit.toml: {type: file, content: "[options]\ntarget_dir = \"../home\"\n"}
+  .bashrc: {type: file, content: "bash"}
+work:
+  lnkit.toml: {type: file, content: "[options]\ntarget_dir = \"../home\"\n"}
+  .gitconfig: {type: file, content: "work"}
+`))
+	require.NoError(t, err)
+
+	home := filepath.Join(tmpDir, "home")
+	runCommand(t, buildRootCmd(), "repo", "add", filepath.Join(tmpDir, "dotfiles"), filepath.Join(tmpDir, "work"))
+
+	var results []repoReport
+	out := runCommand(t, buildRootCmd(), "repo", "status", "--json")
+	require.NoError(t, json.Unmarshal([]byte(out), &results))
+	require.Len(t, results, 2)
+	require.Equal(t, "1 pending", results[0].Report.Summary())
+	require.Equal(t, "1 conflict", results[1].Report.Summary())
+	require.NoFileExists(t, filepath.Join(home, ".bashrc"))
+
+	stringutil.SetInput(strings.NewReader("n\nn\n"))
+	defer stringutil.SetInput(os.Stdin)
+	runCommand(t, buildRootCmd(), "repo", "link")
+	assertSymlink(t, filepath.Join(home, ".bashrc"), filepath.Join(tmpDir, "dotfiles", ".bashrc"))
+	require.FileExists(t, filepath.Join(home, ".gitconfig"))
+}
+
+func TestLink_AltRoot(t *testing.T) {
+	root := newTestDir(t)
+	err := ymlfs.FromYml(root, []byte(`
+home:
+  me:
+    dotfiles:
+      .bashrc: {type: file, content: "bash"}
+`))
+	require.NoError(t, err)
+
+	link := filepath.Join(root, "home", "me", ".bashrc")
+	runCommand(t, buildRootCmd(), "link", "/home/me", "/home/me/dotfiles", "--rec", "--root", root)
+
+	// The link points where the file will be once root is mounted as /
+	target, err := os.Readlink(link)
+	require.NoError(t, err)
+	require.Equal(t, "/home/me/dotfiles/.bashrc", target)
+
+	// Running again recognizes the link instead of treating it as a conflict
+	var report Report
+	out := runCommand(t, buildRootCmd(), "link", "/home/me", "/home/me/dotfiles", "--rec", "--root", root, "--json")
+	require.NoError(t, json.Unmarshal([]byte(out), &report))
+	for _, e := range report.Entries {
+		if e.LinkPath == link {
+			require.Equal(t, StatusUnchanged, e.Status)
+		}
+	}
+
+	runCommand(t, buildRootCmd(), "unlink", "/home/me", "/home/me/dotfiles", "--root", root)
+	require.NoFileExists(t, link)
+}
+
+func TestLink_AltRootHome(t *testing.T) {
+	usr, err := user.Current()
+	if err != nil {
+		t.Skipf("no current user: %v", err)
+	}
+	root := newTestDir(t)
+	err = ymlfs.FromYml(root, []byte(`
+etc:
+  passwd: {type: file, content: "`+usr.Username+`:x:1000:1000::/home/inroot:/bin/sh\n"}
+home:
+  inroot:
+    dotfiles:
+      lnkit.toml: {type: file, content: "[exceptions]\nbashrc = \"~/.bashrc\"\n"}
+      bashrc: {type: file, content: "bash"}
+`))
+	require.NoError(t, err)
+
+	// ~ is the home directory inside the root, for arguments and exception targets alike
+	runCommand(t, buildRootCmd(), "link", "~", "~/dotfiles", "--rec", "--root", root)
+	target, err := os.Readlink(filepath.Join(root, "home", "inroot", ".bashrc"))
+	require.NoError(t, err)
+	require.Equal(t, "/home/inroot/dotfiles/bashrc", target)
+}
+
+func TestReadOnly_RefusesChanges(t *testing.T) {
+	tmpDir := newTestDir(t)
+	t.Cleanup(func() { fileutil.SetReadOnly(false) })
+	err := ymlfs.FromYml(tmpDir, []byte(`
+home:
+  b: {type: file, content: "old b"}
+dots:
+  a: {type: file, content: "a"}
+  b: {type: file, content: "new b"}
+`))
+	require.NoError(t, err)
+
+	home := filepath.Join(tmpDir, "home")
+	dots := filepath.Join(tmpDir, "dots")
+
+	// Even forcing the conflict doesn't touch the tree
+	var report Report
+	out, err := runCommandStdout(t, buildRootCmd(), "--read-only", "link", home, dots, "--rec", "--force", "--json")
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal([]byte(out), &report))
+	refused := 0
+	for _, e := range report.Entries {
+		if e.Status != StatusSkipped {
+			require.Equal(t, StatusFailed, e.Status)
+			require.Equal(t, ReasonReadOnly, e.Reason)
+			refused++
+		}
+	}
+	require.NotZero(t, refused)
+
+	matched, err := ymlfs.AssertStructure(home, `b: {type: file, content: "old b"}`)
+	require.NoError(t, err)
+	require.True(t, matched)
+
+	cmd := buildRootCmd()
+	cmd.SetArgs([]string{"--read-only", "repo", "add", dots})
+	require.ErrorIs(t, cmd.Execute(), fileutil.ErrReadOnly)
+
+	// Nor do commands that only write scratch trees of their own
+	for _, args := range [][]string{{"bench", "--files", "5"}, {"selftest", "--runs", "1", "--files", "5"}} {
+		_, err = runCommandStdout(t, buildRootCmd(), append([]string{"--read-only"}, args...)...)
+		require.ErrorIs(t, err, fileutil.ErrReadOnly)
+	}
+}
+
+func TestBench(t *testing.T) {
+	newTestDir(t)
+
+	var phases []benchPhase
+	out := runCommand(t, buildRootCmd(), "bench", "--files", "50", "--depth", "3", "--json")
+	require.NoError(t, json.Unmarshal([]byte(out), &phases))
+
+	require.Len(t, phases, 4)
+	for _, p := range phases {
+		require.Equal(t, 50, p.Entries, "phase %s", p.Phase)
+		require.Positive(t, p.Duration)
+	}
+}
+
+func TestSelftest_RoundTrip(t *testing.T) {
+	newTestDir(t)
+	for seed := int64(1); seed <= 20; seed++ {
+		require.NoError(t, checkRoundTrip(seed, 60, 4), "seed %d", seed)
+	}
+	runCommand(t, buildRootCmd(), "selftest", "--runs", "2", "--files", "20")
+}
+
+func TestUnlink_RemovesCreatedDirs(t *testing.T) {
+	tmpDir := newTestDir(t)
+	err := ymlfs.FromYml(tmpDir, []byte(`
+home:
+  .config:
+    other: {type: file, content: "mine"}
+dots:
+  .config:
+    nvim:
+      init.lua: {type: file, content: "lua"}
+`))
+	require.NoError(t, err)
+
+	home := filepath.Join(tmpDir, "home")
+	dots := filepath.Join(tmpDir, "dots")
+	runCommand(t, buildRootCmd(), "link", home, dots, "--rec")
+	assertSymlink(t, filepath.Join(home, ".config", "nvim", "init.lua"), filepath.Join(dots, ".config", "nvim", "init.lua"))
+
+	// nvim/ was created for the link and goes with it; .config/ was already there
+	runCommand(t, buildRootCmd(), "unlink", home, dots)
+	matched, err := ymlfs.AssertStructure(home, `
+.config:
+  other: {type: file, content: "mine"}
+`)
+	require.NoError(t, err)
+	require.True(t, matched)
+}
+
+func TestLink_ConfigExceptionPerOSTargets(t *testing.T) {
+	tmpDir := newTestDir(t)
+	err := ymlfs.FromYml(tmpDir, []byte(`
+home:
+dots:
+  lnkit.toml:
+    type: file
+    content: |
+      [exceptions]
+      "foo" = { targets = [{ os = "plan9", path = "lib/foo" }, { path = ".config/foo" }] }
+      "bar" = { targets = [{ os = "plan9", path = "lib/bar" }] }
+  foo: {type: file, content: "foo"}
+  bar: {type: file, content: "bar"}
+`))
+	require.NoError(t, err)
+
+	home := filepath.Join(tmpDir, "home")
+	dots := filepath.Join(tmpDir, "dots")
+
+	var report Report
+	out := runCommand(t, buildRootCmd(), "link", home, dots, "--rec", "--json")
+	require.NoError(t, json.Unmarshal([]byte(out), &report))
+
+	assertSymlink(t, filepath.Join(home, ".config", "foo"), filepath.Join(dots, "foo"))
+	require.NoFileExists(t, filepath.Join(home, "bar"))
+	for _, e := range report.Entries {
+		if e.TargetPath == filepath.Join(dots, "bar") {
+			require.Equal(t, ReasonNoTarget, e.Reason)
+		}
+	}
+}
+
+func TestLoadConfig_RejectsBadExceptionKeys(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, configFile)
+	for exception, want := range map[string]string{
+		`nvim = { target = "~/.config/nvim", priority = "high" }`:           `exception key "priority" must be an integer, got string`,
+		`nvim = { target = "~/.config/nvim", sha256 = 1 }`:                  `exception key "sha256" must be a string, got int64`,
+		`nvim = { tagret = "~/.config/nvim" }`:                              `unknown exception key "tagret"`,
+		`nvim = { targets = [{ path = "~/.config/nvim", sys = "linux" }] }`: `unknown key "sys" in exception target 1`,
+	} {
+		require.NoError(t, os.WriteFile(path, []byte("[exceptions]\n"+exception+"\n"), 0644))
+		_, err := loadConfig(path, dir)
+		require.ErrorContains(t, err, want, exception)
+	}
+}
+
+func TestLink_TracksRenamedSource(t *testing.T) {
+	tmpDir := newTestDir(t)
+	err := ymlfs.FromYml(tmpDir, []byte(`
+home:
+dots:
+  old.conf: {type: file, content: "settings"}
+  other: {type: file, content: "other"}
+  a.empty: {type: file, content: ""}
+  b.empty: {type: file, content: ""}
+`))
+	require.NoError(t, err)
+
+	home := filepath.Join(tmpDir, "home")
+	dots := filepath.Join(tmpDir, "dots")
+	runCommand(t, buildRootCmd(), "link", home, dots, "--rec")
+
+	// Empty files all look alike, so replacing two with another isn't a rename
+	require.NoError(t, os.Rename(filepath.Join(dots, "old.conf"), filepath.Join(dots, "new.conf")))
+	require.NoError(t, os.Remove(filepath.Join(dots, "a.empty")))
+	require.NoError(t, os.Remove(filepath.Join(dots, "b.empty")))
+	require.NoError(t, os.WriteFile(filepath.Join(dots, "c.empty"), nil, 0644))
+
+	var report Report
+	out, err := runCommandStdout(t, buildRootCmd(), "link", home, dots, "--rec", "--json")
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal([]byte(out), &report))
+	require.Equal(t, "1 linked, 1 renamed, 1 unchanged", report.Summary())
+	for _, e := range report.Entries {
+		if e.Status == StatusRenamed {
+			require.Equal(t, filepath.Join(home, "old.conf"), e.RenamedFrom)
+		}
+	}
+
+	matched, err := ymlfs.AssertStructure(home, `
+new.conf: {type: symlink, target: ../dots/new.conf}
+other: {type: symlink, target: ../dots/other}
+a.empty: {type: symlink, target: ../dots/a.empty}
+b.empty: {type: symlink, target: ../dots/b.empty}
+c.empty: {type: symlink, target: ../dots/c.empty}
+`)
+	require.NoError(t, err)
+	require.True(t, matched)
+}
+
+func TestLink_ConfigDirMode(t *testing.T) {
+	tmpDir := newTestDir(t)
+	t.Cleanup(func() { fileutil.SetDirMode(fileutil.DefaultDirMode) })
+	err := ymlfs.FromYml(tmpDir, []byte(`
+home:
+dots:
+  lnkit.toml: {type: file, content: "[options]\ndir_mode = \"0700\"\n"}
+  .config:
+    app.conf: {type: file, content: "conf"}
+`))
+	require.NoError(t, err)
+
+	home := filepath.Join(tmpDir, "home")
+	dots := filepath.Join(tmpDir, "dots")
+	runCommand(t, buildRootCmd(), "link", home, dots, "--rec")
+
+	info, err := os.Stat(filepath.Join(home, ".config"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0700), info.Mode().Perm())
+
+	runCommand(t, buildRootCmd(), "doctor", dots)
+}
+
+func TestLink_BaseRepoOverlay(t *testing.T) {
+	tmpDir := newTestDir(t)
+	err := ymlfs.FromYml(tmpDir, []byte(`
+home:
+company:
+  .gitconfig: {type: file, content: "shared"}
+  .editorconfig: {type: file, content: "shared"}
+dots:
+  lnkit.toml: {type: file, content: "[options]\nbase = \"../company\"\n"}
+  .gitconfig: {type: file, content: "mine"}
+  .bashrc: {type: file, content: "mine"}
+`))
+	require.NoError(t, err)
+
+	home := filepath.Join(tmpDir, "home")
+	company := filepath.Join(tmpDir, "company")
+	dots := filepath.Join(tmpDir, "dots")
+
+	var report Report
+	out, err := runCommandStdout(t, buildRootCmd(), "link", home, dots, "--rec", "--json")
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal([]byte(out), &report))
+
+	assertSymlink(t, filepath.Join(home, ".gitconfig"), filepath.Join(dots, ".gitconfig"))
+	assertSymlink(t, filepath.Join(home, ".bashrc"), filepath.Join(dots, ".bashrc"))
+	assertSymlink(t, filepath.Join(home, ".editorconfig"), filepath.Join(company, ".editorconfig"))
+	shadowed := false
+	for _, e := range report.Entries {
+		if e.TargetPath == filepath.Join(company, ".gitconfig") {
+			require.Equal(t, ReasonShadowed, e.Reason)
+			shadowed = true
+		}
+	}
+	require.True(t, shadowed)
+
+	ignore, err := fileutil.NewIgnoreSet("config", "lnkit.toml")
+	require.NoError(t, err)
+	entries, err := diffOverlay(company, dots, ignore)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []overlayEntry{
+		{rel: ".editorconfig", status: "shared only"},
+		{rel: ".gitconfig", status: "overridden"},
+		{rel: ".bashrc", status: "personal only"},
+	}, entries)
+	out, err = runCommandStdout(t, buildRootCmd(), "overlay", "diff", "--patch", dots)
+	require.NoError(t, err)
+	require.Contains(t, out, ".gitconfig ")
+	require.Contains(t, out, " overridden\n")
+	require.Contains(t, stringutil.StripANSI(out), "+mine")
+
+	runCommand(t, buildRootCmd(), "unlink", home, dots)
+	matched, err := ymlfs.AssertStructure(home, `{}`)
+	require.NoError(t, err)
+	require.True(t, matched)
+}
+
+func TestLink_RecordAndReplay(t *testing.T) {
+	tmpDir := newTestDir(t)
+	err := ymlfs.FromYml(tmpDir, []byte(`
+home:
+  a: {type: file, content: "old a"}
+  unrelated: {type: file, content: "secret"}
+dots:
+  lnkit.toml: {type: file, content: "[options]\nlink_style = \"relative\"\n"}
+  .git:
+    HEAD: {type: file, content: "ref"}
+  a: {type: file, content: "new a"}
+  b: {type: file, content: "b"}
+`))
+	require.NoError(t, err)
+
+	home := filepath.Join(tmpDir, "home")
+	dots := filepath.Join(tmpDir, "dots")
+	bundle := filepath.Join(tmpDir, "bug.tar.gz")
+
+	// Don't preview the conflict, delete it
+	stringutil.SetInput(strings.NewReader("n\ny\n"))
+	defer stringutil.SetInput(os.Stdin)
+	runCommand(t, buildRootCmd(), "link", home, dots, "--rec", "--record", bundle, "--redact")
+	require.True(t, fileutil.IsSymlink(filepath.Join(home, "a")))
+
+	files, err := readBundle(bundle)
+	require.NoError(t, err)
+	require.Contains(t, string(files[bundleConfig]), "relative")
+	require.Contains(t, string(files[bundleSource]), "relative") // The config isn't redacted
+	require.NotContains(t, string(files[bundleSource]), "new a")
+	require.NotContains(t, string(files[bundleSource]), "ref")
+	require.NotContains(t, string(files[bundleLinks]), "unrelated")
+	require.Equal(t, "n\ny\n", string(files[bundleInput]))
+
+	diffs, err := replayBundle(bundle, t.TempDir())
+	require.NoError(t, err)
+	require.Empty(t, diffs)
+
+	out, err := runCommandStdout(t, buildRootCmd(), "replay", bundle)
+	require.NoError(t, err)
+	require.Equal(t, "Replay matches the recorded run\n", out)
+}
+
+func TestReplay_DistrustsBundle(t *testing.T) {
+	tmpDir := newTestDir(t)
+	bundle := filepath.Join(tmpDir, "evil.tar.gz")
+	logPath := filepath.Join(tmpDir, "evil.log")
+	writeEvil := func(meta recordMeta, config string) {
+		metaData, err := json.Marshal(meta)
+		require.NoError(t, err)
+		data, err := writeBundle(map[string][]byte{
+			bundleMeta:   metaData,
+			bundleConfig: []byte(config),
+			bundleSource: []byte("a: {type: file, content: \"a\"}\n"),
+			bundleReport: []byte("{}"),
+		})
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(bundle, data, 0644))
+	}
+
+	// Paths can't leave the replay's directory
+	writeEvil(recordMeta{LinkPath: "/home", TargetPath: "/../../dots"}, "")
+	_, err := replayBundle(bundle, t.TempDir())
+	require.ErrorContains(t, err, "invalid path")
+
+	// Only link flags are replayed, and the config can't run or fetch anything
+	writeEvil(recordMeta{LinkPath: "/home", TargetPath: "/dots", Args: []string{"--rec=true", "--log-file=" + logPath}},
+		"[options]\ndiff_tool = \"vimdiff\"\nbase = \"/etc\"\n[exceptions]\na = { target = \"a\", source_url = \"http://example.com\" }\n")
+	dir := t.TempDir()
+	_, err = replayBundle(bundle, dir)
+	require.NoError(t, err)
+	require.NoFileExists(t, logPath)
+	config, err := os.ReadFile(filepath.Join(dir, bundleConfig))
+	require.NoError(t, err)
+	require.NotContains(t, string(config), "diff_tool")
+	require.NotContains(t, string(config), "base")
+	require.NotContains(t, string(config), "source_url")
+	require.Contains(t, string(config), "target")
+}
+
+func TestRepo_StatusReportsPackageHints(t *testing.T) {
+	tmpDir := newTestDir(t)
+	err := ymlfs.FromYml(tmpDir, []byte(`
+home:
+dotfiles:
+  lnkit.toml: {type: file, content: "[options]\ntarget_dir = \"../home\"\n[exceptions]\nnvim = { target = \"nvim\", requires_pkg = \"neovim\" }\nrg = { target = \"rg\", requires_pkg = \"ripgrep\" }\ngit = { target = \"git\", requires_pkg = \"git\" }\n"}
+  nvim:
+    init.lua: {type: file, content: "vim"}
+  rg: {type: file, content: "rg"}
+  git: {type: file, content: "git"}
+`))
+	require.NoError(t, err)
+
+	defer func(orig func(string) bool) { pkgInstalled = orig }(pkgInstalled)
+	pkgInstalled = func(pkg string) bool { return pkg != "neovim" }
+
+	home := filepath.Join(tmpDir, "home")
+	dotfiles := filepath.Join(tmpDir, "dotfiles")
+	runCommand(t, buildRootCmd(), "repo", "add", dotfiles)
+	runCommand(t, buildRootCmd(), "repo", "link")
+	require.NoError(t, os.Remove(filepath.Join(home, "rg")))
+
+	var results []repoReport
+	out := runCommand(t, buildRootCmd(), "repo", "status", "--json")
+	require.NoError(t, json.Unmarshal([]byte(out), &results))
+	require.Len(t, results, 1)
+	require.Equal(t, []pkgHint{
+		{Source: "nvim", Package: "neovim", LinkPath: filepath.Join(home, "nvim"), Linked: true, Installed: false},
+		{Source: "rg", Package: "ripgrep", LinkPath: filepath.Join(home, "rg"), Linked: false, Installed: true},
+	}, results[0].Packages)
+
+	runCommand(t, buildRootCmd(), "doctor", dotfiles)
+}
+
+func TestGC_RemovesStaleStateAndBackups(t *testing.T) {
+	tmpDir := newTestDir(t)
+	err := ymlfs.FromYml(tmpDir, []byte(`
+home:
+  a: {type: file, content: "old a"}
+  b: {type: file, content: "old b"}
+dots:
+  a: {type: file, content: "new a"}
+  b: {type: file, content: "new b"}
+`))
+	require.NoError(t, err)
+
+	home := filepath.Join(tmpDir, "home")
+	dots := filepath.Join(tmpDir, "dots")
+	stringutil.SetInput(strings.NewReader("b\n"))
+	defer stringutil.SetInput(os.Stdin)
+	runCommand(t, buildRootCmd(), "link", home, dots, "--rec", "--batch", "2")
+	require.FileExists(t, filepath.Join(home, "a.bak"))
+
+	// a's source is gone, so its backup is stale; b's is still in use. While a is
+	// empty, its backup could be restored, so it's kept
+	require.NoError(t, os.Remove(filepath.Join(dots, "a")))
+	require.NoError(t, os.Remove(filepath.Join(home, "a")))
+
+	result, err := collectGarbage(gcOptions{backups: true, force: true})
+	require.NoError(t, err)
+	require.Empty(t, result.Backups)
+	require.Equal(t, 1, result.KeptBackups)
+	require.FileExists(t, filepath.Join(home, "a.bak"))
+
+	require.NoError(t, os.WriteFile(filepath.Join(home, "a"), []byte("newer a"), 0644))
+	result, err = collectGarbage(gcOptions{dryRun: true, backups: true})
+	require.NoError(t, err)
+	require.Equal(t, []string{filepath.Join(home, "a.bak")}, result.Backups)
+	require.FileExists(t, filepath.Join(home, "a.bak"))
+
+	// Backups are only deleted when asked to, and confirmed
+	runCommand(t, buildRootCmd(), "gc")
+	require.FileExists(t, filepath.Join(home, "a.bak"))
+	stringutil.SetInput(strings.NewReader("n\n"))
+	runCommand(t, buildRootCmd(), "gc", "--backups")
+	require.FileExists(t, filepath.Join(home, "a.bak"))
+	runCommand(t, buildRootCmd(), "gc", "--backups", "--force")
+	require.NoFileExists(t, filepath.Join(home, "a.bak"))
+	require.FileExists(t, filepath.Join(home, "b.bak"))
+
+	m, err := loadManifest(home, dots)
+	require.NoError(t, err)
+	require.Len(t, m.Links, 1)
+	require.Len(t, m.Backups, 1)
+
+	// Once the source is gone entirely, so is its manifest
+	runCommand(t, buildRootCmd(), "unlink", home, dots)
+	require.NoError(t, os.RemoveAll(dots))
+	require.NoError(t, os.Remove(filepath.Join(home, "b.bak")))
+	result, err = collectGarbage(gcOptions{})
+	require.NoError(t, err)
+	require.Equal(t, 1, result.Manifests)
+	paths, err := manifest.All()
+	require.NoError(t, err)
+	require.Empty(t, paths)
+}
+
+func TestEnsure_QuietUnlessChangedOrFailed(t *testing.T) {
+	tmpDir := newTestDir(t)
+	err := ymlfs.FromYml(tmpDir, []byte(`
+home:
+dots:
+  a: {type: file, content: "a"}
+  b: {type: file, content: "b"}
+`))
+	require.NoError(t, err)
+
+	home := filepath.Join(tmpDir, "home")
+	dots := filepath.Join(tmpDir, "dots")
+
+	out := runCommand(t, buildRootCmd(), "ensure", home, dots, "--rec")
+	require.Equal(t, 1, strings.Count(out, "\n"))
+	require.Contains(t, out, "2 changed")
+	assertSymlink(t, filepath.Join(home, "a"), filepath.Join(dots, "a"))
+
+	out = runCommand(t, buildRootCmd(), "ensure", home, dots, "--rec")
+	require.Empty(t, out)
+
+	// A rename is still a single line, and logs the user asked for are kept
+	t.Cleanup(func() {
+		logSettings.output, logSettings.color = "stderr", "auto"
+		InitLogger("Fatal")
+	})
+	logPath := filepath.Join(tmpDir, "lnk.log")
+	require.NoError(t, os.Rename(filepath.Join(dots, "a"), filepath.Join(dots, "renamed")))
+	out = runCommand(t, buildRootCmd(), "--log-level", "info", "--log-file", logPath, "ensure", home, dots, "--rec")
+	require.Equal(t, 1, strings.Count(out, "\n"))
+	require.Contains(t, out, "1 changed")
+	logs, err := os.ReadFile(logPath)
+	require.NoError(t, err)
+	require.NotEmpty(t, logs)
+
+	// Something in the way fails the run without changing anything
+	require.NoError(t, os.WriteFile(filepath.Join(dots, "c"), []byte("new c"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(home, "c"), []byte("old c"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dots, "d"), []byte("d"), 0644))
+
+	var stdout, stderr bytes.Buffer
+	cmd := buildRootCmd()
+	cmd.SetOut(&stdout)
+	cmd.SetErr(&stderr)
+	cmd.SetArgs([]string{"ensure", home, dots, "--rec"})
+	require.Error(t, cmd.Execute())
+	require.Empty(t, stdout.String())
+	require.Contains(t, stderr.String(), string(ReasonConflictModified))
+	require.NoFileExists(t, filepath.Join(home, "d"))
+
+	out = runCommand(t, buildRootCmd(), "ensure", home, dots, "--rec", "--force")
+	require.Contains(t, out, "2 changed")
+	assertSymlink(t, filepath.Join(home, "c"), filepath.Join(dots, "c"))
+}
+
+func TestSemanticDiff(t *testing.T) {
+	tmpDir := newTestDir(t)
+	err := ymlfs.FromYml(tmpDir, []byte(`
+old.json: {type: file, content: "{\"a\": 1, \"b\": {\"c\": [1, 2]}, \"gone\": true}"}
+reordered.json: {type: file, content: "{\n  \"gone\": true,\n  \"b\": {\"c\": [1, 2]},\n  \"a\": 1\n}\n"}
+changed.json: {type: file, content: "{\"a\": 2, \"b\": {\"c\": [1, 3]}, \"new\": \"x\"}"}
+old.toml: {type: file, content: "[ui]\ntheme = \"dark\"\nsize = 12\n"}
+new.toml: {type: file, content: "[ui]\nsize = 12\ntheme = \"light\"\n"}
+`))
+	require.NoError(t, err)
+
+	parse := func(name string) any {
+		v, err := parseStructured(filepath.Join(tmpDir, name), structuredFormat(name))
+		require.NoError(t, err)
+		return v
+	}
+
+	require.Empty(t, semanticDiff(parse("old.json"), parse("reordered.json")))
+	require.Equal(t, []string{
+		"~ a: 1 → 2",
+		"~ b.c[1]: 2 → 3",
+		"- gone = true",
+		"+ new = \"x\"",
+	}, semanticDiff(parse("old.json"), parse("changed.json")))
+	require.Equal(t, []string{`~ ui.theme: "dark" → "light"`}, semanticDiff(parse("old.toml"), parse("new.toml")))
+	require.Equal(t, "", structuredFormat("notes.txt"))
+}
+
+func TestLink_PreviewBinaryConflict(t *testing.T) {
+	tmpDir := newTestDir(t)
+	home := filepath.Join(tmpDir, "home")
+	dots := filepath.Join(tmpDir, "dots")
+	require.NoError(t, os.MkdirAll(home, 0755))
+	require.NoError(t, os.MkdirAll(dots, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(home, "font.ttf"), []byte{0, 1, 2, 3}, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dots, "font.ttf"), []byte{0, 1, 2, 4}, 0644))
+	require.True(t, isBinary(filepath.Join(dots, "font.ttf")))
+
+	// Preview, then keep the existing file, with both kinds of binary preview
+	for _, args := range [][]string{{}, {"--hexdiff"}} {
+		stringutil.SetInput(strings.NewReader("y\nn\n"))
+		runCommand(t, buildRootCmd(), append([]string{"link", home, dots, "--rec"}, args...)...)
+		require.False(t, fileutil.IsSymlink(filepath.Join(home, "font.ttf")))
+	}
+	stringutil.SetInput(os.Stdin)
+}
+
+func TestDiffer_AllowlistAndFallback(t *testing.T) {
+	for _, opts := range []Options{
+		{DiffTool: "sh"},
+		{DiffTool: "git", DiffArgs: []string{"-c", "core.pager=sh", "{old}", "{new}"}},
+		{DiffTool: "git", DiffArgs: []string{"diff", "--ext-diff", "{old}", "{new}"}},
+		{DiffTool: "diff", DiffArgs: []string{"-u", "{old}"}},
+		{DiffTool: builtinDiffer, DiffArgs: []string{"{old}", "{new}"}},
+	} {
+		_, err := opts.differ()
+		require.Error(t, err, "%+v", opts)
+	}
+	tool, err := Options{DiffTool: "delta", DiffArgs: []string{"--side-by-side", "{old}", "{new}"}}.differ()
+	require.NoError(t, err)
+	require.Equal(t, []string{"--side-by-side", "a", "b"}, tool.command("a", "b"))
+
+	dir := newTestDir(t)
+	old := filepath.Join(dir, "old")
+	new := filepath.Join(dir, "new")
+	require.NoError(t, os.WriteFile(old, []byte("1\n2\n3\n4\n5\n6\n7\n8\n9\n"), 0644))
+	require.NoError(t, os.WriteFile(new, []byte("1\n2\n3\n4\n5\n6\n7\n8\nnine\n"), 0644))
+
+	var out bytes.Buffer
+	require.NoError(t, builtinDiff(&out, old, new))
+	require.Equal(t, []string{"--- " + old, "+++ " + new, "@@", " 6", " 7", " 8", "-9", "+nine", " "},
+		strings.Split(strings.TrimSuffix(stringutil.StripANSI(out.String()), "\n"), "\n"))
+
+	// A tool that isn't installed falls back to the built-in differ
+	defer func(orig diffTool) { differ = orig }(differ)
+	differ = diffTool{name: "lnkit-no-such-differ", args: []string{"{old}", "{new}"}}
+	out.Reset()
+	require.NoError(t, PreviewDiff(&out, old, new))
+	require.Contains(t, stringutil.StripANSI(out.String()), "+nine")
+}
+
+func TestLink_FollowUps(t *testing.T) {
+	tmpDir := newTestDir(t)
+	err := ymlfs.FromYml(tmpDir, []byte(`
+home:
+dots:
+  lnkit.toml: {type: file, content: "[follow_ups]\n\"nvim\" = \"Run :PlugInstall in nvim\"\n\".tmux.conf\" = \"Restart the tmux server\"\n\"zsh\" = \"Open a new shell\"\n"}
+  .tmux.conf: {type: file, content: "tmux"}
+  nvim:
+    init.lua: {type: file, content: "vim"}
+    plugins.lua: {type: file, content: "plugins"}
+`))
+	require.NoError(t, err)
+
+	home := filepath.Join(tmpDir, "home")
+	dots := filepath.Join(tmpDir, "dots")
+
+	var report Report
+	out := runCommand(t, buildRootCmd(), "link", home, dots, "--rec", "--json")
+	require.NoError(t, json.Unmarshal([]byte(out), &report))
+	require.Equal(t, []string{"Restart the tmux server", "Run :PlugInstall in nvim"}, report.FollowUps)
+
+	// Nothing new was linked, so there is nothing to do
+	report = Report{}
+	out = runCommand(t, buildRootCmd(), "link", home, dots, "--rec", "--json")
+	require.NoError(t, json.Unmarshal([]byte(out), &report))
+	require.Empty(t, report.FollowUps)
+}
+
+func TestList_RecordsCreationAndVerification(t *testing.T) {
+	tmpDir := newTestDir(t)
+	err := ymlfs.FromYml(tmpDir, []byte(`
+home:
+dots:
+  a: {type: file, content: "a"}
+  b: {type: file, content: "b"}
+`))
+	require.NoError(t, err)
+
+	home := filepath.Join(tmpDir, "home")
+	dots := filepath.Join(tmpDir, "dots")
+	runCommand(t, buildRootCmd(), "link", home, dots, "--rec")
+
+	// Backdate a's record, as if it was linked long ago and never checked since
+	m, err := loadManifest(home, dots)
+	require.NoError(t, err)
+	old := time.Now().Add(-90 * 24 * time.Hour).UTC().Truncate(time.Second)
+	r := m.Links[filepath.Join(home, "a")]
+	require.NotNil(t, r.Created)
+	r.Created, r.Verified = &old, &old
+	m.Links[r.LinkPath] = r
+	require.NoError(t, m.Save())
+	require.Equal(t, "unknown", formatAge(nil, time.Now()))
+	require.Contains(t, formatAge(&old, time.Now()), "(90d ago)")
+
+	// Linking again verifies a without changing when it was created
+	runCommand(t, buildRootCmd(), "link", home, dots, "--rec")
+	m, err = loadManifest(home, dots)
+	require.NoError(t, err)
+	r = m.Links[filepath.Join(home, "a")]
+	require.Equal(t, old, *r.Created)
+	require.True(t, r.Verified.After(old))
+
+	require.NoError(t, os.Remove(filepath.Join(dots, "b")))
+	require.Equal(t, "broken", linkCondition(m.Links[filepath.Join(home, "b")]))
+	runCommand(t, buildRootCmd(), "list", "--long", home, dots)
+}
+
+func TestLink_GitExportIgnore(t *testing.T) {
+	tmpDir := newTestDir(t)
+	err := ymlfs.FromYml(tmpDir, []byte(`
+home:
+dots:
+  lnkit.toml: {type: file, content: "[options]\ngit_export_ignore = true\n"}
+  .gitattributes: {type: file, content: "README.md export-ignore\n/docs/** export-ignore\nbin/dev.sh export-ignore\n"}
+  README.md: {type: file, content: "readme"}
+  .bashrc: {type: file, content: "bash"}
+  docs:
+    setup.md: {type: file, content: "docs"}
+  bin:
+    dev.sh: {type: file, content: "dev"}
+    tool: {type: file, content: "tool"}
+`))
+	require.NoError(t, err)
+
+	home := filepath.Join(tmpDir, "home")
+	dots := filepath.Join(tmpDir, "dots")
+	runCommand(t, buildRootCmd(), "link", home, dots, "--rec")
+
+	assertSymlink(t, filepath.Join(home, ".bashrc"), filepath.Join(dots, ".bashrc"))
+	assertSymlink(t, filepath.Join(home, "bin", "tool"), filepath.Join(dots, "bin", "tool"))
+	require.NoFileExists(t, filepath.Join(home, "README.md"))
+	require.NoDirExists(t, filepath.Join(home, "docs"))
+	require.NoFileExists(t, filepath.Join(home, "bin", "dev.sh"))
+}
+
+func TestLink_RequireCleanSource(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git isn't installed")
+	}
+
+	tmpDir := newTestDir(t)
+	err := ymlfs.FromYml(tmpDir, []byte(`
+home:
+  .bashrc: {type: file, content: "old"}
+dots:
+  lnkit.toml: {type: file, content: "[options]\nrequire_clean_source = true\n"}
+  .bashrc: {type: file, content: "bash"}
+`))
+	require.NoError(t, err)
+
+	home := filepath.Join(tmpDir, "home")
+	dots := filepath.Join(tmpDir, "dots")
+	git := func(args ...string) {
+		args = append([]string{"-C", dots, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)
+		out, err := exec.Command("git", args...).CombinedOutput()
+		require.NoError(t, err, string(out))
+	}
+	git("init", "-q")
+	git("add", "-A")
+	git("commit", "-q", "-m", "init")
+
+	// Uncommitted changes refuse --force
+	require.NoError(t, os.WriteFile(filepath.Join(dots, ".bashrc"), []byte("half-edited"), 0644))
+	cmd := buildRootCmd()
+	cmd.SetArgs([]string{"link", home, dots, "--rec", "--force"})
+	require.ErrorContains(t, cmd.Execute(), "uncommitted changes")
+	require.False(t, fileutil.IsSymlink(filepath.Join(home, ".bashrc")))
+
+	// Warn mode goes ahead
+	require.NoError(t, os.WriteFile(filepath.Join(dots, "lnkit.toml"), []byte("[options]\nrequire_clean_source = \"warn\"\n"), 0644))
+	runCommand(t, buildRootCmd(), "link", home, dots, "--rec", "--force")
+	assertSymlink(t, filepath.Join(home, ".bashrc"), filepath.Join(dots, ".bashrc"))
+}
+
+func TestLink_ChecksFreeSpaceFirst(t *testing.T) {
+	tmpDir := newTestDir(t)
+	err := ymlfs.FromYml(tmpDir, []byte(`
+home:
+dots:
+  .bashrc: {type: file, content: "bash"}
+  .config:
+    nvim:
+      init.lua: {type: file, content: "nvim"}
+`))
+	require.NoError(t, err)
+
+	home := filepath.Join(tmpDir, "home")
+	dots := filepath.Join(tmpDir, "dots")
+	defer func(orig func(string) (fileutil.Space, bool, error)) { diskSpace = orig }(diskSpace)
+
+	// Two links and two directories don't fit in three blocks
+	diskSpace = func(string) (fileutil.Space, bool, error) {
+		return fileutil.Space{BlockSize: 4096, Total: 1 << 30, Available: 3 * 4096}, true, nil
+	}
+	cmd := buildRootCmd()
+	cmd.SetArgs([]string{"link", home, dots, "--rec"})
+	require.ErrorContains(t, cmd.Execute(), "not enough space")
+	require.NoFileExists(t, filepath.Join(home, ".bashrc"))
+
+	diskSpace = func(string) (fileutil.Space, bool, error) {
+		return fileutil.Space{BlockSize: 4096, Total: 1 << 30, Available: 1 << 29, TotalInodes: 100, FreeInodes: 3}, true, nil
+	}
+	cmd = buildRootCmd()
+	cmd.SetArgs([]string{"link", home, dots, "--rec"})
+	require.ErrorContains(t, cmd.Execute(), "not enough inodes")
+
+	diskSpace = func(string) (fileutil.Space, bool, error) {
+		return fileutil.Space{BlockSize: 4096, Total: 1 << 30, Available: 1 << 29}, true, nil
+	}
+	runCommand(t, buildRootCmd(), "link", home, dots, "--rec")
+	assertSymlink(t, filepath.Join(home, ".bashrc"), filepath.Join(dots, ".bashrc"))
+	assertSymlink(t, filepath.Join(home, ".config", "nvim", "init.lua"), filepath.Join(dots, ".config", "nvim", "init.lua"))
+}
+
+func TestLink_Subdir(t *testing.T) {
+	tmpDir := newTestDir(t)
+	err := ymlfs.FromYml(tmpDir, []byte(`
+home:
+dots:
+  .bashrc: {type: file, content: "bash"}
+  .config:
+    nvim:
+      init.lua: {type: file, content: "nvim"}
+      lua:
+        plugins.lua: {type: file, content: "plugins"}
+    git:
+      config: {type: file, content: "git"}
+`))
+	require.NoError(t, err)
+
+	home := filepath.Join(tmpDir, "home")
+	dots := filepath.Join(tmpDir, "dots")
+	runCommand(t, buildRootCmd(), "link", home, dots, "--rec", "--subdir", ".config/nvim")
+
+	assertSymlink(t, filepath.Join(home, ".config", "nvim", "init.lua"), filepath.Join(dots, ".config", "nvim", "init.lua"))
+	assertSymlink(t, filepath.Join(home, ".config", "nvim", "lua", "plugins.lua"), filepath.Join(dots, ".config", "nvim", "lua", "plugins.lua"))
+	require.NoFileExists(t, filepath.Join(home, ".bashrc"))
+	require.NoDirExists(t, filepath.Join(home, ".config", "git"))
+
+	// Without --rec the subdirectory is linked whole
+	home2 := filepath.Join(tmpDir, "home2")
+	require.NoError(t, os.Mkdir(home2, 0755))
+	runCommand(t, buildRootCmd(), "link", home2, dots, "--subdir", ".config/git")
+	assertSymlink(t, filepath.Join(home2, ".config", "git"), filepath.Join(dots, ".config", "git"))
+
+	runCommand(t, buildRootCmd(), "unlink", home, dots, "--subdir", ".config/nvim/lua")
+	require.NoFileExists(t, filepath.Join(home, ".config", "nvim", "lua", "plugins.lua"))
+	assertSymlink(t, filepath.Join(home, ".config", "nvim", "init.lua"), filepath.Join(dots, ".config", "nvim", "init.lua"))
+
+	cmd := buildRootCmd()
+	cmd.SetArgs([]string{"link", home, dots, "--subdir", "../elsewhere"})
+	require.ErrorContains(t, cmd.Execute(), "inside the source")
+}
+
+func TestList_ForeignIgnoresTargetJunk(t *testing.T) {
+	tmpDir := newTestDir(t)
+	err := ymlfs.FromYml(tmpDir, []byte(`
+home:
+dots:
+  .config:
+    nvim:
+      init.lua: {type: file, content: "nvim"}
+`))
+	require.NoError(t, err)
+
+	home := filepath.Join(tmpDir, "home")
+	dots := filepath.Join(tmpDir, "dots")
+	runCommand(t, buildRootCmd(), "link", home, dots, "--rec")
+
+	nvim := filepath.Join(home, ".config", "nvim")
+	for _, name := range []string{".DS_Store", ".init.lua.swp", "notes.txt"} {
+		require.NoError(t, os.WriteFile(filepath.Join(nvim, name), nil, 0644))
+	}
+
+	out := runCommand(t, buildRootCmd(), "list", "--foreign", home, dots)
+	require.Equal(t, filepath.Join(nvim, "notes.txt")+"\n", out)
+
+	// The list replaces the defaults
+	require.NoError(t, os.WriteFile(filepath.Join(dots, "lnkit.toml"), []byte("[options]\ntarget_ignore = [\"*.txt\"]\n"), 0644))
+	out = runCommand(t, buildRootCmd(), "list", "--foreign", home, dots)
+	require.Equal(t, filepath.Join(nvim, ".DS_Store")+"\n"+filepath.Join(nvim, ".init.lua.swp")+"\n", out)
+}
+
+func TestLink_DryRunExitCode(t *testing.T) {
+	tmpDir := newTestDir(t)
+	err := ymlfs.FromYml(tmpDir, []byte(`
+home:
+dots:
+  .bashrc: {type: file, content: "bash"}
+`))
+	require.NoError(t, err)
+
+	home := filepath.Join(tmpDir, "home")
+	dots := filepath.Join(tmpDir, "dots")
+
+	var out bytes.Buffer
+	cmd := buildRootCmd()
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"link", home, dots, "--rec", "--dry-run", "--json"})
+	err = cmd.Execute()
+	var exitErr *exitError
+	require.ErrorAs(t, err, &exitErr)
+	require.Equal(t, exitPending, exitErr.code)
+	require.NoFileExists(t, filepath.Join(home, ".bashrc"))
+
+	var plan api.Plan
+	require.NoError(t, json.Unmarshal(out.Bytes(), &plan))
+	require.Equal(t, 1, plan.Stats.Pending)
+	require.Contains(t, plan.Actions, api.Action{Kind: api.ActionCreate, LinkPath: filepath.Join(home, ".bashrc"), TargetPath: filepath.Join(dots, ".bashrc"), State: LMissing.String()})
+
+	runCommand(t, buildRootCmd(), "link", home, dots, "--rec")
+	// Nothing left to do exits 0
+	status := runCommand(t, buildRootCmd(), "link", home, dots, "--rec", "-n")
+	require.Contains(t, status, "Plan: ")
+	require.NotContains(t, status, "Would link")
+}
+
+func TestLink_LogsReplacedSymlinkTargets(t *testing.T) {
+	tmpDir := newTestDir(t)
+	err := ymlfs.FromYml(tmpDir, []byte(`
+home:
+dots:
+  .bashrc: {type: file, content: "bash"}
+elsewhere:
+  bashrc: {type: file, content: "old bash"}
+`))
+	require.NoError(t, err)
+
+	home := filepath.Join(tmpDir, "home")
+	dots := filepath.Join(tmpDir, "dots")
+	oldTarget := filepath.Join(tmpDir, "elsewhere", "bashrc")
+	require.NoError(t, os.Symlink(oldTarget, filepath.Join(home, ".bashrc")))
+
+	out := runCommand(t, buildRootCmd(), "link", home, dots, "--rec", "--force", "--json")
+	var report Report
+	require.NoError(t, json.Unmarshal([]byte(out), &report))
+	require.Len(t, report.Entries, 1)
+	require.Equal(t, oldTarget, report.Entries[0].OldTarget)
+	assertSymlink(t, filepath.Join(home, ".bashrc"), filepath.Join(dots, ".bashrc"))
+
+	log := runCommand(t, buildRootCmd(), "log", home, dots)
+	require.Contains(t, log, filepath.Join(home, ".bashrc"))
+	require.Contains(t, log, "was: "+oldTarget)
+	require.Contains(t, log, "now: "+filepath.Join(dots, ".bashrc"))
+}
+
+func TestLink_ImmutableTargets(t *testing.T) {
+	tmpDir := newTestDir(t)
+	err := ymlfs.FromYml(tmpDir, []byte(`
+home:
+  .bashrc: {type: file, content: "old"}
+  .profile: {type: file, content: "same"}
+dots:
+  .bashrc: {type: file, content: "bash"}
+  .profile: {type: file, content: "same"}
+`))
+	require.NoError(t, err)
+
+	home := filepath.Join(tmpDir, "home")
+	dots := filepath.Join(tmpDir, "dots")
+	bashrc := filepath.Join(home, ".bashrc")
+	profile := filepath.Join(home, ".profile")
+	if err := fileutil.SetImmutable(bashrc, true); err != nil {
+		t.Skipf("can't flag files immutable here: %v", err)
+	}
+	t.Cleanup(func() { fileutil.SetImmutable(bashrc, false) })
+
+	cmd := buildRootCmd()
+	cmd.SetArgs([]string{"link", home, dots, "--rec", "--force"})
+	require.ErrorIs(t, cmd.Execute(), fileutil.ErrImmutable)
+	require.False(t, fileutil.IsSymlink(bashrc))
+
+	runCommand(t, buildRootCmd(), "link", home, dots, "--rec", "--force", "--clear-immutable")
+	assertSymlink(t, bashrc, filepath.Join(dots, ".bashrc"))
+
+	// Files identical to the source are replaced too, so they are checked before
+	// anything is linked
+	require.NoError(t, os.WriteFile(filepath.Join(dots, ".inputrc"), []byte("input"), 0644))
+	require.NoError(t, os.Remove(profile))
+	require.NoError(t, os.WriteFile(profile, []byte("same"), 0644))
+	require.NoError(t, fileutil.SetImmutable(profile, true))
+	t.Cleanup(func() { fileutil.SetImmutable(profile, false) })
+	cmd = buildRootCmd()
+	cmd.SetArgs([]string{"link", home, dots, "--rec"})
+	require.ErrorIs(t, cmd.Execute(), fileutil.ErrImmutable)
+	require.False(t, fileutil.IsSymlink(profile))
+	require.NoFileExists(t, filepath.Join(home, ".inputrc"))
+}
+
+func TestLink_SELinuxRestorecon(t *testing.T) {
+	tmpDir := newTestDir(t)
+	err := ymlfs.FromYml(tmpDir, []byte(`
+home:
+dots:
+  lnkit.toml: {type: file, content: "[options]\nselinux_restorecon = true\n"}
+  .config:
+    app.conf: {type: file, content: "app"}
+bin:
+  restorecon: {type: file, content: "#!/bin/sh\necho \"$@\" >> \"$(dirname \"$0\")/calls\"\n[ \"$1\" = -n ] && echo \"Would relabel $5 from a_t to b_t\"\nexit 0\n"}
+`))
+	require.NoError(t, err)
+
+	home := filepath.Join(tmpDir, "home")
+	dots := filepath.Join(tmpDir, "dots")
+	bin := filepath.Join(tmpDir, "bin")
+	require.NoError(t, os.Chmod(filepath.Join(bin, "restorecon"), 0755))
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	defer func(orig func() bool) { selinuxEnabled = orig }(selinuxEnabled)
+	selinuxEnabled = func() bool { return true }
+
+	runCommand(t, buildRootCmd(), "link", home, dots, "--rec")
+	calls, err := os.ReadFile(filepath.Join(bin, "calls"))
+	require.NoError(t, err)
+	link := filepath.Join(home, ".config", "app.conf")
+	require.Equal(t, "-F -- "+filepath.Join(home, ".config")+" "+link+"\n", string(calls))
+
+	m, err := loadManifest(home, dots)
+	require.NoError(t, err)
+	mismatches, err := contextMismatches(m)
+	require.NoError(t, err)
+	require.Equal(t, []string{"Would relabel " + link + " from a_t to b_t"}, mismatches)
+}
+
+func TestLink_WalkLimits(t *testing.T) {
+	tmpDir := newTestDir(t)
+	err := ymlfs.FromYml(tmpDir, []byte(`
+home:
+dots:
+  .bashrc: {type: file, content: "bash"}
+  .config:
+    nvim:
+      init.lua: {type: file, content: "nvim"}
+    git.conf: {type: file, content: "git"}
+`))
+	require.NoError(t, err)
+
+	home := filepath.Join(tmpDir, "home")
+	dots := filepath.Join(tmpDir, "dots")
+
+	cmd := buildRootCmd()
+	cmd.SetArgs([]string{"link", home, dots, "--rec", "--max-entries", "4"})
+	require.ErrorIs(t, cmd.Execute(), errTooManyEntries)
+	require.NoFileExists(t, filepath.Join(home, ".bashrc"))
+
+	runCommand(t, buildRootCmd(), "link", home, dots, "--rec", "--max-depth", "2")
+	assertSymlink(t, filepath.Join(home, ".bashrc"), filepath.Join(dots, ".bashrc"))
+	assertSymlink(t, filepath.Join(home, ".config", "git.conf"), filepath.Join(dots, ".config", "git.conf"))
+	require.NoFileExists(t, filepath.Join(home, ".config", "nvim", "init.lua"))
+
+	// Unlinking refuses before it removes anything too
+	require.NoError(t, os.WriteFile(filepath.Join(dots, "lnkit.toml"), []byte("[options]\nmax_entries = 4\n"), 0644))
+	cmd = buildRootCmd()
+	cmd.SetArgs([]string{"unlink", home, dots})
+	require.ErrorIs(t, cmd.Execute(), errTooManyEntries)
+	assertSymlink(t, filepath.Join(home, ".bashrc"), filepath.Join(dots, ".bashrc"))
+}
+
+func TestReview_AppliesChosenResolutionsAtOnce(t *testing.T) {
+	tmpDir := newTestDir(t)
+	layout := []byte(`
+home:
+  .bashrc: {type: file, content: "old bash"}
+  .vimrc: {type: file, content: "old vim"}
+  .zshrc: {type: file, content: "old zsh"}
+dots:
+  .bashrc: {type: file, content: "bash"}
+  .vimrc: {type: file, content: "vim"}
+  .zshrc: {type: file, content: "zsh"}
+  .inputrc: {type: file, content: "input"}
+`)
+	require.NoError(t, ymlfs.FromYml(tmpDir, layout))
+	home := filepath.Join(tmpDir, "home")
+	dots := filepath.Join(tmpDir, "dots")
+
+	// Quitting changes nothing, not even what doesn't conflict, and neither does
+	// running out of input
+	stringutil.SetInput(strings.NewReader("o\nq\n"))
+	defer stringutil.SetInput(os.Stdin)
+	runCommand(t, buildRootCmd(), "review", home, dots, "--rec")
+	stringutil.SetInput(strings.NewReader(""))
+	runCommand(t, buildRootCmd(), "review", home, dots, "--rec")
+	require.NoFileExists(t, filepath.Join(home, ".inputrc"))
+	require.False(t, fileutil.IsSymlink(filepath.Join(home, ".bashrc")))
+
+	// Keep overwriting .bashrc from before, back up .vimrc, go back to .vimrc and
+	// skip it after all, leave .zshrc undecided, then apply
+	stringutil.SetInput(strings.NewReader("n\nb\np\ns\na\n"))
+	out := runCommand(t, buildRootCmd(), "review", home, dots, "--rec")
+	require.Contains(t, out, ".bashrc (exists (modified)): overwrite\n")
+	require.Contains(t, out, ".zshrc (exists (modified)): undecided\n")
+	require.Contains(t, out, "Skipping 1 undecided conflicts\n")
+
+	assertSymlink(t, filepath.Join(home, ".bashrc"), filepath.Join(dots, ".bashrc"))
+	assertSymlink(t, filepath.Join(home, ".inputrc"), filepath.Join(dots, ".inputrc"))
+	require.False(t, fileutil.IsSymlink(filepath.Join(home, ".vimrc")))
+	require.NoFileExists(t, filepath.Join(home, ".vimrc.bak"))
+	require.False(t, fileutil.IsSymlink(filepath.Join(home, ".zshrc")))
+
+	// Without conflicts, the plan is shown and applied only once confirmed
+	require.NoError(t, os.Remove(filepath.Join(home, ".vimrc")))
+	require.NoError(t, os.Remove(filepath.Join(home, ".zshrc")))
+	stringutil.SetInput(strings.NewReader("n\n"))
+	out = runCommand(t, buildRootCmd(), "review", home, dots, "--rec")
+	require.Contains(t, out, "Would link: ")
+	require.NoFileExists(t, filepath.Join(home, ".vimrc"))
+	stringutil.SetInput(strings.NewReader("y\n"))
+	runCommand(t, buildRootCmd(), "review", home, dots, "--rec")
+	assertSymlink(t, filepath.Join(home, ".vimrc"), filepath.Join(dots, ".vimrc"))
+}
+
+func TestStats_History(t *testing.T) {
+	tmpDir := newTestDir(t)
+	err := ymlfs.FromYml(tmpDir, []byte(`
+home:
+dots:
+  .bashrc: {type: file, content: "bash"}
+  .vimrc: {type: file, content: "vim"}
+`))
+	require.NoError(t, err)
+
+	home := filepath.Join(tmpDir, "home")
+	dots := filepath.Join(tmpDir, "dots")
+	require.Equal(t, "No stats recorded from "+home+" to "+dots+" yet\n", runCommand(t, buildRootCmd(), "stats", "--history", home, dots))
+
+	runCommand(t, buildRootCmd(), "stats", "--rec", home, dots)
+	runCommand(t, buildRootCmd(), "link", home, dots, "--rec")
+	runCommand(t, buildRootCmd(), "stats", "--rec", home, dots)
+
+	out := runCommand(t, buildRootCmd(), "stats", "--history", home, dots)
+	require.Contains(t, out, "drift █▁\n")
+	require.Contains(t, out, "0 in place, 2 missing, 0 conflicts")
+	require.Contains(t, out, "2 in place, 0 missing, 0 conflicts")
+
+	out = runCommand(t, buildRootCmd(), "stats", "--history", "--last", "1", home, dots)
+	require.Contains(t, out, "drift ▁\n")
+	require.NotContains(t, out, "2 missing")
+
+	_, err = runCommandStdout(t, buildRootCmd(), "stats", "--history", "--last", "-1", home, dots)
+	require.ErrorContains(t, err, "--last must be at least 1")
+
+	// Under --read-only the stats are shown but not recorded
+	t.Cleanup(func() { fileutil.SetReadOnly(false) })
+	runCommand(t, buildRootCmd(), "--read-only", "stats", "--rec", home, dots)
+	out = runCommand(t, buildRootCmd(), "stats", "--history", home, dots)
+	require.Contains(t, out, "drift █▁\n")
+}
+
+func TestSparkline(t *testing.T) {
+	require.Equal(t, "▁▄█", sparkline([]int{0, 2, 4}))
+	require.Equal(t, "▁▁", sparkline([]int{0, 0}))
+}
+
+func TestApply_RefusesChangedOrUnsignedPlans(t *testing.T) {
+	tmpDir := newTestDir(t)
+	err := ymlfs.FromYml(tmpDir, []byte(`
+home:
+  .bashrc: {type: file, content: "old bash"}
+dots:
+  .bashrc: {type: file, content: "bash"}
+  .vimrc: {type: file, content: "vim"}
+`))
+	require.NoError(t, err)
+
+	home := filepath.Join(tmpDir, "home")
+	dots := filepath.Join(tmpDir, "dots")
+	planPath := filepath.Join(tmpDir, "plan.json")
+	sigPath := filepath.Join(tmpDir, "plan.sig")
+
+	// Changing anything the plan covers makes it stale
+	runCommand(t, buildRootCmd(), "plan", "--rec", "-o", planPath, home, dots)
+	require.NoError(t, os.WriteFile(filepath.Join(home, ".bashrc"), []byte("newer bash"), 0644))
+	cmd := buildRootCmd()
+	cmd.SetArgs([]string{"apply", "--approved", planPath})
+	require.ErrorContains(t, cmd.Execute(), "changed since it was planned")
+	require.NoFileExists(t, filepath.Join(home, ".vimrc"))
+
+	// A plan is applied with the config it was planned with
+	configPath := filepath.Join(tmpDir, "alt.toml")
+	require.NoError(t, os.WriteFile(configPath, []byte("[options]\nignore = [\".vimrc\"]\n"), 0644))
+	runCommand(t, buildRootCmd(), "plan", "--rec", "--config", configPath, "-o", planPath, home, dots)
+	runCommand(t, buildRootCmd(), "apply", "--approved", planPath)
+	assertSymlink(t, filepath.Join(home, ".bashrc"), filepath.Join(dots, ".bashrc"))
+	require.NoFileExists(t, filepath.Join(home, ".vimrc"))
+
+	// With a key set, only a plan signed with it is applied
+	t.Setenv(approvalKeyEnv, "secret")
+	runCommand(t, buildRootCmd(), "plan", "--rec", "-o", planPath, home, dots)
+	cmd = buildRootCmd()
+	cmd.SetArgs([]string{"apply", "--approved", planPath})
+	require.ErrorContains(t, cmd.Execute(), "must be signed")
+
+	require.NoError(t, os.WriteFile(sigPath, []byte(runCommand(t, buildRootCmd(), "approve", planPath)), 0644))
+	runCommand(t, buildRootCmd(), "apply", "--approved", planPath, "--signature", sigPath)
+
+	assertSymlink(t, filepath.Join(home, ".bashrc"), filepath.Join(dots, ".bashrc"))
+	assertSymlink(t, filepath.Join(home, ".vimrc"), filepath.Join(dots, ".vimrc"))
+	content, err := os.ReadFile(filepath.Join(home, ".bashrc.bak"))
+	require.NoError(t, err)
+	require.Equal(t, "newer bash", string(content))
+}
+
+func TestLogs_SeparateFromUserOutput(t *testing.T) {
+	tmpDir := newTestDir(t)
+	err := ymlfs.FromYml(tmpDir, []byte(`
+home:
+dots:
+  .bashrc: {type: file, content: "bash"}
+`))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		logSettings.output, logSettings.color = "stderr", "auto"
+		InitLogger("Fatal")
+	})
+
+	home := filepath.Join(tmpDir, "home")
+	dots := filepath.Join(tmpDir, "dots")
+	logPath := filepath.Join(tmpDir, "lnk.log")
+	runCommand(t, buildRootCmd(), "link", home, dots, "--rec")
+
+	// Colored results don't color the logs, and the logs don't end up in the results
+	out := runCommand(t, buildRootCmd(), "--color", "always", "--log-level", "info", "--log-file", logPath, "unlink", home, dots)
+	logs, err := os.ReadFile(logPath)
+	require.NoError(t, err)
+	require.Contains(t, string(logs), "Unlinked: "+filepath.Join(home, ".bashrc"))
+	require.NotContains(t, string(logs), "\x1b[")
+	require.NotContains(t, out, "Unlinked:")
+
+	cmd := buildRootCmd()
+	cmd.SetArgs([]string{"--log-color", "sometimes", "list", home, dots})
+	require.ErrorContains(t, cmd.Execute(), "invalid --log-color")
+}
+
+func TestLink_IgnoreFileExplained(t *testing.T) {
+	tmpDir := newTestDir(t)
+	err := ymlfs.FromYml(tmpDir, []byte(`
+home:
+dots:
+  .lnkitignore: {type: file, content: "# not for this machine\n/private/\n"}
+  private:
+    key: {type: file, content: "secret"}
+  .bashrc: {type: file, content: "bash"}
+`))
+	require.NoError(t, err)
+
+	home := filepath.Join(tmpDir, "home")
+	out := runCommand(t, buildRootCmd(), "link", home, filepath.Join(tmpDir, "dots"), "--rec", "--json")
+
+	var report Report
+	require.NoError(t, json.Unmarshal([]byte(out), &report))
+	messages := map[string]string{}
+	for _, e := range report.Entries {
+		rel, _ := filepath.Rel(home, e.LinkPath)
+		messages[rel] = e.Message
+	}
+	require.Equal(t, `matches "private" from .lnkitignore`, messages["private"])
+	require.Equal(t, `matches ".lnkitignore" from config`, messages[".lnkitignore"])
+	require.NoFileExists(t, filepath.Join(home, "private", "key"))
+	assertSymlink(t, filepath.Join(home, ".bashrc"), filepath.Join(tmpDir, "dots", ".bashrc"))
+}
+
+func TestLink_CollisionsNeedAPriority(t *testing.T) {
+	tmpDir := newTestDir(t)
+	err := ymlfs.FromYml(tmpDir, []byte(`
+home:
+dots:
+  lnkit.toml:
+    type: file
+    content: |
+      [exceptions]
+      "bash/.profile" = ".profile"
+      "zsh-compat/.profile" = ".profile"
+  .profile: {type: file, content: "plain"}
+  bash:
+    .profile: {type: file, content: "bash"}
+  zsh-compat:
+    .profile: {type: file, content: "zsh"}
+`))
+	require.NoError(t, err)
+
+	home := filepath.Join(tmpDir, "home")
+	dots := filepath.Join(tmpDir, "dots")
+
+	// Three sources for ~/.profile and nothing to choose between them
+	cmd := buildRootCmd()
+	cmd.SetArgs([]string{"link", home, dots, "--rec"})
+	require.ErrorIs(t, cmd.Execute(), errCollision)
+	require.NoFileExists(t, filepath.Join(home, ".profile"))
+
+	config := "[exceptions]\n\"bash/.profile\" = { target = \".profile\", priority = 1 }\n\"zsh-compat/.profile\" = \".profile\"\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dots, "lnkit.toml"), []byte(config), 0644))
+	out := runCommand(t, buildRootCmd(), "link", home, dots, "--rec", "--json")
+
+	var report Report
+	require.NoError(t, json.Unmarshal([]byte(out), &report))
+	collided := map[string]bool{}
+	for _, e := range report.Entries {
+		if e.Reason == ReasonCollision {
+			rel, _ := filepath.Rel(dots, e.TargetPath)
+			collided[rel] = true
+		}
+	}
+	require.Equal(t, map[string]bool{".profile": true, "zsh-compat/.profile": true}, collided)
+	assertSymlink(t, filepath.Join(home, ".profile"), filepath.Join(dots, "bash", ".profile"))
+}
+
+func TestLink_SlowReport(t *testing.T) {
+	tmpDir := newTestDir(t)
+	err := ymlfs.FromYml(tmpDir, []byte(`
+home:
+  big: {type: file, content: "old"}
+dots:
+  a: {type: file, content: "a"}
+  big: {type: file, content: "new"}
+`))
+	require.NoError(t, err)
+
+	home := filepath.Join(tmpDir, "home")
+	dots := filepath.Join(tmpDir, "dots")
+	big := filepath.Join(dots, "big")
+	require.NoError(t, os.Truncate(big, largeFileBytes))
+
+	stringutil.SetInput(strings.NewReader("s\n"))
+	defer stringutil.SetInput(os.Stdin)
+	out := runCommand(t, buildRootCmd(), "link", home, dots, "--rec", "--slow-report=1")
+
+	// Hashing the big file to compare it is the slowest part of the run
+	require.Contains(t, out, "Slowest 1 of ")
+	require.Regexp(t, `\n +\S+  `+regexp.QuoteMeta(big)+` \(detect \S+, apply \S+\): 16 MiB hashed`, out)
+	require.NotContains(t, out, filepath.Join(dots, "a")+" (detect")
+}
+
+func TestLink_ExceptionsOutsideTheRoot(t *testing.T) {
+	tmpDir := newTestDir(t)
+	err := ymlfs.FromYml(tmpDir, []byte(`
+home:
+bin:
+dots:
+  myscript: {type: file, content: "#!/bin/sh"}
+`))
+	require.NoError(t, err)
+
+	home := filepath.Join(tmpDir, "home")
+	dots := filepath.Join(tmpDir, "dots")
+	script := filepath.Join(tmpDir, "bin", "myscript")
+
+	// Relative targets still can't leave the link root
+	require.NoError(t, os.WriteFile(filepath.Join(dots, "lnkit.toml"), []byte("[exceptions]\nmyscript = \"../bin/myscript\"\n"), 0644))
+	cmd := buildRootCmd()
+	cmd.SetArgs([]string{"link", home, dots, "--rec"})
+	require.ErrorContains(t, cmd.Execute(), "give targets outside it as absolute paths")
+
+	// Absolute ones can, once confirmed separately
+	config := fmt.Sprintf("[exceptions]\nmyscript = %q\n", script)
+	require.NoError(t, os.WriteFile(filepath.Join(dots, "lnkit.toml"), []byte(config), 0644))
+	out, _ := runCommandStdout(t, buildRootCmd(), "link", home, dots, "--rec", "--dry-run")
+	require.Contains(t, out, "Would link ["+string(ReasonOutsideRoot)+"]: ")
+	stringutil.SetInput(strings.NewReader("n\n"))
+	defer stringutil.SetInput(os.Stdin)
+	runCommand(t, buildRootCmd(), "link", home, dots, "--rec")
+	require.NoFileExists(t, script)
+	stringutil.SetInput(strings.NewReader("n\n"))
+	runCommand(t, buildRootCmd(), "link", home, dots, "--rec", "--force")
+	require.NoFileExists(t, script)
+
+	runCommand(t, buildRootCmd(), "link", home, dots, "--rec", "--allow-outside")
+	assertSymlink(t, script, filepath.Join(dots, "myscript"))
+
+	// Targets holding the link root are refused
+	config = fmt.Sprintf("[exceptions]\nmyscript = %q\n", tmpDir)
+	require.NoError(t, os.WriteFile(filepath.Join(dots, "lnkit.toml"), []byte(config), 0644))
+	cmd = buildRootCmd()
+	cmd.SetArgs([]string{"link", home, dots, "--rec"})
+	require.ErrorContains(t, cmd.Execute(), "would replace a directory holding")
+}
+
+func TestLink_RemoteSources(t *testing.T) {
+	tmpDir := newTestDir(t)
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	err := ymlfs.FromYml(tmpDir, []byte(`
+home:
+dots:
+`))
+	require.NoError(t, err)
+
+	upstream := "add_newline = false\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, upstream)
+	}))
+	defer server.Close()
+
+	home := filepath.Join(tmpDir, "home")
+	dots := filepath.Join(tmpDir, "dots")
+	configPath := filepath.Join(dots, "lnkit.toml")
+	link := filepath.Join(home, ".config", "starship.toml")
+	pin := func(content string) string {
+		sum := sha256.Sum256([]byte(content))
+		return hex.EncodeToString(sum[:])
+	}
+	writeConfig := func(sum string) {
+		config := fmt.Sprintf("[exceptions]\n\"starship.toml\" = { target = \".config/starship.toml\", source_url = %q, sha256 = %q }\n",
+			server.URL+"/starship.toml", sum)
+		require.NoError(t, os.WriteFile(configPath, []byte(config), 0644))
+	}
+
+	// Only content matching the pin is linked
+	writeConfig(strings.Repeat("0", 64))
+	out := runCommand(t, buildRootCmd(), "link", home, dots, "--rec", "--json")
+	require.Contains(t, out, `"reason": "FETCH_FAILED"`)
+	require.NoFileExists(t, link)
+
+	writeConfig(pin(upstream))
+	runCommand(t, buildRootCmd(), "link", home, dots, "--rec")
+	content, err := os.ReadFile(link)
+	require.NoError(t, err)
+	require.Equal(t, upstream, string(content))
+
+	// Upstream changing doesn't change the link until the pin is updated
+	upstream = "add_newline = true\n"
+	runCommand(t, buildRootCmd(), "link", home, dots, "--rec")
+	content, err = os.ReadFile(link)
+	require.NoError(t, err)
+	require.Equal(t, "add_newline = false\n", string(content))
+
+	out = runCommand(t, buildRootCmd(), "update-remotes", dots)
+	require.Contains(t, out, "starship.toml: "+pin("add_newline = false\n")+" → "+pin(upstream))
+	config, err := os.ReadFile(configPath)
+	require.NoError(t, err)
+	require.Contains(t, string(config), pin(upstream))
+
+	runCommand(t, buildRootCmd(), "link", home, dots, "--rec")
+	content, err = os.ReadFile(link)
+	require.NoError(t, err)
+	require.Equal(t, upstream, string(content))
+
+	// A cached file changed since is fetched again
+	require.NoError(t, os.WriteFile(link, []byte("tampered"), 0644))
+	runCommand(t, buildRootCmd(), "link", home, dots, "--rec")
+	content, err = os.ReadFile(link)
+	require.NoError(t, err)
+	require.Equal(t, upstream, string(content))
+
+	runCommand(t, buildRootCmd(), "unlink", home, dots)
+	require.NoFileExists(t, link)
+}
+
+func TestLink_RemoteSourcesUncached(t *testing.T) {
+	tmpDir := newTestDir(t)
+	err := ymlfs.FromYml(tmpDir, []byte(`
+home:
+dots:
+`))
+	require.NoError(t, err)
+
+	upstream := "add_newline = false\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, upstream)
+	}))
+	defer server.Close()
+
+	home := filepath.Join(tmpDir, "home")
+	dots := filepath.Join(tmpDir, "dots")
+	link := filepath.Join(home, ".config", "starship.toml")
+	sum := sha256.Sum256([]byte(upstream))
+	config := fmt.Sprintf("[exceptions]\n\"starship.toml\" = { target = \".config/starship.toml\", source_url = %q, sha256 = %q }\n",
+		server.URL+"/starship.toml", hex.EncodeToString(sum[:]))
+	require.NoError(t, os.WriteFile(filepath.Join(dots, "lnkit.toml"), []byte(config), 0644))
+
+	// A dry run on a fresh machine shows the file it would fetch, without fetching it
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	out, err := runCommandStdout(t, buildRootCmd(), "link", home, dots, "--rec", "--dry-run")
+	var exitErr *exitError
+	require.ErrorAs(t, err, &exitErr)
+	require.Equal(t, exitPending, exitErr.code)
+	require.Contains(t, out, "Would link ["+string(ReasonFetchPending)+"]: ")
+	require.NoFileExists(t, link)
+
+	// ensure fetches and links it
+	runCommand(t, buildRootCmd(), "ensure", home, dots, "--rec")
+	content, err := os.ReadFile(link)
+	require.NoError(t, err)
+	require.Equal(t, upstream, string(content))
+	runCommand(t, buildRootCmd(), "unlink", home, dots)
+	require.NoFileExists(t, link)
+
+	// So does applying a plan made before it was cached, which lists it
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	planPath := filepath.Join(tmpDir, "plan.json")
+	runCommand(t, buildRootCmd(), "plan", "--rec", "-o", planPath, home, dots)
+	plan, err := os.ReadFile(planPath)
+	require.NoError(t, err)
+	require.Contains(t, string(plan), `"reason": "FETCH_PENDING"`)
+	runCommand(t, buildRootCmd(), "apply", "--approved", planPath)
+	content, err = os.ReadFile(link)
+	require.NoError(t, err)
+	require.Equal(t, upstream, string(content))
+}
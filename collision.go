@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// errCollision is returned when sources would be linked to the same place and
+// nothing says which one wins.
+var errCollision = errors.New("link path collision")
+
+// claimant is a source that would be linked to a given link path.
+type claimant struct {
+	source   string // Relative to the source root
+	priority int
+}
+
+// linkedWhole reports whether the walk links the source at rel as an entry of its
+// own, rather than only what is in it.
+func (o linkOptions) linkedWhole(rel string, isDir bool) bool {
+	if !o.recursive {
+		return !strings.Contains(rel, string(filepath.Separator))
+	}
+	return !isDir || o.fold
+}
+
+// findCollisions finds link paths more than one source would be linked to: two
+// exceptions with the same target, or an exception targeting where another source
+// is mirrored. It returns each source that loses to one with a higher priority,
+// mapped to the source that wins, or errCollision if any link path has no single
+// winner. opts.exceptions must already be resolved.
+func findCollisions(linkRoot, targetRoot string, opts linkOptions) (map[string]string, error) {
+	claims := map[string][]claimant{}
+	for source, m := range opts.exceptions {
+		if m.Target != "" {
+			claims[m.Target] = append(claims[m.Target], claimant{source, m.Priority})
+		}
+	}
+
+	losers := map[string]string{}
+	var unresolved []string
+	for linkPath, claimants := range claims {
+		if rel, err := filepath.Rel(linkRoot, linkPath); err == nil && filepath.IsLocal(rel) {
+			_, isException := opts.exceptions[rel]
+			info, err := os.Lstat(filepath.Join(targetRoot, rel))
+			if !isException && err == nil && info.Mode()&os.ModeSymlink == 0 &&
+				opts.linkedWhole(rel, info.IsDir()) && !opts.ignore.Match(rel, info.IsDir()) {
+				claimants = append(claimants, claimant{rel, 0})
+			}
+		}
+		if len(claimants) < 2 {
+			continue
+		}
+
+		sort.Slice(claimants, func(i, j int) bool {
+			if claimants[i].priority != claimants[j].priority {
+				return claimants[i].priority > claimants[j].priority
+			}
+			return claimants[i].source < claimants[j].source
+		})
+		if claimants[0].priority == claimants[1].priority {
+			sources := make([]string, len(claimants))
+			for i, c := range claimants {
+				sources[i] = c.source
+			}
+			unresolved = append(unresolved, fmt.Sprintf("%s from %s", linkPath, strings.Join(sources, " and ")))
+			continue
+		}
+		for _, c := range claimants[1:] {
+			losers[c.source] = claimants[0].source
+		}
+	}
+
+	if len(unresolved) > 0 {
+		sort.Strings(unresolved)
+		return nil, fmt.Errorf("%w: more than one source would be linked to %s; give one a higher priority in [exceptions], or ignore the others",
+			errCollision, strings.Join(unresolved, ", "))
+	}
+	return losers, nil
+}
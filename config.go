@@ -0,0 +1,400 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+
+	"lnkit/fileutil"
+
+	"github.com/BurntSushi/toml"
+)
+
+const configFile = "lnkit.toml"
+
+const gitattributesFile = ".gitattributes"
+
+type Config struct {
+	Options Options            `toml:"options"`
+	Links   map[string]Mapping `toml:"exceptions"` // Custom exceptions as source -> target mappings
+
+	// Things to do once a source path (or anything under it) is newly linked,
+	// e.g. "nvim" = "Run :PlugInstall in nvim", printed at the end of the run
+	FollowUps map[string]string `toml:"follow_ups"`
+}
+
+type Options struct {
+	Confirm    bool      `toml:"confirm"`
+	Force      bool      `toml:"force"`
+	CreateDirs bool      `toml:"create_dirs"`
+	SourceDir  string    `toml:"source_dir"`
+	TargetDir  string    `toml:"target_dir"`
+	Ignore     []string  `toml:"ignore"`
+	LogLevel   string    `toml:"log_level"`
+	LinkStyle  LinkStyle `toml:"link_style"`
+
+	// Only treat a link as correct if its literal target matches, rather than
+	// any path resolving to the same file (e.g. through /home -> /Users)
+	StrictLinkMatch bool `toml:"strict_link_match"`
+
+	// How created links are marked as managed: "manifest" or "xattr"
+	ManagedMarker string `toml:"managed_marker"`
+
+	// Use slower but safer filesystem operations for network filesystems like NFS
+	NetworkFS bool `toml:"network_fs"`
+
+	// Octal mode of directories created to hold links, applied regardless of the umask
+	DirMode string `toml:"dir_mode"`
+
+	// Shared repo linked underneath this one; files here shadow files there
+	Base string `toml:"base"`
+
+	// Whether forced operations require the source repo to have no uncommitted changes
+	RequireCleanSource CleanSource `toml:"require_clean_source"`
+
+	// Limits on recursive walks of the source: how deep to go (0 for no limit), and
+	// how many entries to visit before failing, so a wrong source can't run away
+	MaxDepth   int `toml:"max_depth"`
+	MaxEntries int `toml:"max_entries"`
+
+	// Patterns of names in directories lnkit manages on the link side that aren't
+	// reported as foreign, e.g. files the OS or an editor leave behind
+	TargetIgnore []string `toml:"target_ignore"`
+
+	// Give created links and directories the SELinux context the policy expects for
+	// their path, when SELinux is enabled
+	SELinuxRestorecon bool `toml:"selinux_restorecon"`
+
+	// Also skip what the source's .gitattributes marks export-ignore
+	GitExportIgnore bool `toml:"git_export_ignore"`
+
+	// Tool conflict previews are diffed with, from an allowlist, and its arguments,
+	// with {old} and {new} standing for the files compared
+	DiffTool string   `toml:"diff_tool"`
+	DiffArgs []string `toml:"diff_args"`
+}
+
+// Mapping is a single exception: where a source path should be linked, and how.
+// In TOML it is either a plain target string or a table, which may list targets
+// for different operating systems instead of a single one:
+//
+//	"nvim" = "~/.config/nvim"
+//	"bin/tool" = { target = "~/.local/bin/tool", link_style = "relative" }
+//	"foo" = { targets = [{ os = "darwin", path = "~/Library/Application Support/foo" }, { path = "~/.config/foo" }] }
+//	"nvim" = { target = "~/.config/nvim", requires_pkg = "neovim" }
+type Mapping struct {
+	Target    string      `toml:"target"`
+	Targets   []Candidate `toml:"targets"`    // Used if Target is empty; the first matching one wins
+	LinkStyle LinkStyle   `toml:"link_style"` // Overrides options.link_style if set
+
+	// Package providing the tool this configures, e.g. "neovim", to point out
+	// configs linked for tools that aren't installed and the other way around
+	RequiresPkg string `toml:"requires_pkg"`
+
+	// Which source wins if another would be linked to the same place; the source
+	// mirrored there has priority 0. Ties are an error.
+	Priority int `toml:"priority"`
+
+	// Remote file to link instead of a file in the source, for configs vendored from
+	// upstream, and the SHA-256 it is pinned to (see lnk update-remotes)
+	SourceURL string `toml:"source_url"`
+	SHA256    string `toml:"sha256"`
+
+	outside bool // Set by resolveExceptions if the target is outside the link root
+}
+
+// Candidate is one of a mapping's alternative targets, used on a matching OS.
+type Candidate struct {
+	OS   string `toml:"os"`   // A GOOS value such as "linux" or "darwin"; empty matches any
+	Path string `toml:"path"` // Target path
+}
+
+// targetFor returns the target to use on goos, or "" if the mapping has none for it.
+func (m Mapping) targetFor(goos string) string {
+	if m.Target != "" {
+		return m.Target
+	}
+	for _, c := range m.Targets {
+		if c.OS == "" || c.OS == goos {
+			return c.Path
+		}
+	}
+	return ""
+}
+
+func (m *Mapping) UnmarshalTOML(data any) error {
+	switch v := data.(type) {
+	case string:
+		m.Target = v
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+
+		for _, key := range keys {
+			val := v[key]
+			want, known := mappingKeys[key]
+			if !known {
+				return fmt.Errorf("unknown exception key %q", key)
+			}
+			var ok bool
+			switch key {
+			case "target":
+				m.Target, ok = val.(string)
+			case "targets":
+				var err error
+				if m.Targets, ok, err = unmarshalCandidates(val); err != nil {
+					return err
+				}
+			case "link_style":
+				var style string
+				style, ok = val.(string)
+				m.LinkStyle = LinkStyle(style)
+			case "requires_pkg":
+				m.RequiresPkg, ok = val.(string)
+			case "priority":
+				var priority int64
+				priority, ok = val.(int64)
+				m.Priority = int(priority)
+			case "source_url":
+				m.SourceURL, ok = val.(string)
+			case "sha256":
+				m.SHA256, ok = val.(string)
+			}
+			if !ok {
+				return fmt.Errorf("exception key %q must be %s, got %T", key, want, val)
+			}
+		}
+
+		_, hasTarget := v["target"]
+		_, hasTargets := v["targets"]
+		switch {
+		case hasTarget && hasTargets:
+			return fmt.Errorf("exception can't have both 'target' and 'targets'")
+		case !hasTarget && !hasTargets:
+			return fmt.Errorf("exception is missing a 'target' string or 'targets' list")
+		}
+	default:
+		return fmt.Errorf("exception must be a string or table, got %T", data)
+	}
+	return nil
+}
+
+// mappingKeys are the keys of an exception table, and what each must be.
+var mappingKeys = map[string]string{
+	"target":       "a string",
+	"targets":      "a list of tables",
+	"link_style":   "a string",
+	"requires_pkg": "a string",
+	"priority":     "an integer",
+	"source_url":   "a string",
+	"sha256":       "a string",
+}
+
+// unmarshalCandidates decodes an exception's targets list. It returns false if
+// val isn't a list of tables, and an error if one of them is malformed.
+func unmarshalCandidates(val any) ([]Candidate, bool, error) {
+	var tables []map[string]any
+	switch list := val.(type) {
+	case []map[string]any: // [[exceptions."foo".targets]]
+		tables = list
+	case []any:
+		for _, item := range list {
+			t, ok := item.(map[string]any)
+			if !ok {
+				return nil, false, nil
+			}
+			tables = append(tables, t)
+		}
+	default:
+		return nil, false, nil
+	}
+
+	candidates := make([]Candidate, 0, len(tables))
+	for i, t := range tables {
+		var c Candidate
+		for key, val := range t {
+			var ok bool
+			switch key {
+			case "path":
+				c.Path, ok = val.(string)
+			case "os":
+				c.OS, ok = val.(string)
+			default:
+				return nil, true, fmt.Errorf("unknown key %q in exception target %d", key, i+1)
+			}
+			if !ok {
+				return nil, true, fmt.Errorf("%q of exception target %d must be a string, got %T", key, i+1, val)
+			}
+		}
+		if _, ok := t["path"]; !ok {
+			return nil, true, fmt.Errorf("exception target %d is missing a 'path' string", i+1)
+		}
+		candidates = append(candidates, c)
+	}
+	return candidates, true, nil
+}
+
+// Default configuration to fall back on if no config file is found
+var defaultConfig = Config{
+	Options: Options{
+		Confirm:    true,
+		Force:      false,
+		CreateDirs: true,
+		SourceDir:  ".",
+		TargetDir:  "~",
+		Ignore:     []string{"lnkit.toml", ".lnkitignore", "*.git"},
+		LogLevel:   "debug",
+		LinkStyle:  LinkAbsolute,
+
+		ManagedMarker: MarkerManifest,
+		DirMode:       "0755",
+		DiffTool:      "git",
+
+		RequireCleanSource: CleanSourceOff,
+		MaxEntries:         100_000,
+		TargetIgnore:       []string{".DS_Store", "Thumbs.db", "desktop.ini", "*.swp", "*~"},
+	},
+}
+
+// loadConfig reads the config at path on top of the defaults. If path is empty,
+// it looks for lnkit.toml in sourceDir and falls back to the defaults if there is none.
+func loadConfig(path, sourceDir string) (Config, error) {
+	cfg := defaultConfig
+	// Decoding reuses slices, which would write into the defaults
+	cfg.Options.Ignore = slices.Clone(cfg.Options.Ignore)
+	cfg.Options.TargetIgnore = slices.Clone(cfg.Options.TargetIgnore)
+	if path == "" {
+		path = filepath.Join(sourceDir, configFile)
+		if !fileutil.IsRegularFile(path) {
+			return cfg, nil
+		}
+	}
+
+	if _, err := toml.DecodeFile(path, &cfg); err != nil {
+		return cfg, fmt.Errorf("failed to load config %s: %w", path, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return cfg, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+
+	sugar.Debugf("Loaded config: %s", path)
+	return cfg, nil
+}
+
+// ignoreSet returns what to skip in sourceDir: the ignore option, the patterns in
+// its .lnkitignore, and, with git_export_ignore, what its .gitattributes marks
+// export-ignore.
+func (o Options) ignoreSet(sourceDir string) (*fileutil.IgnoreSet, error) {
+	set, err := fileutil.NewIgnoreSet("config", o.Ignore...)
+	if err != nil {
+		return nil, fmt.Errorf("ignore: %w", err)
+	}
+	if err := set.AddFile(filepath.Join(sourceDir, ignoreFile)); err != nil {
+		return nil, err
+	}
+	if o.GitExportIgnore {
+		if err := set.AddGitattributes(filepath.Join(sourceDir, gitattributesFile)); err != nil {
+			return nil, err
+		}
+	}
+	return set, nil
+}
+
+// dirMode parses the dir_mode option.
+func (o Options) dirMode() (os.FileMode, error) {
+	mode, err := strconv.ParseUint(o.DirMode, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid dir mode %q, expected octal permissions like \"0755\"", o.DirMode)
+	}
+	return os.FileMode(mode), nil
+}
+
+func (c Config) validate() error {
+	if err := c.Options.LinkStyle.validate(); err != nil {
+		return err
+	}
+	if m := c.Options.ManagedMarker; m != MarkerManifest && m != MarkerXattr {
+		return fmt.Errorf("unknown managed marker %q, expected %q or %q", m, MarkerManifest, MarkerXattr)
+	}
+	if _, err := c.Options.dirMode(); err != nil {
+		return err
+	}
+	if _, err := c.Options.differ(); err != nil {
+		return err
+	}
+	if c.Options.MaxDepth < 0 || c.Options.MaxEntries < 0 {
+		return fmt.Errorf("max_depth and max_entries can't be negative")
+	}
+	if _, err := fileutil.MatchesPatterns("", c.Options.TargetIgnore); err != nil {
+		return fmt.Errorf("target_ignore: %w", err)
+	}
+	for source, m := range c.Links {
+		if m.LinkStyle != "" {
+			if err := m.LinkStyle.validate(); err != nil {
+				return fmt.Errorf("exception %q: %w", source, err)
+			}
+		}
+		if err := m.validateRemote(); err != nil {
+			return fmt.Errorf("exception %q: %w", source, err)
+		}
+	}
+	return nil
+}
+
+// errBadException is returned for exceptions whose target can't be linked to.
+var errBadException = errors.New("invalid exception")
+
+// resolveExceptions returns the exceptions keyed by source path relative to the
+// source root, with targets for this OS expanded to absolute link paths. Relative
+// targets are resolved against linkRoot and must stay inside it; other targets are
+// expanded as seen from inside root if it is set, and may be anywhere but a filesystem root or a
+// directory holding linkRoot. Exceptions with no target for this OS are kept with
+// an empty target, so their source isn't linked at all.
+func resolveExceptions(links map[string]Mapping, linkRoot, root string) (map[string]Mapping, error) {
+	resolved := make(map[string]Mapping, len(links))
+	for source, m := range links {
+		target := m.targetFor(runtime.GOOS)
+		if target == "" {
+			sugar.Debugf("Exception %q has no target for %s", source, runtime.GOOS)
+			m.Target = ""
+			resolved[filepath.Clean(source)] = m
+			continue
+		}
+		relative := !filepath.IsAbs(target) && !strings.HasPrefix(target, "~") && !strings.HasPrefix(target, "$")
+		var expanded string
+		var err error
+		switch {
+		case relative:
+			expanded, err = fileutil.ExpandPath(filepath.Join(linkRoot, target))
+		case root != "":
+			expanded, err = fileutil.ExpandPathInRoot(target, root)
+		default:
+			expanded, err = fileutil.ExpandPath(target)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to expand exception target %q: %w", target, err)
+		}
+		target = expanded
+		if inRoot, _ := fileutil.IsChildPath(target, linkRoot); !inRoot {
+			if relative {
+				return nil, fmt.Errorf("%w: target %s is outside of %s; give targets outside it as absolute paths", errBadException, target, linkRoot)
+			}
+			if holdsRoot, _ := fileutil.IsChildPath(linkRoot, target); holdsRoot || filepath.Dir(target) == target {
+				return nil, fmt.Errorf("%w: target %s would replace a directory holding %s", errBadException, target, linkRoot)
+			}
+			m.outside = true
+		}
+
+		m.Target = target
+		resolved[filepath.Clean(source)] = m
+	}
+	return resolved, nil
+}
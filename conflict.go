@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"lnkit/fileutil"
+	"lnkit/stringutil"
+)
+
+// Resolution is what to do with an existing file or link that is in the way of a new link.
+type Resolution int
+
+const (
+	ResolveSkip      Resolution = iota // Leave the existing path alone and don't link
+	ResolveBackup                      // Move the existing path to a .bak file, then link
+	ResolveOverwrite                   // Delete the existing path, then link
+)
+
+// conflict is a link path that is occupied by something other than the intended link.
+type conflict struct {
+	linkPath   string
+	targetPath string
+	state      LState
+	style      LinkStyle
+	root       string
+	hexdiff    bool // Preview binary files as hex dumps
+}
+
+// createLink creates the symlink for an entry and records the outcome in report. If root
+// is set, the link's target is written as it will appear from inside root.
+func createLink(report *Report, linkPath, targetPath string, state LState, style LinkStyle, root string, createDirs bool) {
+	var created []string
+	if createDirs {
+		created = fileutil.MissingParents(linkPath)
+	}
+
+	linkTarget, err := style.linkTarget(fileutil.StripRoot(root, linkPath), fileutil.StripRoot(root, targetPath))
+	if err == nil {
+		err = fileutil.CreateSymlink(linkPath, linkTarget, createDirs)
+	}
+	if err != nil {
+		err = explainImmutable(err, linkPath)
+		reason := errorReason(err)
+		sugar.Infof("Error creating symlink %s [%s]: %v", linkString(linkPath, targetPath), reason, err)
+		report.add(linkPath, targetPath, state, StatusFailed, reason, err.Error())
+		return
+	}
+	sugar.Infof("Linked: %s", linkString(linkPath, targetPath))
+	report.add(linkPath, targetPath, state, StatusLinked, "", "")
+	report.Entries[len(report.Entries)-1].CreatedDirs = created
+}
+
+// resolveConflict applies resolution to the conflicting linkPath and, unless skipping, links it.
+func resolveConflict(report *Report, c conflict, resolution Resolution, createDirs bool) error {
+	backup := ""
+	oldTarget := readOldTarget(c.linkPath)
+
+	switch resolution {
+	case ResolveSkip:
+		reason := conflictReason(c.state)
+		printEntry("Skipped [%s]: %s", reason, c.linkPath)
+		report.add(c.linkPath, c.targetPath, c.state, StatusSkipped, reason, "")
+		return nil
+
+	case ResolveBackup:
+		var err error
+		backup, err = fileutil.BackupPath(c.linkPath)
+		if err != nil {
+			return explainImmutable(err, c.linkPath)
+		}
+		sugar.Infof("Backed up existing file: %s", linkString(c.linkPath, backup))
+
+	case ResolveOverwrite:
+		sugar.Infof("Overwriting existing file at: %s", c.linkPath)
+		if err := fileutil.RemoveAll(c.linkPath); err != nil {
+			return fmt.Errorf("failed to remove existing file %s: %w", c.linkPath, explainImmutable(err, c.linkPath))
+		}
+	}
+
+	createLink(report, c.linkPath, c.targetPath, c.state, c.style, c.root, createDirs)
+	report.Entries[len(report.Entries)-1].Backup = backup
+	report.Entries[len(report.Entries)-1].OldTarget = oldTarget
+	return nil
+}
+
+// readOldTarget returns the target of the symlink at linkPath, about to be replaced,
+// or "" if it isn't a symlink.
+func readOldTarget(linkPath string) string {
+	if !fileutil.IsSymlink(linkPath) {
+		return ""
+	}
+	target, err := os.Readlink(linkPath)
+	if err != nil {
+		sugar.Debugf("Can't read the old target of %s: %v", linkPath, err)
+		return ""
+	}
+	return target
+}
+
+// promptConflict asks the user how to resolve a single conflict.
+func promptConflict(c conflict) Resolution {
+	if stringutil.AskForConfirmation("Preview diff of existing file at " + c.linkPath + "?") {
+		previewConflict(c)
+	}
+	if stringutil.AskForConfirmation("Delete existing file at " + c.linkPath + "?") {
+		return ResolveOverwrite
+	}
+	return ResolveSkip
+}
+
+// promptBatch asks for a single resolution covering every conflict in batch,
+// optionally previewing their diffs first.
+func promptBatch(batch []conflict) Resolution {
+	rows := make([][2]string, len(batch))
+	for i, c := range batch {
+		rows[i] = [2]string{c.linkPath, c.state.String()}
+	}
+	stringutil.PrintDotTable(rows)
+
+	for {
+		switch stringutil.AskForChoice("Resolve all shown: skip, backup, overwrite, or preview diffs?", []string{"s", "b", "o", "d"}, "s") {
+		case "b":
+			return ResolveBackup
+		case "o":
+			return ResolveOverwrite
+		case "d":
+			for _, c := range batch {
+				previewConflict(c)
+			}
+		default:
+			return ResolveSkip
+		}
+	}
+}
+
+// resolveInBatches prompts for pending conflicts size at a time and applies each decision.
+func resolveInBatches(report *Report, pending []conflict, size int, createDirs bool) error {
+	for start := 0; start < len(pending); start += size {
+		end := min(start+size, len(pending))
+		printEntry("Conflicts %d-%d of %d:", start+1, end, len(pending))
+
+		resolution := promptBatch(pending[start:end])
+		for _, c := range pending[start:end] {
+			if err := resolveConflict(report, c, resolution, createDirs); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// conflictGroup is a set of conflicts under a common directory.
+type conflictGroup struct {
+	dir       string
+	conflicts []conflict
+}
+
+// summary describes how many conflicts the group has, broken down by state.
+func (g conflictGroup) summary() string {
+	counts := map[LState]int{}
+	var states []LState
+	for _, c := range g.conflicts {
+		if counts[c.state] == 0 {
+			states = append(states, c.state)
+		}
+		counts[c.state]++
+	}
+
+	parts := make([]string, len(states))
+	for i, state := range states {
+		parts[i] = fmt.Sprintf("%d %s", counts[state], state)
+	}
+	return fmt.Sprintf("%d conflicts (%s)", len(g.conflicts), strings.Join(parts, ", "))
+}
+
+// groupConflicts groups conflicts by their parent directory, folding groups for
+// subdirectories into an ancestor directory that also has conflicts. Conflicts
+// directly under linkRoot are never used to absorb subdirectories, since that
+// would put every conflict in one group. Groups are returned sorted by directory.
+func groupConflicts(pending []conflict, linkRoot string) []conflictGroup {
+	byDir := map[string][]conflict{}
+	for _, c := range pending {
+		dir := filepath.Dir(c.linkPath)
+		byDir[dir] = append(byDir[dir], c)
+	}
+
+	dirs := make([]string, 0, len(byDir))
+	for dir := range byDir {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	var groups []conflictGroup
+	for _, dir := range dirs {
+		folded := false
+		for i := range groups {
+			if groups[i].dir == linkRoot {
+				continue
+			}
+			if isChild, _ := fileutil.IsChildPath(dir, groups[i].dir); isChild {
+				groups[i].conflicts = append(groups[i].conflicts, byDir[dir]...)
+				folded = true
+				break
+			}
+		}
+		if !folded {
+			groups = append(groups, conflictGroup{dir: dir, conflicts: byDir[dir]})
+		}
+	}
+	return groups
+}
+
+// resolveInGroups prompts once per directory group of pending conflicts and applies each decision.
+func resolveInGroups(report *Report, pending []conflict, linkRoot string, createDirs bool) error {
+	for _, g := range groupConflicts(pending, linkRoot) {
+		printEntry("%s: %s", g.dir, g.summary())
+
+		resolution := promptBatch(g.conflicts)
+		for _, c := range g.conflicts {
+			if err := resolveConflict(report, c, resolution, createDirs); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"lnkit/stringutil"
+
+	"github.com/fatih/color"
+)
+
+// builtinDiffer is the diff_tool value that never runs an external tool.
+const builtinDiffer = "builtin"
+
+// diffTools are the external tools PreviewDiff may run, with the arguments used if
+// diff_args isn't set. {old} and {new} stand for the files compared. Pagers are
+// turned off, since the tool is killed after diffTimeout.
+var diffTools = map[string][]string{
+	"git":       {"--no-pager", "diff", "--color", "--no-index", "{old}", "{new}"},
+	"diff":      {"-u", "{old}", "{new}"},
+	"colordiff": {"-u", "{old}", "{new}"},
+	"delta":     {"--paging=never", "{old}", "{new}"},
+	"difft":     {"{old}", "{new}"},
+	"icdiff":    {"{old}", "{new}"},
+}
+
+// Arguments that could make a diff tool run something else, e.g. a pager or git's
+// external diff drivers, are refused.
+var forbiddenDiffArgs = []string{"pager", "ext-diff", "textconv", "exec"}
+
+// diffEnv is all of the environment a diff tool gets.
+var diffEnv = []string{"PATH", "HOME", "TERM", "COLORTERM", "NO_COLOR", "LANG", "LC_ALL", "LC_CTYPE"}
+
+// diffTimeout is how long a diff tool may run before it is killed.
+const diffTimeout = 30 * time.Second
+
+// diffTool is an external diff command.
+type diffTool struct {
+	name string
+	args []string
+}
+
+// differ is the tool PreviewDiff runs, set from the config by loadOptions.
+var differ = diffTool{name: "git", args: diffTools["git"]}
+
+// differ parses the diff_tool and diff_args options.
+func (o Options) differ() (diffTool, error) {
+	if o.DiffTool == builtinDiffer {
+		if len(o.DiffArgs) > 0 {
+			return diffTool{}, fmt.Errorf("diff_args can't be used with the %s differ", builtinDiffer)
+		}
+		return diffTool{name: builtinDiffer}, nil
+	}
+
+	defaults, ok := diffTools[o.DiffTool]
+	if !ok {
+		allowed := []string{builtinDiffer}
+		for name := range diffTools {
+			allowed = append(allowed, name)
+		}
+		sort.Strings(allowed)
+		return diffTool{}, fmt.Errorf("diff tool %q isn't allowed, expected one of %s", o.DiffTool, strings.Join(allowed, ", "))
+	}
+	if len(o.DiffArgs) == 0 {
+		return diffTool{name: o.DiffTool, args: defaults}, nil
+	}
+
+	for _, arg := range o.DiffArgs {
+		for _, forbidden := range forbiddenDiffArgs {
+			if strings.Contains(strings.ToLower(arg), forbidden) {
+				return diffTool{}, fmt.Errorf("diff argument %q isn't allowed", arg)
+			}
+		}
+	}
+	if !slices.Contains(o.DiffArgs, "{old}") || !slices.Contains(o.DiffArgs, "{new}") {
+		return diffTool{}, fmt.Errorf("diff_args must include {old} and {new}")
+	}
+	return diffTool{name: o.DiffTool, args: o.DiffArgs}, nil
+}
+
+// command returns the tool's command line for comparing source with target.
+func (t diffTool) command(source, target string) []string {
+	r := strings.NewReplacer("{old}", source, "{new}", target)
+	args := make([]string, len(t.args))
+	for i, arg := range t.args {
+		args[i] = r.Replace(arg)
+	}
+	return args
+}
+
+// env returns the environment the tool runs with: only diffEnv from lnkit's own,
+// and for git, none of the user's or system's git config, which could run other tools.
+func (t diffTool) env() []string {
+	var env []string
+	for _, name := range diffEnv {
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	if t.name == "git" {
+		env = append(env, "GIT_CONFIG_NOSYSTEM=1", "GIT_CONFIG_GLOBAL="+os.DevNull)
+	}
+	return env
+}
+
+// run runs the tool on source and target, writing its output to w.
+func (t diffTool) run(w io.Writer, source, target string) error {
+	path, err := exec.LookPath(t.name)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), diffTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, path, t.command(source, target)...)
+	cmd.Env = t.env()
+	cmd.Stdout = w
+	cmd.Stderr = os.Stderr
+
+	err = cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%s timed out after %s", t.name, diffTimeout)
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return nil // Diff tools exit with 1 when the files differ
+	}
+	return err
+}
+
+// PreviewDiff writes the differences between two files to w with the configured diff
+// tool, or with the built-in differ if it is missing or fails.
+func PreviewDiff(w io.Writer, source, target string) error {
+	if differ.name != builtinDiffer {
+		err := differ.run(w, source, target)
+		if err == nil {
+			return nil
+		}
+		sugar.Debugf("Using the built-in differ, %s failed: %v", differ.name, err)
+	}
+	return builtinDiff(w, source, target)
+}
+
+// diffContext is how many unchanged lines the built-in differ shows around changes.
+const diffContext = 3
+
+// builtinDiff writes the changed lines between two text files, with some context.
+func builtinDiff(w io.Writer, source, target string) error {
+	old, err := os.ReadFile(source)
+	if err != nil {
+		return err
+	}
+	new, err := os.ReadFile(target)
+	if err != nil {
+		return err
+	}
+
+	lines, ok := stringutil.LineDiff(strings.Split(string(old), "\n"), strings.Split(string(new), "\n"))
+	if !ok {
+		fmt.Fprintf(w, "Files %s and %s differ (too large for the built-in differ)\n", source, target)
+		return nil
+	}
+
+	// Keep changed lines and the context around them
+	keep := make([]bool, len(lines))
+	for i, line := range lines {
+		if line[0] == ' ' {
+			continue
+		}
+		for j := max(0, i-diffContext); j <= min(len(lines)-1, i+diffContext); j++ {
+			keep[j] = true
+		}
+	}
+
+	red, green := color.New(color.FgRed).SprintFunc(), color.New(color.FgGreen).SprintFunc()
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", source, target)
+	skipped := false
+	for i, line := range lines {
+		if !keep[i] {
+			skipped = true
+			continue
+		}
+		if skipped {
+			fmt.Fprintln(w, "@@")
+			skipped = false
+		}
+		switch line[0] {
+		case '-':
+			fmt.Fprintln(w, red(line))
+		case '+':
+			fmt.Fprintln(w, green(line))
+		default:
+			fmt.Fprintln(w, line)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+
+	"lnkit/fileutil"
+	"lnkit/manifest"
+	"lnkit/stringutil"
+
+	"github.com/spf13/cobra"
+)
+
+func NewDoctorCmd() *cobra.Command {
+	var opts linkOptions
+	var configPath string
+
+	cmd := &cobra.Command{
+		Use:   "doctor [target_path]",
+		Short: "Show the environment lnkit runs in, to explain differences between machines",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			targetPath := "."
+			if len(args) == 1 {
+				targetPath = args[0]
+			}
+			targetPath, err := fileutil.ExpandPath(targetPath)
+			if err != nil {
+				return fmt.Errorf("failed to expand target path: %w", err)
+			}
+
+			cfg, err := loadOptions(cmd, configPath, targetPath, &opts)
+			if err != nil {
+				return err
+			}
+
+			umask := "n/a"
+			if mask, ok := fileutil.Umask(); ok {
+				umask = fmt.Sprintf("%04o", mask)
+			}
+			stateDir, err := manifest.StateDir()
+			if err != nil {
+				stateDir = err.Error()
+			}
+
+			rows := [][2]string{
+				{"platform", runtime.GOOS + "/" + runtime.GOARCH},
+				{"umask", umask + " (not used for lnkit's own directories and files)"},
+				{"dir mode", fmt.Sprintf("%04o", fileutil.DirMode())},
+				{"state dir", stateDir},
+				{"link style", string(opts.linkStyle)},
+				{"managed marker", cfg.Options.ManagedMarker},
+				{"network fs", fmt.Sprint(cfg.Options.NetworkFS)},
+				{"read-only", fmt.Sprint(fileutil.IsReadOnly())},
+				{"selinux", fmt.Sprint(selinuxEnabled())},
+			}
+
+			linkRoot, err := repoLinkRoot(targetPath, cfg)
+			if err != nil {
+				return err
+			}
+			hints, err := checkPackages(linkRoot, targetPath, opts)
+			if err != nil {
+				return err
+			}
+			for _, h := range hints {
+				rows = append(rows, [2]string{h.Source, h.String()})
+			}
+
+			if selinuxEnabled() {
+				m, err := loadManifest(linkRoot, targetPath)
+				if err != nil {
+					return err
+				}
+				mismatches, err := contextMismatches(m)
+				if err != nil {
+					rows = append(rows, [2]string{"selinux", err.Error()})
+				}
+				for _, line := range mismatches {
+					rows = append(rows, [2]string{"selinux context", line})
+				}
+			}
+
+			stringutil.PrintDotTable(rows)
+			return nil
+		},
+		Example: `
+			lnk doctor
+			lnk doctor ~/dotfiles
+		`,
+	}
+	cmd.Flags().StringVar(&configPath, "config", "", "Config file (default: lnkit.toml in target_path)")
+
+	return cmd
+}
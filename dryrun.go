@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	api "lnkit/api/v1"
+)
+
+// exitPending is the exit code of a dry run that found changes to make, so scripts
+// can tell it apart from one with nothing to do (0) and from an error (1).
+const exitPending = 2
+
+// exitError makes lnk exit with code instead of 1, printing msg if it isn't empty.
+type exitError struct {
+	code int
+	msg  string
+}
+
+func (e *exitError) Error() string {
+	return e.msg
+}
+
+// hasChanges reports whether applying plan would change anything: create a link,
+// or deal with something in the way of one.
+func hasChanges(plan *Report) bool {
+	for _, e := range plan.Entries {
+		if e.Status == StatusPending || e.Status == StatusConflict {
+			return true
+		}
+	}
+	return false
+}
+
+// printPlan writes what applying plan would do, as JSON in the form of api/v1 if
+// jsonOut is set.
+func printPlan(w io.Writer, plan *Report, jsonOut bool) error {
+	if jsonOut {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(api.NewPlan(plan.Entries))
+	}
+	for _, e := range plan.Entries {
+		switch e.Status {
+		case StatusPending:
+			if e.Reason != "" {
+				fmt.Fprintf(w, "Would link [%s]: %s\n", e.Reason, linkString(e.LinkPath, e.TargetPath))
+				continue
+			}
+			fmt.Fprintf(w, "Would link: %s\n", linkString(e.LinkPath, e.TargetPath))
+		case StatusConflict:
+			fmt.Fprintf(w, "Conflict [%s]: %s\n", e.Reason, e.LinkPath)
+		}
+	}
+	fmt.Fprintf(w, "Plan: %s\n", plan.Summary())
+	return nil
+}
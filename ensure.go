@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"lnkit/fileutil"
+	"lnkit/stringutil"
+
+	"github.com/spf13/cobra"
+)
+
+// ensureFailed reports whether an entry keeps a run of ensure from succeeding.
+func ensureFailed(e Entry) bool {
+	switch e.Status {
+	case StatusFailed, StatusConflict:
+		return true
+	case StatusSkipped:
+		return e.Reason == ReasonConflictModified || e.Reason == ReasonConflictMislinked
+	}
+	return false
+}
+
+// ensureChanged reports whether an entry was changed on disk.
+func ensureChanged(e Entry) bool {
+	return e.Status == StatusLinked || e.Status == StatusRenamed
+}
+
+// writeDiagnostics writes everything about a failed run: each entry that failed
+// or is in the way, then the full report.
+func writeDiagnostics(w io.Writer, report *Report, err error) {
+	if err != nil {
+		fmt.Fprintf(w, "error: %v\n", err)
+	}
+	if report == nil {
+		return
+	}
+	for _, e := range report.Entries {
+		if !ensureFailed(e) {
+			continue
+		}
+		line := fmt.Sprintf("%s [%s]: %s (%s)", e.Status, e.Reason, linkString(e.LinkPath, e.TargetPath), e.State)
+		if e.Message != "" {
+			line += ": " + e.Message
+		}
+		fmt.Fprintln(w, line)
+	}
+	fmt.Fprintln(w, "full report:")
+	report.WriteJSON(w)
+}
+
+// runEnsure links linkRoot to targetRoot without ever prompting. It checks first and
+// changes nothing if anything is in the way, unless opts.force is set. It returns the
+// report of what it changed, or nil if everything was already in place.
+func runEnsure(linkRoot, targetRoot string, cfg Config, opts linkOptions) (*Report, error) {
+	report, err := planLinks(linkRoot, targetRoot, cfg, opts)
+	if err != nil {
+		return report, err
+	}
+
+	pending, conflicts := 0, 0
+	for _, e := range report.Entries {
+		switch e.Status {
+		case StatusPending:
+			pending++
+		case StatusConflict:
+			conflicts++
+		}
+	}
+	if conflicts > 0 && !opts.force {
+		return report, fmt.Errorf("%d conflicts in %s, nothing was changed", conflicts, linkRoot)
+	}
+	if pending == 0 && conflicts == 0 {
+		return nil, nil
+	}
+	if err := checkSpace(linkRoot, report); err != nil {
+		return report, err
+	}
+	if _, err := checkImmutable(report, false); err != nil {
+		return report, err
+	}
+
+	// Anything that turned up in the way since the check is skipped, not asked about
+	stringutil.SetInput(strings.NewReader(""))
+	defer stringutil.SetInput(os.Stdin)
+
+	report, err = createSymlinks(linkRoot, targetRoot, opts)
+	if err == nil {
+		err = recordLinks(report, linkRoot, targetRoot, cfg.Options.ManagedMarker)
+	}
+	if err == nil {
+		var baseReport *Report
+		baseReport, err = linkBase(linkRoot, targetRoot, cfg, opts)
+		if baseReport != nil {
+			report.Entries = append(report.Entries, baseReport.Entries...)
+		}
+	}
+	if err == nil && cfg.Options.SELinuxRestorecon && selinuxEnabled() {
+		err = restoreContexts(createdPaths(report))
+	}
+	if err != nil {
+		return report, err
+	}
+	for _, e := range report.Entries {
+		if ensureFailed(e) {
+			return report, fmt.Errorf("failed to link everything from %s into %s", targetRoot, linkRoot)
+		}
+	}
+	return report, nil
+}
+
+func NewEnsureCmd() *cobra.Command {
+	var opts linkOptions
+	var configPath string
+
+	cmd := &cobra.Command{
+		Use:   "ensure link_path target_path",
+		Short: "Link non-interactively for provisioning scripts: silent if nothing changed, one line if something did",
+		Long: `Link target_path into link_path without ever prompting, for cloud-init, image builds,
+and other provisioning scripts. Running it again is a no-op.
+
+Prints nothing and exits 0 if everything was already linked, prints a single summary
+line and exits 0 if links were created, and prints full diagnostics to stderr and
+exits non-zero if anything failed or is in the way. Without --force, nothing is
+changed when something is in the way.`,
+		Args:          cobra.ExactArgs(2),
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Only failures are worth printing, and those are in the diagnostics
+			quietLogs("Fatal")
+			entryOut = io.Discard
+
+			linkPath, err := fileutil.ExpandPath(args[0])
+			if err != nil {
+				return fmt.Errorf("failed to expand link path: %w", err)
+			}
+			targetPath, err := fileutil.ExpandPath(args[1])
+			if err != nil {
+				return fmt.Errorf("failed to expand target path: %w", err)
+			}
+
+			cfg, err := loadOptions(cmd, configPath, targetPath, &opts)
+			if err != nil {
+				writeDiagnostics(cmd.ErrOrStderr(), nil, err)
+				return err
+			}
+
+			if opts.force {
+				if err := checkCleanSource(targetPath, cfg.Options.RequireCleanSource); err != nil {
+					writeDiagnostics(cmd.ErrOrStderr(), nil, err)
+					return err
+				}
+			}
+
+			report, err := runEnsure(linkPath, targetPath, cfg, opts)
+			if err != nil {
+				writeDiagnostics(cmd.ErrOrStderr(), report, err)
+				return err
+			}
+			if report == nil {
+				return nil
+			}
+
+			changed := 0
+			for _, e := range report.Entries {
+				if ensureChanged(e) {
+					changed++
+				}
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "lnk: %d changed in %s (%s)\n", changed, linkPath, report.Summary())
+			return nil
+		},
+		Example: `
+			lnk ensure --rec ~ ~/dotfiles
+			lnk ensure --rec --force /home/me /opt/image/dotfiles
+		`,
+	}
+	cmd.Flags().BoolVar(&opts.recursive, "rec", false, "Recursively process nested directories")
+	cmd.Flags().BoolVar(&opts.fold, "fold", false, "Link whole directories where applicable")
+	cmd.Flags().BoolVar(&opts.force, "force", false, "Replace whatever is in the way instead of failing")
+	cmd.Flags().BoolVar(&opts.createDirs, "create-dirs", true, "Create dirs")
+	cmd.Flags().StringVar(&configPath, "config", "", "Config file (default: lnkit.toml in target_path)")
+
+	return cmd
+}
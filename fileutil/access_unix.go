@@ -0,0 +1,15 @@
+//go:build linux || darwin
+
+package fileutil
+
+import (
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// CanCreate reports whether the current user may create path, judging by write
+// access to its closest existing parent directory.
+func CanCreate(path string) bool {
+	return unix.Access(existingAncestor(filepath.Dir(path)), unix.W_OK) == nil
+}
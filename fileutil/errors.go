@@ -0,0 +1,39 @@
+package fileutil
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PatternError is returned for a malformed ignore pattern.
+type PatternError struct {
+	Pattern string
+	Err     error
+}
+
+func (e *PatternError) Error() string {
+	return fmt.Sprintf("invalid pattern %q: %v", e.Pattern, e.Err)
+}
+
+func (e *PatternError) Unwrap() error {
+	return e.Err
+}
+
+// InvalidPathError is returned for a path lnkit refuses to work with, e.g. one
+// containing a NUL byte, rather than passing it on to the filesystem.
+type InvalidPathError struct {
+	Path   string
+	Reason string
+}
+
+func (e *InvalidPathError) Error() string {
+	return fmt.Sprintf("invalid path %q: %s", e.Path, e.Reason)
+}
+
+// checkPath returns an InvalidPathError if path can't safely be used as a path.
+func checkPath(path string) error {
+	if strings.ContainsRune(path, 0) {
+		return &InvalidPathError{Path: path, Reason: "contains a NUL byte"}
+	}
+	return nil
+}
@@ -80,6 +80,26 @@ func HashFile(path string) ([]byte, error) {
 	return hash.Sum(nil), nil
 }
 
+// binarySniffLen is how much of a file IsBinary looks at, as git does.
+const binarySniffLen = 8000
+
+// IsBinary reports whether the file at path looks binary, i.e. has a NUL byte
+// in its first few kilobytes.
+func IsBinary(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	buf := make([]byte, binarySniffLen)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, fmt.Errorf("error reading file %s: %v", path, err)
+	}
+	return bytes.IndexByte(buf[:n], 0) >= 0, nil
+}
+
 // compareFileHashes compares the hashes of two files.
 func CompareFileHashes(file1, file2 string) (bool, error) {
 	hash1, err := HashFile(file1)
@@ -176,12 +196,12 @@ func CompareDirHashes(dir1, dir2 string) ([]string, error) {
 }
 
 // MatchesAnyPattern checks if `value` matches any of the patterns in the list.
-// Returns true if matched, or error if any pattern is invalid.
+// Returns true if matched, or a *PatternError if any pattern is invalid.
 func MatchesPatterns(value string, patterns []string) (bool, error) {
 	for _, pattern := range patterns {
 		matched, err := filepath.Match(pattern, value)
 		if err != nil {
-			return false, fmt.Errorf("error matching pattern %q: %w", pattern, err)
+			return false, &PatternError{Pattern: pattern, Err: err}
 		}
 		if matched {
 			return true, nil
@@ -190,26 +210,121 @@ func MatchesPatterns(value string, patterns []string) (bool, error) {
 	return false, nil
 }
 
+// ExpandPath expands ~, environment variables, and XDG and sandbox path variables
+// (see XDGConfigVar and AppConfigVar) in path, and makes it absolute. XDG
+// environment variables that are unset expand to their defaults. Paths that can't be used
+// safely, before or after expansion, give an *InvalidPathError.
 func ExpandPath(path string) (string, error) {
+	expanded, err := expandVars(path, currentHome)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(expanded)
+}
+
+// ExpandPathInRoot expands path like ExpandPath, but as seen from inside root, e.g. a
+// system mounted at /mnt: ~ is the user's home directory in root's /etc/passwd, and
+// relative paths are relative to the working directory if it is inside root, or to
+// root itself if not. It returns where that is on the host, under root.
+func ExpandPathInRoot(path, root string) (string, error) {
+	expanded, err := expandVars(path, func() (string, error) { return homeInRoot(root) })
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(expanded) {
+		dir := string(filepath.Separator)
+		if cwd, err := os.Getwd(); err == nil {
+			if rel, err := filepath.Rel(root, cwd); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				dir = filepath.Join(dir, rel)
+			}
+		}
+		expanded = filepath.Join(dir, expanded)
+	}
+	return filepath.Join(root, expanded), nil
+}
+
+// expandVars expands ~ and variables in path as ExpandPath describes, leaving it
+// relative if it is. home is only called for paths that need the home directory,
+// as there may be no user entry to look it up in.
+func expandVars(path string, home func() (string, error)) (string, error) {
+	if err := checkPath(path); err != nil {
+		return "", err
+	}
+
+	var homeDir string
+	var homeErr error
+	lookupHome := func() string {
+		if homeDir == "" && homeErr == nil {
+			homeDir, homeErr = home()
+		}
+		return homeDir
+	}
+
 	// Expand ~ to the home directory
+	original := path
 	if strings.HasPrefix(path, "~") {
-		usr, err := user.Current()
-		if err != nil {
-			return "", err
+		path = filepath.Join(lookupHome(), strings.TrimPrefix(path, "~"))
+	}
+
+	// Expand XDG, sandbox, and environment variables
+	var varErr error
+	path = os.Expand(path, func(name string) string {
+		if dir, ok := expandXDGVar(lookupHome, name); ok {
+			return dir
 		}
-		path = filepath.Join(usr.HomeDir, strings.TrimPrefix(path, "~"))
+		if dir, ok, err := expandSandboxVar(lookupHome, name); ok {
+			if err != nil && varErr == nil {
+				varErr = &InvalidPathError{Path: original, Reason: err.Error()}
+			}
+			return dir
+		}
+		return os.Getenv(name)
+	})
+	if homeErr != nil {
+		return "", homeErr
+	}
+	if varErr != nil {
+		return "", varErr
 	}
 
-	// Expand environment variables
-	path = os.ExpandEnv(path)
+	// Variables could have brought in anything
+	if err := checkPath(path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
 
-	// Make path absolute
-	absPath, err := filepath.Abs(path)
+// currentHome returns the home directory of the current user.
+func currentHome() (string, error) {
+	usr, err := user.Current()
 	if err != nil {
 		return "", err
 	}
+	return usr.HomeDir, nil
+}
 
-	return absPath, nil
+// homeInRoot returns the home directory of the current user according to root's
+// /etc/passwd, or the one on the host if root doesn't list them.
+func homeInRoot(root string) (string, error) {
+	usr, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(filepath.Join(root, "etc", "passwd"))
+	if err != nil {
+		return usr.HomeDir, nil
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// name:password:uid:gid:gecos:home:shell
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) >= 6 && fields[0] == usr.Username && filepath.IsAbs(fields[5]) {
+			return fields[5], nil
+		}
+	}
+	return usr.HomeDir, nil
 }
 
 func ReadFileLines(filePath string, ignoreBlank bool) ([]string, error) {
@@ -246,12 +361,17 @@ func ReadFileLines(filePath string, ignoreBlank bool) ([]string, error) {
 
 // Create a symlink
 // CreateSymlink creates a symlink at linkPath pointing to targetPath.
-// If createDirs is true, it ensures the parent directory of linkPath exists.
+// If createDirs is true, it ensures the parent directory of linkPath exists, creating
+// missing directories with DirMode.
 // It returns an error if the symlink already exists or the path is taken.
 func CreateSymlink(linkPath, targetPath string, createDirs bool) error {
+	if err := CheckWritable("create symlink", linkPath); err != nil {
+		return err
+	}
+
 	if createDirs {
 		parent := filepath.Dir(linkPath)
-		if err := os.MkdirAll(parent, 0755); err != nil {
+		if err := MkdirAll(parent, DirMode()); err != nil {
 			return fmt.Errorf("failed to create parent directories for %s: %w", linkPath, err)
 		}
 	}
@@ -268,11 +388,55 @@ func CreateSymlink(linkPath, targetPath string, createDirs bool) error {
 		return fmt.Errorf("failed to create symlink: %w", err)
 	}
 
-	return nil
+	if err := syncDir(filepath.Dir(linkPath)); err != nil {
+		return err
+	}
+	return settle(linkPath, func() bool {
+		target, err := os.Readlink(linkPath)
+		return err == nil && target == targetPath
+	})
+}
+
+// MissingParents returns the directories that would have to be created to hold
+// path, outermost first.
+func MissingParents(path string) []string {
+	var missing []string
+	for dir := filepath.Dir(path); !PathExists(dir); dir = filepath.Dir(dir) {
+		missing = append([]string{dir}, missing...)
+		if dir == filepath.Dir(dir) {
+			break
+		}
+	}
+	return missing
+}
+
+// RemoveEmptyDir deletes the directory at path if it is empty, and does nothing
+// if it isn't or doesn't exist. It returns true if the directory is gone.
+func RemoveEmptyDir(path string) (bool, error) {
+	if err := CheckWritable("remove directory", path); err != nil {
+		return false, err
+	}
+	entries, err := os.ReadDir(path)
+	if os.IsNotExist(err) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	if len(entries) > 0 {
+		return false, nil
+	}
+	if err := os.Remove(path); err != nil {
+		return false, fmt.Errorf("failed to remove directory %s: %w", path, err)
+	}
+	return true, nil
 }
 
 // RemoveSymlink deletes a symlink at the given path if it exists and is a symlink.
 func RemoveSymlink(path string) error {
+	if err := CheckWritable("remove symlink", path); err != nil {
+		return err
+	}
+
 	info, err := os.Lstat(path)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -289,18 +453,16 @@ func RemoveSymlink(path string) error {
 		return fmt.Errorf("failed to remove symlink %s: %w", path, err)
 	}
 
-	return nil
+	if err := syncDir(filepath.Dir(path)); err != nil {
+		return err
+	}
+	return settle(path, func() bool { return !PathExists(path) })
 }
 
 // IsSymlinkPointingTo returns true if `path` is a symlink that points to `target`.
 // It resolves relative symlink targets to absolute paths for accurate comparison.
 func IsSymlinkPointingTo(symlink, target string) (bool, error) {
-	linkTarget, err := os.Readlink(symlink)
-	if err != nil {
-		return false, err
-	}
-
-	linkTargetAbs, err := filepath.Abs(linkTarget)
+	linkTargetAbs, err := ReadLinkAbs(symlink)
 	if err != nil {
 		return false, err
 	}
@@ -336,8 +498,86 @@ const (
 	ExistsModified                   // Regular file or dir exists, content differs from source
 )
 
-// Determine the state of a symlink linking target to source (target ~> source)
-func GetLinkState(targetAbs, sourceAbs string) (LinkState, error) {
+// IsSymlinkResolvingTo returns true if `symlink` is a symlink that ends up at the same
+// path as `target` once both are resolved, e.g. via a symlinked parent (/home -> /Users).
+// A hardlink to `target` is the same file, but not a link to it.
+func IsSymlinkResolvingTo(symlink, target string) (bool, error) {
+	return IsSymlinkResolvingToInRoot(symlink, target, "")
+}
+
+// IsSymlinkResolvingToInRoot is IsSymlinkResolvingTo for paths under root, resolving
+// links as seen from inside it (see EvalSymlinksInRoot).
+func IsSymlinkResolvingToInRoot(symlink, target, root string) (bool, error) {
+	if !IsSymlink(symlink) {
+		return false, nil
+	}
+	resolved, err := EvalSymlinksInRoot(symlink, root)
+	if err != nil {
+		return false, err
+	}
+	resolvedTarget, err := EvalSymlinksInRoot(target, root)
+	if err != nil {
+		return false, err
+	}
+	return resolved == resolvedTarget, nil
+}
+
+// EvalSymlinksInRoot is filepath.EvalSymlinks for a path under root, as if root were
+// /: absolute link targets are followed inside root rather than on the host, and
+// nothing leads out of it. An empty root is the host's own.
+func EvalSymlinksInRoot(path, root string) (string, error) {
+	if root == "" {
+		return filepath.EvalSymlinks(path)
+	}
+
+	sep := string(filepath.Separator)
+	rest := strings.Split(StripRoot(root, path), sep)
+	resolved := sep
+	for links := 0; len(rest) > 0; {
+		name := rest[0]
+		rest = rest[1:]
+		switch name {
+		case "", ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+
+		next := filepath.Join(resolved, name)
+		info, err := os.Lstat(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		if links++; links > 255 {
+			return "", fmt.Errorf("too many links resolving %s", path)
+		}
+		target, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			resolved = sep
+		}
+		rest = append(strings.Split(target, sep), rest...)
+	}
+	return filepath.Join(root, resolved), nil
+}
+
+// Determine the state of a symlink linking target to source (target ~> source).
+// Unless strict is set, a symlink reaching source through an equivalent path
+// (see IsSymlinkResolvingTo) counts as AlreadyLinked rather than Mislinked.
+func GetLinkState(targetAbs, sourceAbs string, strict bool) (LinkState, error) {
+	return GetLinkStateInRoot(targetAbs, sourceAbs, "", strict)
+}
+
+// GetLinkStateInRoot is GetLinkState for links inside an alternate root (e.g. a
+// new system mounted at /mnt), whose absolute link targets are relative to root.
+func GetLinkStateInRoot(targetAbs, sourceAbs, root string, strict bool) (LinkState, error) {
 
 	if !filepath.IsAbs(sourceAbs) {
 		return Missing, fmt.Errorf("sourceAbs: expected absolute path, got: %s", sourceAbs)
@@ -353,7 +593,11 @@ func GetLinkState(targetAbs, sourceAbs string) (LinkState, error) {
 
 	// Target is a symlink
 	if IsSymlink(targetAbs) {
-		linked, _ := IsSymlinkPointingTo(targetAbs, sourceAbs)
+		linkTargetAbs, _ := ReadLinkInRoot(targetAbs, root)
+		linked := linkTargetAbs == filepath.Clean(sourceAbs)
+		if !linked && !strict {
+			linked, _ = IsSymlinkResolvingToInRoot(targetAbs, sourceAbs, root)
+		}
 		if linked {
 			return AlreadyLinked, nil
 		} else {
@@ -370,3 +614,70 @@ func GetLinkState(targetAbs, sourceAbs string) (LinkState, error) {
 
 	return ExistsModified, nil
 }
+
+// BackupPath moves whatever is at path (file, dir, or symlink) out of the way to
+// path.bak, or path.bak.N if that is taken, and returns where it was moved to.
+func BackupPath(path string) (string, error) {
+	if err := CheckWritable("back up", path); err != nil {
+		return "", err
+	}
+
+	backup := path + ".bak"
+	for i := 1; PathExists(backup); i++ {
+		backup = fmt.Sprintf("%s.bak.%d", path, i)
+	}
+
+	if err := os.Rename(path, backup); err != nil {
+		return "", fmt.Errorf("failed to back up %s: %w", path, err)
+	}
+
+	return backup, syncDir(filepath.Dir(path))
+}
+
+// RelativeLinkTarget returns targetPath relative to the directory containing linkPath,
+// i.e. the string to store in a symlink at linkPath so that it resolves to targetPath.
+func RelativeLinkTarget(linkPath, targetPath string) (string, error) {
+	for _, path := range []string{linkPath, targetPath} {
+		if !filepath.IsAbs(path) {
+			return "", &InvalidPathError{Path: path, Reason: "expected an absolute path"}
+		}
+		if err := checkPath(path); err != nil {
+			return "", err
+		}
+	}
+	return filepath.Rel(filepath.Dir(linkPath), targetPath)
+}
+
+// ReadLinkAbs returns the absolute path the symlink at path points to, resolving
+// relative link targets against the directory containing the symlink.
+func ReadLinkAbs(path string) (string, error) {
+	return ReadLinkInRoot(path, "")
+}
+
+// ReadLinkInRoot is ReadLinkAbs for a symlink inside an alternate root: absolute
+// link targets are taken to be relative to root, as they would be after a chroot.
+func ReadLinkInRoot(path, root string) (string, error) {
+	target, err := os.Readlink(path)
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(path), target)
+	} else if root != "" {
+		target = filepath.Join(root, target)
+	}
+	return filepath.Abs(target)
+}
+
+// StripRoot returns path as it appears inside root, e.g. /mnt/new/etc/hosts is
+// /etc/hosts inside /mnt/new. Paths outside root are returned unchanged.
+func StripRoot(root, path string) string {
+	if root == "" {
+		return path
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return path
+	}
+	return filepath.Join(string(filepath.Separator), rel)
+}
@@ -1,8 +1,11 @@
 package fileutil
 
 import (
+	"errors"
 	"os"
+	"os/user"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -28,6 +31,21 @@ func TestIsRegularFile(t *testing.T) {
 	}
 }
 
+func TestIsBinary(t *testing.T) {
+	dir := t.TempDir()
+	text := filepath.Join(dir, "text")
+	bin := filepath.Join(dir, "bin")
+	os.WriteFile(text, []byte("plain text\n"), 0644)
+	os.WriteFile(bin, []byte{0x7f, 'E', 'L', 'F', 0, 1}, 0644)
+
+	if binary, err := IsBinary(text); err != nil || binary {
+		t.Errorf("expected text file not to be binary, got %v, %v", binary, err)
+	}
+	if binary, err := IsBinary(bin); err != nil || !binary {
+		t.Errorf("expected file with NUL bytes to be binary, got %v, %v", binary, err)
+	}
+}
+
 func TestIsSymlink(t *testing.T) {
 	dir := t.TempDir()
 	target := filepath.Join(dir, "target")
@@ -112,3 +130,449 @@ func TestMatchesPatterns(t *testing.T) {
 		t.Errorf("expected 'file12.md' not to match patterns")
 	}
 }
+
+func TestAppConfigDir(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", "")
+
+	if got, want := AppConfigDir(home, "org.alacritty.Alacritty"), filepath.Join(home, ".config"); got != want {
+		t.Errorf("native install: got %q, want %q", got, want)
+	}
+
+	os.MkdirAll(filepath.Join(home, "snap", "alacritty"), 0755)
+	if got, want := AppConfigDir(home, "org.alacritty.Alacritty"), SnapConfigDir(home, "alacritty"); got != want {
+		t.Errorf("snap install: got %q, want %q", got, want)
+	}
+
+	os.MkdirAll(filepath.Join(home, ".var", "app", "org.alacritty.Alacritty"), 0755)
+	if got, want := AppConfigDir(home, "org.alacritty.Alacritty"), FlatpakConfigDir(home, "org.alacritty.Alacritty"); got != want {
+		t.Errorf("flatpak install: got %q, want %q", got, want)
+	}
+}
+
+func TestExpandPathSandboxVars(t *testing.T) {
+	got, err := ExpandPath("/base/${flatpak_config:org.foo.Bar}/x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(got, filepath.Join(".var", "app", "org.foo.Bar", "config", "x")) {
+		t.Errorf("expected flatpak config dir in %q", got)
+	}
+
+	// Ids can't lead out of the app's directory
+	for _, path := range []string{"${flatpak_config:../../etc}", "${app_config:org.foo/../..}", "${flatpak_config:/etc}", "${snap_config:../x}"} {
+		var invalid *InvalidPathError
+		if _, err := ExpandPath(path); !errors.As(err, &invalid) {
+			t.Errorf("ExpandPath(%q) = %v, want an InvalidPathError", path, err)
+		}
+	}
+
+	t.Setenv("LNKIT_TEST_VAR", "plain")
+	got, err = ExpandPath("/base/$LNKIT_TEST_VAR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "/base/plain" {
+		t.Errorf("expected environment variable to expand, got %q", got)
+	}
+}
+
+func TestBackupPath(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file.txt")
+
+	os.WriteFile(file, []byte("one"), 0644)
+	backup, err := BackupPath(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backup != file+".bak" || PathExists(file) {
+		t.Errorf("expected %q to be moved to %q, got %q", file, file+".bak", backup)
+	}
+
+	os.WriteFile(file, []byte("two"), 0644)
+	backup, err = BackupPath(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backup != file+".bak.1" {
+		t.Errorf("expected second backup at %q, got %q", file+".bak.1", backup)
+	}
+}
+
+func TestRelativeLinkTarget(t *testing.T) {
+	got, err := RelativeLinkTarget("/home/u/.config/nvim", "/home/u/dotfiles/nvim")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "../dotfiles/nvim" {
+		t.Errorf("RelativeLinkTarget() = %q; want %q", got, "../dotfiles/nvim")
+	}
+
+	if _, err := RelativeLinkTarget("relative/link", "/abs/target"); err == nil {
+		t.Errorf("expected error for relative link path")
+	}
+}
+
+func TestIsSymlinkPointingToRelative(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "dots", "file")
+	link := filepath.Join(dir, "home", "file")
+	os.MkdirAll(filepath.Dir(target), 0755)
+	os.MkdirAll(filepath.Dir(link), 0755)
+	os.WriteFile(target, []byte("hi"), 0644)
+	os.Symlink("../dots/file", link)
+
+	linked, err := IsSymlinkPointingTo(link, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !linked {
+		t.Errorf("expected relative symlink %q to point to %q", link, target)
+	}
+}
+
+func TestGetLinkStateEquivalentPath(t *testing.T) {
+	dir := t.TempDir()
+	real := filepath.Join(dir, "real")
+	alias := filepath.Join(dir, "alias") // Like a /home -> /Users symlink
+	os.Mkdir(real, 0755)
+	os.Symlink(real, alias)
+
+	source := filepath.Join(real, "source")
+	link := filepath.Join(dir, "link")
+	os.WriteFile(source, []byte("hi"), 0644)
+	os.Symlink(filepath.Join(alias, "source"), link)
+
+	state, err := GetLinkState(link, source, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state != AlreadyLinked {
+		t.Errorf("expected link through equivalent path to be AlreadyLinked, got %v", state)
+	}
+
+	state, err = GetLinkState(link, source, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state != Mislinked {
+		t.Errorf("expected strict matching to report Mislinked, got %v", state)
+	}
+}
+
+func TestIsSymlinkResolvingToHardlink(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source")
+	hardlink := filepath.Join(dir, "hardlink")
+	link := filepath.Join(dir, "link")
+	os.WriteFile(source, []byte("hi"), 0644)
+	if err := os.Link(source, hardlink); err != nil {
+		t.Skipf("can't hardlink here: %v", err)
+	}
+	os.Symlink(hardlink, link)
+
+	// Both are the same file as source, but neither is a link to it
+	for _, path := range []string{hardlink, link} {
+		if ok, err := IsSymlinkResolvingTo(path, source); err != nil || ok {
+			t.Errorf("IsSymlinkResolvingTo(%s) = %v, %v, want false", filepath.Base(path), ok, err)
+		}
+	}
+}
+
+func TestStripRoot(t *testing.T) {
+	tests := []struct{ root, path, want string }{
+		{"/mnt/new", "/mnt/new/etc/hosts", "/etc/hosts"},
+		{"/mnt/new", "/mnt/new", "/"},
+		{"/mnt/new", "/mnt/newer/etc", "/mnt/newer/etc"},
+		{"", "/etc/hosts", "/etc/hosts"},
+	}
+	for _, tt := range tests {
+		if got := StripRoot(tt.root, tt.path); got != tt.want {
+			t.Errorf("StripRoot(%q, %q) = %q, want %q", tt.root, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestReadLinkInRoot(t *testing.T) {
+	root := t.TempDir()
+	link := filepath.Join(root, "link")
+	os.Symlink("/etc/hosts", link)
+
+	target, err := ReadLinkInRoot(link, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(root, "etc", "hosts"); target != want {
+		t.Errorf("expected absolute link target inside root to resolve to %q, got %q", want, target)
+	}
+}
+
+func TestEvalSymlinksInRoot(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "real"), 0755)
+	os.WriteFile(filepath.Join(root, "real", "file"), []byte("hi"), 0644)
+	os.Symlink("/real", filepath.Join(root, "alias"))      // Absolute, so inside the root
+	os.Symlink("/alias/file", filepath.Join(root, "link")) // Through another link
+	os.Symlink("../../..", filepath.Join(root, "up"))
+
+	got, err := EvalSymlinksInRoot(filepath.Join(root, "link"), root)
+	if err != nil || got != filepath.Join(root, "real", "file") {
+		t.Errorf("EvalSymlinksInRoot(link) = %q, %v, want the file inside the root", got, err)
+	}
+	got, err = EvalSymlinksInRoot(filepath.Join(root, "up", "real"), root)
+	if err != nil || got != filepath.Join(root, "real") {
+		t.Errorf("EvalSymlinksInRoot(up/real) = %q, %v, want it to stay inside the root", got, err)
+	}
+}
+
+func TestExpandPathInRoot(t *testing.T) {
+	root := t.TempDir()
+	usr, err := user.Current()
+	if err != nil {
+		t.Skipf("no current user: %v", err)
+	}
+	os.MkdirAll(filepath.Join(root, "etc"), 0755)
+	os.MkdirAll(filepath.Join(root, "work"), 0755)
+	passwd := usr.Username + ":x:1000:1000::/home/inroot:/bin/sh\n"
+	os.WriteFile(filepath.Join(root, "etc", "passwd"), []byte(passwd), 0644)
+
+	// ~ is the home directory inside the root, not the host's
+	got, err := ExpandPathInRoot("~/x", root)
+	if want := filepath.Join(root, "home", "inroot", "x"); err != nil || got != want {
+		t.Errorf("ExpandPathInRoot(~/x) = %q, %v, want %q", got, err, want)
+	}
+
+	// Relative paths are relative to the working directory inside the root, or the root
+	got, err = ExpandPathInRoot("x", root)
+	if want := filepath.Join(root, "x"); err != nil || got != want {
+		t.Errorf("ExpandPathInRoot(x) from outside = %q, %v, want %q", got, err, want)
+	}
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	if err := os.Chdir(filepath.Join(root, "work")); err != nil {
+		t.Fatal(err)
+	}
+	got, err = ExpandPathInRoot("x", root)
+	if want := filepath.Join(root, "work", "x"); err != nil || got != want {
+		t.Errorf("ExpandPathInRoot(x) from inside = %q, %v, want %q", got, err, want)
+	}
+}
+
+func TestWriteFileAtomicNetworkSafe(t *testing.T) {
+	for _, safe := range []bool{false, true} {
+		SetNetworkSafe(safe)
+		path := filepath.Join(t.TempDir(), "state.json")
+
+		for _, content := range []string{"first", "second"} {
+			if err := WriteFileAtomic(path, []byte(content), 0644); err != nil {
+				t.Fatalf("network safe %v: %v", safe, err)
+			}
+			data, _ := os.ReadFile(path)
+			if string(data) != content {
+				t.Errorf("network safe %v: expected %q, got %q", safe, content, data)
+			}
+		}
+		if PathExists(path + ".tmp") {
+			t.Errorf("network safe %v: temporary file left behind", safe)
+		}
+
+		link := filepath.Join(filepath.Dir(path), "link")
+		if err := CreateSymlink(link, path, false); err != nil {
+			t.Fatalf("network safe %v: %v", safe, err)
+		}
+		if err := RemoveSymlink(link); err != nil {
+			t.Fatalf("network safe %v: %v", safe, err)
+		}
+	}
+	SetNetworkSafe(false)
+}
+
+func TestMkdirAllIgnoresUmask(t *testing.T) {
+	base := t.TempDir()
+	path := filepath.Join(base, "a", "b")
+
+	// Group write would normally be masked off by a typical 022 umask
+	if err := MkdirAll(path, 0775); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{filepath.Join(base, "a"), path} {
+		info, err := os.Stat(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0775 {
+			t.Errorf("expected %s to have mode 0775, got %04o", dir, info.Mode().Perm())
+		}
+	}
+}
+
+func TestExportIgnorePatterns(t *testing.T) {
+	attrs := `# Release archives leave these out
+*.md export-ignore
+/docs/** export-ignore
+**/testdata export-ignore
+scripts/dev.sh export-ignore text
+*.sh text eol=lf
+keep.md -export-ignore
+[attr]binary -diff -merge -text
+`
+	names, paths, err := ExportIgnorePatterns(strings.NewReader(attrs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"*.md", "testdata"}; strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Errorf("names = %q; want %q", names, want)
+	}
+	if want := []string{"docs", "scripts/dev.sh"}; strings.Join(paths, ",") != strings.Join(want, ",") {
+		t.Errorf("paths = %q; want %q", paths, want)
+	}
+}
+
+func TestDiskSpace(t *testing.T) {
+	dir := t.TempDir()
+	space, ok, err := DiskSpace(filepath.Join(dir, "not", "yet", "created"))
+	if err != nil {
+		t.Fatalf("DiskSpace: %v", err)
+	}
+	if !ok {
+		t.Skip("free space isn't available on this platform")
+	}
+	if space.BlockSize == 0 || space.Total == 0 || space.Available > space.Total {
+		t.Errorf("DiskSpace = %+v, want a block size and no more available than in total", space)
+	}
+}
+
+func TestSetImmutable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetImmutable(path, true); err != nil {
+		t.Skipf("can't flag files immutable here: %v", err)
+	}
+	defer SetImmutable(path, false)
+
+	if ok, err := IsImmutable(path); err != nil || !ok {
+		t.Fatalf("IsImmutable = %v, %v after SetImmutable(true)", ok, err)
+	}
+	if err := os.Remove(path); err == nil {
+		t.Fatalf("removed an immutable file")
+	}
+	if err := SetImmutable(path, false); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := IsImmutable(path); err != nil || ok {
+		t.Fatalf("IsImmutable = %v, %v after SetImmutable(false)", ok, err)
+	}
+}
+
+func TestClearImmutable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetImmutable(path, true); err != nil {
+		t.Skipf("can't flag files immutable here: %v", err)
+	}
+	defer SetImmutable(path, false)
+
+	saved, err := ClearImmutable(path)
+	if err != nil || saved == 0 {
+		t.Fatalf("ClearImmutable = %v, %v, want the immutable flag", saved, err)
+	}
+	if ok, err := IsImmutable(path); err != nil || ok {
+		t.Fatalf("IsImmutable = %v, %v after ClearImmutable", ok, err)
+	}
+	if err := RestoreImmutable(path, saved); err != nil {
+		t.Fatal(err)
+	}
+	if again, err := ClearImmutable(path); err != nil || again != saved {
+		t.Fatalf("ClearImmutable = %v, %v after RestoreImmutable, want %v", again, err, saved)
+	}
+}
+
+func TestExpandPathXDGVars(t *testing.T) {
+	home, err := ExpandPath("~")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Unset and relative values fall back to the spec's defaults
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("XDG_DATA_HOME", "relative/data")
+	for path, want := range map[string]string{
+		"${xdg_config}/nvim":     filepath.Join(home, ".config", "nvim"),
+		"$XDG_CONFIG_HOME/nvim":  filepath.Join(home, ".config", "nvim"),
+		"${xdg_data}/fonts":      filepath.Join(home, ".local", "share", "fonts"),
+		"${XDG_DATA_HOME}/fonts": filepath.Join(home, ".local", "share", "fonts"),
+		"${xdg_cache}":           filepath.Join(home, ".cache"),
+	} {
+		got, err := ExpandPath(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("ExpandPath(%q) = %q, want %q", path, got, want)
+		}
+	}
+
+	t.Setenv("XDG_STATE_HOME", "/xdg/state")
+	got, err := ExpandPath("${xdg_state}/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "/xdg/state/app" {
+		t.Errorf("expected $XDG_STATE_HOME to be used, got %q", got)
+	}
+}
+
+func TestIgnoreSet(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, ".lnkitignore"), []byte("# build output\n\n/out/\n"), 0644)
+	os.WriteFile(filepath.Join(dir, ".gitattributes"), []byte("/docs export-ignore\n"), 0644)
+
+	set, err := NewIgnoreSet("config", "*.git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, add := range []func(string) error{set.AddFile, set.AddGitattributes} {
+		if err := add(filepath.Join(dir, "missing")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := set.AddFile(filepath.Join(dir, ".lnkitignore")); err != nil {
+		t.Fatal(err)
+	}
+	if err := set.AddGitattributes(filepath.Join(dir, ".gitattributes")); err != nil {
+		t.Fatal(err)
+	}
+	set.AddFunc("no large files", func(rel string, isDir bool) bool { return !isDir && strings.HasSuffix(rel, ".iso") })
+
+	for rel, want := range map[string]string{
+		"a/b/.git":    `matches "*.git" from config`,
+		"out":         `matches "out" from .lnkitignore`,
+		"docs":        `matches "docs" from .gitattributes`,
+		"x/disk.iso":  "matches no large files",
+		"x/out":       "",
+		"x/docs":      "",
+		".bashrc":     "",
+		"x/disk.iso/": "",
+	} {
+		isDir := strings.HasSuffix(rel, "/")
+		if got := set.Explain(strings.TrimSuffix(rel, "/"), isDir); got != want {
+			t.Errorf("Explain(%q) = %q, want %q", rel, got, want)
+		}
+		if got := set.Match(strings.TrimSuffix(rel, "/"), isDir); got != (want != "") {
+			t.Errorf("Match(%q) = %v", rel, got)
+		}
+	}
+
+	if _, err := NewIgnoreSet("config", "[a-"); err == nil {
+		t.Errorf("expected malformed pattern to be refused")
+	}
+	var none *IgnoreSet
+	if none.Match(".git", true) {
+		t.Errorf("expected a nil set to ignore nothing")
+	}
+}
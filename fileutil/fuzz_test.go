@@ -0,0 +1,68 @@
+package fileutil
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func FuzzMatchesPatterns(f *testing.F) {
+	for _, seed := range [][2]string{{"*.git", ".git"}, {"[a-", "a"}, {"\\", "x"}, {"lnkit.toml", "lnkit.toml"}} {
+		f.Add(seed[0], seed[1])
+	}
+	f.Fuzz(func(t *testing.T, pattern, value string) {
+		matched, err := MatchesPatterns(value, []string{pattern})
+		if err != nil {
+			var perr *PatternError
+			if !errors.As(err, &perr) {
+				t.Fatalf("expected a PatternError, got %T: %v", err, err)
+			}
+			if matched {
+				t.Fatalf("malformed pattern %q reported a match", pattern)
+			}
+		}
+	})
+}
+
+func FuzzExpandPath(f *testing.F) {
+	for _, seed := range []string{"~", "~/.config", "$HOME/x", "${app_config:org.foo.Bar}", "${", "a/../../b", "\x00"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, path string) {
+		expanded, err := ExpandPath(path)
+		if err != nil {
+			var ierr *InvalidPathError
+			if !errors.As(err, &ierr) {
+				t.Fatalf("expected an InvalidPathError, got %T: %v", err, err)
+			}
+			return
+		}
+		if !filepath.IsAbs(expanded) || filepath.Clean(expanded) != expanded {
+			t.Fatalf("ExpandPath(%q) = %q, want a clean absolute path", path, expanded)
+		}
+		if strings.ContainsRune(expanded, 0) {
+			t.Fatalf("ExpandPath(%q) = %q contains a NUL byte", path, expanded)
+		}
+	})
+}
+
+func FuzzRelativeLinkTarget(f *testing.F) {
+	for _, seed := range [][2]string{{"/home/me/.bashrc", "/home/me/dotfiles/.bashrc"}, {"/a", "/"}, {"/", "/a"}, {"a", "/b"}, {"/a/../b", "/c/./d"}} {
+		f.Add(seed[0], seed[1])
+	}
+	f.Fuzz(func(t *testing.T, linkPath, targetPath string) {
+		rel, err := RelativeLinkTarget(linkPath, targetPath)
+		if err != nil {
+			var ierr *InvalidPathError
+			if !errors.As(err, &ierr) {
+				t.Fatalf("expected an InvalidPathError, got %T: %v", err, err)
+			}
+			return
+		}
+		// The link must resolve to exactly the target, never somewhere else
+		if got := filepath.Join(filepath.Dir(linkPath), rel); got != filepath.Clean(targetPath) {
+			t.Fatalf("link %q with target %q resolves to %q, want %q", linkPath, rel, got, filepath.Clean(targetPath))
+		}
+	})
+}
@@ -0,0 +1,43 @@
+package fileutil
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// ExportIgnorePatterns reads a .gitattributes file and returns the patterns of paths
+// marked export-ignore, split like git matches them: patterns without a slash match
+// a name at any depth, others match the path relative to the file's directory.
+// "dir/**" is returned as "dir", since ignoring a directory ignores everything in it.
+func ExportIgnorePatterns(r io.Reader) (names, paths []string, err error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], "[attr]") {
+			continue
+		}
+
+		ignored := false
+		for _, attr := range fields[1:] {
+			switch attr {
+			case "export-ignore", "export-ignore=true":
+				ignored = true
+			case "-export-ignore", "!export-ignore", "export-ignore=false":
+				ignored = false
+			}
+		}
+		if !ignored {
+			continue
+		}
+
+		pattern := strings.TrimPrefix(fields[0], "**/")
+		pattern = strings.TrimSuffix(pattern, "/**")
+		if strings.Contains(pattern, "/") {
+			paths = append(paths, strings.TrimPrefix(pattern, "/"))
+		} else {
+			names = append(names, pattern)
+		}
+	}
+	return names, paths, scanner.Err()
+}
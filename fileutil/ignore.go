@@ -0,0 +1,172 @@
+package fileutil
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// IgnoreSet decides which paths under a root are ignored. It is built up from any
+// number of sources: default patterns, config arrays, ignore files, .gitattributes,
+// and rules given as functions. Each rule remembers where it came from, so Explain
+// can say why a path is ignored.
+//
+// Patterns are matched like filepath.Match. A pattern without a slash matches a
+// name at any depth; one with a slash matches the whole path relative to the root.
+type IgnoreSet struct {
+	rules []ignoreRule
+}
+
+type ignoreRule struct {
+	pattern string                            // Glob pattern, if the rule isn't a function
+	path    bool                              // Whether pattern matches the relative path rather than the name
+	match   func(rel string, isDir bool) bool // Programmatic rule, if set
+	source  string                            // Where the rule came from, e.g. "config" or ".lnkitignore"
+}
+
+func (r ignoreRule) matches(rel string, isDir bool) bool {
+	if r.match != nil {
+		return r.match(rel, isDir)
+	}
+	subject := path.Base(rel)
+	if r.path {
+		subject = rel
+	}
+	matched, _ := path.Match(r.pattern, subject) // Checked when added
+	return matched
+}
+
+func (r ignoreRule) String() string {
+	if r.match != nil {
+		return r.source
+	}
+	return fmt.Sprintf("%q from %s", r.pattern, r.source)
+}
+
+// NewIgnoreSet returns an IgnoreSet with the given name patterns from source.
+func NewIgnoreSet(source string, patterns ...string) (*IgnoreSet, error) {
+	s := &IgnoreSet{}
+	return s, s.AddPatterns(source, patterns...)
+}
+
+// AddPatterns adds glob patterns from source. Patterns with a slash, other than a
+// trailing one, match paths relative to the root; a leading slash is dropped.
+func (s *IgnoreSet) AddPatterns(source string, patterns ...string) error {
+	for _, pattern := range patterns {
+		pattern = strings.TrimSuffix(pattern, "/")
+		isPath := strings.Contains(pattern, "/")
+		if err := s.add(strings.TrimPrefix(pattern, "/"), isPath, source); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// add adds a single glob pattern, after checking it.
+func (s *IgnoreSet) add(pattern string, isPath bool, source string) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return &PatternError{Pattern: pattern, Err: err}
+	}
+	s.rules = append(s.rules, ignoreRule{pattern: pattern, path: isPath, source: source})
+	return nil
+}
+
+// AddFunc adds a programmatic rule, described by source when it matches.
+func (s *IgnoreSet) AddFunc(source string, match func(rel string, isDir bool) bool) {
+	s.rules = append(s.rules, ignoreRule{match: match, source: source})
+}
+
+// AddReader adds the patterns in r, one per line; blank lines and lines starting
+// with # are skipped.
+func (s *IgnoreSet) AddReader(source string, r io.Reader) error {
+	var patterns []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return s.AddPatterns(source, patterns...)
+}
+
+// AddFile adds the patterns in the ignore file at path (see AddReader), if it exists.
+func (s *IgnoreSet) AddFile(path string) error {
+	if !IsRegularFile(path) {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return s.AddReader(filepath.Base(path), f)
+}
+
+// AddGitattributes adds the paths the .gitattributes file at path marks
+// export-ignore (see ExportIgnorePatterns), if it exists.
+func (s *IgnoreSet) AddGitattributes(path string) error {
+	if !IsRegularFile(path) {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	names, paths, err := ExportIgnorePatterns(f)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	source := filepath.Base(path)
+	for _, pattern := range names {
+		if err := s.add(pattern, false, source); err != nil {
+			return err
+		}
+	}
+	for _, pattern := range paths {
+		if err := s.add(pattern, true, source); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rule returns the first rule matching rel, the slash-separated path relative to the root.
+func (s *IgnoreSet) rule(rel string, isDir bool) (ignoreRule, bool) {
+	if s == nil {
+		return ignoreRule{}, false
+	}
+	rel = filepath.ToSlash(rel)
+	for _, r := range s.rules {
+		if r.matches(rel, isDir) {
+			return r, true
+		}
+	}
+	return ignoreRule{}, false
+}
+
+// Match reports whether rel, a path relative to the root, is ignored. A nil set
+// ignores nothing.
+func (s *IgnoreSet) Match(rel string, isDir bool) bool {
+	_, ok := s.rule(rel, isDir)
+	return ok
+}
+
+// Explain says which rule ignores rel, e.g. `matches "*.git" from config`, or returns ""
+// if none does.
+func (s *IgnoreSet) Explain(rel string, isDir bool) string {
+	if r, ok := s.rule(rel, isDir); ok {
+		return "matches " + r.String()
+	}
+	return ""
+}
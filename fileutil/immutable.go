@@ -0,0 +1,34 @@
+package fileutil
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrImmutableUnsupported is returned when the platform has no immutable flag to change.
+var ErrImmutableUnsupported = errors.New("immutable flags are not supported here")
+
+// ImmutableFlags are the flags that kept a path from being changed, as ClearImmutable
+// found them, so RestoreImmutable can set exactly those again.
+type ImmutableFlags uint32
+
+// ErrImmutable is matched by every ImmutableError.
+var ErrImmutable = errors.New("path is immutable")
+
+// ImmutableError is returned for a path that can't be changed, or whose directory
+// entries can't be, because it is flagged immutable (chattr +i, chflags uchg).
+type ImmutableError struct {
+	Paths []string
+}
+
+func (e *ImmutableError) Error() string {
+	what := e.Paths[0] + " is"
+	if len(e.Paths) > 1 {
+		what = fmt.Sprintf("%d paths in the way, e.g. %s, are", len(e.Paths), e.Paths[0])
+	}
+	return what + " flagged immutable; clear the flag (chattr -i on Linux, chflags nouchg on macOS) or rerun with --clear-immutable"
+}
+
+func (e *ImmutableError) Is(target error) bool {
+	return target == ErrImmutable
+}
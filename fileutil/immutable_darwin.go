@@ -0,0 +1,71 @@
+package fileutil
+
+import "golang.org/x/sys/unix"
+
+// IsImmutable returns true if path can't be modified or removed because it has the
+// user or system immutable or append-only flag.
+func IsImmutable(path string) (bool, error) {
+	var st unix.Stat_t
+	if err := unix.Lstat(path, &st); err != nil {
+		return false, err
+	}
+	return st.Flags&(unix.UF_IMMUTABLE|unix.UF_APPEND|unix.SF_IMMUTABLE|unix.SF_APPEND) != 0, nil
+}
+
+// SetImmutable sets or clears the user immutable flag of path. Clearing also clears
+// the system flags, which takes root outside of single-user mode.
+func SetImmutable(path string, on bool) error {
+	if err := CheckWritable("change the immutable flag of", path); err != nil {
+		return err
+	}
+	var st unix.Stat_t
+	if err := unix.Lstat(path, &st); err != nil {
+		return err
+	}
+	if st.Mode&unix.S_IFMT == unix.S_IFLNK {
+		return ErrImmutableUnsupported // chflags would follow it
+	}
+	flags := st.Flags
+	if on {
+		flags |= unix.UF_IMMUTABLE
+	} else {
+		flags &^= unix.UF_IMMUTABLE | unix.UF_APPEND | unix.SF_IMMUTABLE | unix.SF_APPEND
+	}
+	return unix.Chflags(path, int(flags))
+}
+
+// ClearImmutable clears the user and system immutable and append-only flags of
+// path and returns those it had, for RestoreImmutable. Clearing system flags takes
+// root outside of single-user mode.
+func ClearImmutable(path string) (ImmutableFlags, error) {
+	if err := CheckWritable("change the immutable flag of", path); err != nil {
+		return 0, err
+	}
+	var st unix.Stat_t
+	if err := unix.Lstat(path, &st); err != nil {
+		return 0, err
+	}
+	if st.Mode&unix.S_IFMT == unix.S_IFLNK {
+		return 0, ErrImmutableUnsupported // chflags would follow it
+	}
+	saved := st.Flags & (unix.UF_IMMUTABLE | unix.UF_APPEND | unix.SF_IMMUTABLE | unix.SF_APPEND)
+	if saved == 0 {
+		return 0, nil
+	}
+	return ImmutableFlags(saved), unix.Chflags(path, int(st.Flags&^saved))
+}
+
+// RestoreImmutable sets the flags ClearImmutable cleared from path again.
+func RestoreImmutable(path string, saved ImmutableFlags) error {
+	if err := CheckWritable("change the immutable flag of", path); err != nil {
+		return err
+	}
+	var st unix.Stat_t
+	if err := unix.Lstat(path, &st); err != nil {
+		return err
+	}
+	if st.Mode&unix.S_IFMT == unix.S_IFLNK {
+		return ErrImmutableUnsupported
+	}
+	return unix.Chflags(path, int(st.Flags|uint32(saved)))
+}
@@ -0,0 +1,24 @@
+//go:build !linux && !darwin
+
+package fileutil
+
+// IsImmutable returns true if path can't be modified or removed because it is
+// flagged immutable. This platform has no such flag.
+func IsImmutable(path string) (bool, error) {
+	return false, nil
+}
+
+// SetImmutable sets or clears the immutable flag of path. Not supported on this platform.
+func SetImmutable(path string, on bool) error {
+	return ErrImmutableUnsupported
+}
+
+// ClearImmutable clears the immutable flags of path. Not supported on this platform.
+func ClearImmutable(path string) (ImmutableFlags, error) {
+	return 0, ErrImmutableUnsupported
+}
+
+// RestoreImmutable sets the flags ClearImmutable cleared again. Not supported on this platform.
+func RestoreImmutable(path string, saved ImmutableFlags) error {
+	return ErrImmutableUnsupported
+}
@@ -0,0 +1,6 @@
+package fileutil
+
+import "errors"
+
+// ErrMarkerUnsupported is returned when the platform or filesystem can't store link markers.
+var ErrMarkerUnsupported = errors.New("link markers are not supported here")
@@ -0,0 +1,3 @@
+package fileutil
+
+const markerAttr = "com.lnkit.managed"
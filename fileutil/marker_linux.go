@@ -0,0 +1,3 @@
+package fileutil
+
+const markerAttr = "user.lnkit.managed"
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package fileutil
+
+// SetLinkMarker tags the symlink at path as created by lnkit. Not supported on this platform.
+func SetLinkMarker(path string) error {
+	return ErrMarkerUnsupported
+}
+
+// HasLinkMarker returns true if the symlink at path was tagged by SetLinkMarker.
+func HasLinkMarker(path string) bool {
+	return false
+}
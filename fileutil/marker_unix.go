@@ -0,0 +1,29 @@
+//go:build linux || darwin
+
+package fileutil
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// SetLinkMarker tags the symlink at path (not its target) as created by lnkit.
+// Linux only allows user xattrs on regular files and dirs, so this returns
+// ErrMarkerUnsupported for symlinks there.
+func SetLinkMarker(path string) error {
+	if err := CheckWritable("mark", path); err != nil {
+		return err
+	}
+	err := unix.Lsetxattr(path, markerAttr, []byte("1"), 0)
+	if errors.Is(err, unix.EPERM) || errors.Is(err, unix.ENOTSUP) {
+		return ErrMarkerUnsupported
+	}
+	return err
+}
+
+// HasLinkMarker returns true if the symlink at path was tagged by SetLinkMarker.
+func HasLinkMarker(path string) bool {
+	_, err := unix.Lgetxattr(path, markerAttr, nil)
+	return err == nil
+}
@@ -0,0 +1,101 @@
+package fileutil
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+var networkSafe atomic.Bool
+
+// Retries used in network-safe mode while waiting for a change to become visible
+const (
+	settleAttempts = 6
+	settleDelay    = 20 * time.Millisecond // Doubled after every attempt
+)
+
+// SetNetworkSafe turns network-safe mode on or off for the whole process. It trades
+// speed for correctness on network filesystems such as NFS, where writes may only
+// reach the server on close or sync and attribute caching can hide a change for a
+// moment: written files and their parent directories are synced after every
+// change, and changes are waited on until they are visible.
+func SetNetworkSafe(on bool) {
+	networkSafe.Store(on)
+}
+
+// syncDir flushes the directory entry changes in dir to disk, in network-safe mode.
+func syncDir(dir string) error {
+	if !networkSafe.Load() {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync directory %s: %w", dir, err)
+	}
+	return nil
+}
+
+// settle waits, in network-safe mode, until visible reports true, so a change
+// isn't read back as missing or stale right after it was made.
+func settle(path string, visible func() bool) error {
+	if !networkSafe.Load() {
+		return nil
+	}
+	delay := settleDelay
+	for i := 0; i < settleAttempts; i++ {
+		if visible() {
+			return nil
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+	return fmt.Errorf("change to %s didn't become visible", path)
+}
+
+// WriteFileAtomic writes data to path so that readers see either the old or the new
+// contents, never a partial file. It writes a temporary file and renames it over path,
+// in network-safe mode too, where the file and directory are also synced and the new
+// contents waited on, so a crash at any point leaves one of the two versions.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	if err := CheckWritable("write", path); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(perm) // Regardless of the umask
+	}
+	if err == nil && networkSafe.Load() {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	if err := syncDir(filepath.Dir(path)); err != nil {
+		return err
+	}
+	return settle(path, func() bool {
+		info, err := os.Stat(path)
+		return err == nil && info.Size() == int64(len(data))
+	})
+}
@@ -0,0 +1,48 @@
+package fileutil
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+)
+
+// DefaultDirMode is the mode of directories created to hold links, unless set with SetDirMode.
+const DefaultDirMode os.FileMode = 0755
+
+var dirMode atomic.Uint32
+
+func init() {
+	dirMode.Store(uint32(DefaultDirMode))
+}
+
+// SetDirMode sets the mode of directories created to hold links for the whole process.
+func SetDirMode(mode os.FileMode) {
+	dirMode.Store(uint32(mode.Perm()))
+}
+
+// DirMode returns the mode directories created to hold links get.
+func DirMode() os.FileMode {
+	return os.FileMode(dirMode.Load())
+}
+
+// MkdirAll creates path and any missing parents like os.MkdirAll, but sets mode on
+// each directory it creates explicitly, so the result doesn't depend on the umask.
+func MkdirAll(path string, mode os.FileMode) error {
+	if err := CheckWritable("create directory", path); err != nil {
+		return err
+	}
+
+	missing := MissingParents(path)
+	if !PathExists(path) {
+		missing = append(missing, path)
+	}
+	if err := os.MkdirAll(path, mode); err != nil {
+		return err
+	}
+	for _, dir := range missing {
+		if err := os.Chmod(dir, mode); err != nil {
+			return fmt.Errorf("failed to set mode of %s: %w", dir, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package fileutil
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+)
+
+// ErrReadOnly is returned by anything that would modify the filesystem while
+// read-only mode is on.
+var ErrReadOnly = errors.New("read-only mode is on")
+
+var readOnly atomic.Bool
+
+// SetReadOnly turns read-only mode on or off for the whole process.
+func SetReadOnly(on bool) {
+	readOnly.Store(on)
+}
+
+// IsReadOnly returns true if read-only mode is on.
+func IsReadOnly() bool {
+	return readOnly.Load()
+}
+
+// CheckWritable returns an error wrapping ErrReadOnly if read-only mode is on.
+// Every code path that modifies the filesystem calls it first, so read-only mode
+// holds no matter which command is run.
+func CheckWritable(op, path string) error {
+	if readOnly.Load() {
+		return fmt.Errorf("refusing to %s %s: %w", op, path, ErrReadOnly)
+	}
+	return nil
+}
+
+// RemoveAll removes path and anything it contains, like os.RemoveAll, unless in read-only mode.
+func RemoveAll(path string) error {
+	if err := CheckWritable("remove", path); err != nil {
+		return err
+	}
+	return os.RemoveAll(path)
+}
@@ -0,0 +1,107 @@
+package fileutil
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Path variables understood by ExpandPath for sandboxed application configs,
+// e.g. "${app_config:org.alacritty.Alacritty}/alacritty.toml".
+const (
+	AppConfigVar     = "app_config"     // Detect Flatpak, then Snap, then fall back to the XDG config dir
+	FlatpakConfigVar = "flatpak_config" // Always ~/.var/app/<id>/config
+	SnapConfigVar    = "snap_config"    // Always ~/snap/<name>/current/.config
+)
+
+// flatpakIDRegex matches a Flatpak app id: at least three dot-separated elements
+// of letters, digits, underscores, and dashes, none starting with a digit or dash.
+var flatpakIDRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*(\.[A-Za-z_][A-Za-z0-9_-]*){2,}$`)
+
+// snapNameRegex matches a snap name: lowercase letters, digits, and single dashes
+// between them.
+var snapNameRegex = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// validFlatpakID returns true if id is a well-formed Flatpak app id, so it can't
+// point anywhere but at the app's own directory.
+func validFlatpakID(id string) bool {
+	return len(id) <= 255 && flatpakIDRegex.MatchString(id)
+}
+
+// validSnapName returns true if name is a well-formed snap name.
+func validSnapName(name string) bool {
+	return len(name) <= 40 && snapNameRegex.MatchString(name)
+}
+
+// FlatpakConfigDir returns the config directory Flatpak exposes to the app with the given id.
+func FlatpakConfigDir(home, appID string) string {
+	return filepath.Join(home, ".var", "app", appID, "config")
+}
+
+// SnapConfigDir returns the config directory Snap exposes to the snap with the given name.
+func SnapConfigDir(home, snapName string) string {
+	return filepath.Join(home, "snap", snapName, "current", ".config")
+}
+
+// IsFlatpakApp returns true if the app with the given id has a Flatpak data directory under home.
+func IsFlatpakApp(home, appID string) bool {
+	return IsDir(filepath.Join(home, ".var", "app", appID))
+}
+
+// IsSnapApp returns true if the snap with the given name has a data directory under home.
+func IsSnapApp(home, snapName string) bool {
+	return IsDir(filepath.Join(home, "snap", snapName))
+}
+
+// snapNameFromID guesses the snap name for a reverse-DNS Flatpak id, e.g.
+// "org.alacritty.Alacritty" -> "alacritty".
+func snapNameFromID(appID string) string {
+	parts := strings.Split(appID, ".")
+	return strings.ToLower(parts[len(parts)-1])
+}
+
+// AppConfigDir returns the directory an application actually reads its config
+// from, depending on how it is installed: Flatpak first, then Snap, falling
+// back to the regular XDG config directory for native installs.
+func AppConfigDir(home, appID string) string {
+	if IsFlatpakApp(home, appID) {
+		return FlatpakConfigDir(home, appID)
+	}
+	if name := snapNameFromID(appID); IsSnapApp(home, name) {
+		return SnapConfigDir(home, name)
+	}
+	return XDGConfigHome(home)
+}
+
+// expandSandboxVar resolves a "kind:arg" path variable. The second return
+// value is false if name is not a sandbox variable; an error means it is one, but
+// its app id or snap name is malformed.
+func expandSandboxVar(home func() string, name string) (string, bool, error) {
+	kind, arg, found := strings.Cut(name, ":")
+	if !found || arg == "" {
+		return "", false, nil
+	}
+
+	switch kind {
+	case AppConfigVar, FlatpakConfigVar:
+		if !validFlatpakID(arg) {
+			return "", true, fmt.Errorf("%q is not a Flatpak app id like org.example.App", arg)
+		}
+	case SnapConfigVar:
+		if !validSnapName(arg) {
+			return "", true, fmt.Errorf("%q is not a snap name", arg)
+		}
+	default:
+		return "", false, nil
+	}
+
+	switch kind {
+	case AppConfigVar:
+		return AppConfigDir(home(), arg), true, nil
+	case FlatpakConfigVar:
+		return FlatpakConfigDir(home(), arg), true, nil
+	default:
+		return SnapConfigDir(home(), arg), true, nil
+	}
+}
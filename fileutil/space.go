@@ -0,0 +1,22 @@
+package fileutil
+
+import "path/filepath"
+
+// Space is how much room a filesystem has, in bytes and inodes. Available only
+// counts what unprivileged users may use, so it is below the free space when the
+// filesystem reserves some for root.
+type Space struct {
+	BlockSize   uint64
+	Total       uint64
+	Available   uint64
+	TotalInodes uint64
+	FreeInodes  uint64 // 0 with TotalInodes 0 on filesystems without a fixed inode count
+}
+
+// existingAncestor returns path, or its closest parent that exists.
+func existingAncestor(path string) string {
+	for !PathExists(path) && path != filepath.Dir(path) {
+		path = filepath.Dir(path)
+	}
+	return path
+}
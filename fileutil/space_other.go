@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+
+package fileutil
+
+// DiskSpace returns the space on the filesystem that holds path, or would hold it
+// once created, and whether the platform can tell.
+func DiskSpace(path string) (Space, bool, error) {
+	return Space{}, false, nil
+}
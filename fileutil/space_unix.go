@@ -0,0 +1,26 @@
+//go:build linux || darwin
+
+package fileutil
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// DiskSpace returns the space on the filesystem that holds path, or would hold it
+// once created, and whether the platform can tell.
+func DiskSpace(path string) (Space, bool, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(existingAncestor(path), &st); err != nil {
+		return Space{}, true, fmt.Errorf("failed to get free space for %s: %w", path, err)
+	}
+	bsize := uint64(st.Bsize)
+	return Space{
+		BlockSize:   bsize,
+		Total:       uint64(st.Blocks) * bsize,
+		Available:   uint64(st.Bavail) * bsize,
+		TotalInodes: uint64(st.Files),
+		FreeInodes:  uint64(st.Ffree),
+	}, true, nil
+}
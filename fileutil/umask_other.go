@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+
+package fileutil
+
+// Umask returns the process umask, and whether the platform has one.
+func Umask() (int, bool) {
+	return 0, false
+}
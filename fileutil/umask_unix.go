@@ -0,0 +1,13 @@
+//go:build linux || darwin
+
+package fileutil
+
+import "golang.org/x/sys/unix"
+
+// Umask returns the process umask, and whether the platform has one.
+func Umask() (int, bool) {
+	// The umask can only be read by setting it, so put it straight back
+	mask := unix.Umask(0)
+	unix.Umask(mask)
+	return mask, true
+}
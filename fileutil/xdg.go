@@ -0,0 +1,70 @@
+package fileutil
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Path variables understood by ExpandPath for XDG base directories, e.g.
+// "${xdg_config}/nvim". Unlike $XDG_CONFIG_HOME and friends, they always
+// expand to somewhere, falling back as the XDG Base Directory spec says.
+const (
+	XDGConfigVar = "xdg_config" // $XDG_CONFIG_HOME, or ~/.config
+	XDGDataVar   = "xdg_data"   // $XDG_DATA_HOME, or ~/.local/share
+	XDGStateVar  = "xdg_state"  // $XDG_STATE_HOME, or ~/.local/state
+	XDGCacheVar  = "xdg_cache"  // $XDG_CACHE_HOME, or ~/.cache
+)
+
+// xdgDirs maps each XDG base directory's environment variable to its path under
+// home if the variable is unset.
+var xdgDirs = map[string][]string{
+	"XDG_CONFIG_HOME": {".config"},
+	"XDG_DATA_HOME":   {".local", "share"},
+	"XDG_STATE_HOME":  {".local", "state"},
+	"XDG_CACHE_HOME":  {".cache"},
+}
+
+// xdgVars maps each XDG path variable to the environment variable it resolves.
+var xdgVars = map[string]string{
+	XDGConfigVar: "XDG_CONFIG_HOME",
+	XDGDataVar:   "XDG_DATA_HOME",
+	XDGStateVar:  "XDG_STATE_HOME",
+	XDGCacheVar:  "XDG_CACHE_HOME",
+}
+
+// xdgHome returns the XDG base directory named by env, or its default under home if
+// env is unset. The spec says relative paths are invalid, so those are ignored too.
+func xdgHome(home, env string) string {
+	if dir := os.Getenv(env); filepath.IsAbs(dir) {
+		return dir
+	}
+	return filepath.Join(append([]string{home}, xdgDirs[env]...)...)
+}
+
+// XDGConfigHome returns $XDG_CONFIG_HOME, or ~/.config if it is unset or not absolute.
+func XDGConfigHome(home string) string { return xdgHome(home, "XDG_CONFIG_HOME") }
+
+// XDGDataHome returns $XDG_DATA_HOME, or ~/.local/share if it is unset or not absolute.
+func XDGDataHome(home string) string { return xdgHome(home, "XDG_DATA_HOME") }
+
+// XDGStateHome returns $XDG_STATE_HOME, or ~/.local/state if it is unset or not absolute.
+func XDGStateHome(home string) string { return xdgHome(home, "XDG_STATE_HOME") }
+
+// XDGCacheHome returns $XDG_CACHE_HOME, or ~/.cache if it is unset or not absolute.
+func XDGCacheHome(home string) string { return xdgHome(home, "XDG_CACHE_HOME") }
+
+// expandXDGVar resolves an XDG path variable, or an XDG environment variable with
+// its fallback. The second return value is false if name is neither.
+func expandXDGVar(home func() string, name string) (string, bool) {
+	env, ok := xdgVars[name]
+	if !ok {
+		if _, ok := xdgDirs[name]; !ok {
+			return "", false
+		}
+		env = name
+	}
+	if dir := os.Getenv(env); filepath.IsAbs(dir) {
+		return dir, true
+	}
+	return xdgHome(home(), env), true
+}
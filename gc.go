@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"lnkit/fileutil"
+	"lnkit/manifest"
+	"lnkit/stringutil"
+
+	"github.com/spf13/cobra"
+)
+
+// gcOptions are what a garbage collection may do beyond cleaning up lnkit's state.
+type gcOptions struct {
+	dryRun  bool // Only report what would be removed
+	backups bool // Delete stale backups too
+	force   bool // Delete them without asking
+}
+
+// gcResult sums up what a garbage collection removed.
+type gcResult struct {
+	Entries     int // Manifest entries for links, backups, and dirs that are gone
+	Backups     []string
+	KeptBackups int   // Stale backups left alone
+	Manifests   int   // Manifests of pairs with nothing left to manage
+	Reclaimed   int64 // Bytes
+}
+
+// collectGarbage cleans up lnkit's state: it drops manifest entries for paths that
+// no longer exist and removes manifests of pairs whose source is gone and that
+// have nothing left. Backups are the user's own files, so those made for links
+// that are no longer managed or whose source is gone are only deleted with
+// opts.backups, after asking unless opts.force, and never while their original
+// path is empty, where they can still be restored.
+func collectGarbage(opts gcOptions) (gcResult, error) {
+	var result gcResult
+	paths, err := manifest.All()
+	if err != nil {
+		return result, err
+	}
+
+	for _, path := range paths {
+		m, err := manifest.Load(path)
+		if err != nil {
+			return result, err
+		}
+		before := pathSize(path)
+
+		result.Entries += m.Prune()
+		for backupPath, b := range m.Backups {
+			r, managed := m.Links[b.OriginalPath]
+			if managed && fileutil.PathExists(r.TargetPath) {
+				continue
+			}
+			if !opts.backups || !fileutil.PathExists(b.OriginalPath) {
+				result.KeptBackups++
+				continue
+			}
+			if !opts.dryRun && !opts.force && !stringutil.AskForConfirmation("Delete backup "+backupPath+" of "+b.OriginalPath+"?") {
+				result.KeptBackups++
+				continue
+			}
+			result.Backups = append(result.Backups, backupPath)
+			result.Reclaimed += pathSize(backupPath)
+			if !opts.dryRun {
+				if err := fileutil.RemoveAll(backupPath); err != nil {
+					return result, fmt.Errorf("failed to remove backup %s: %w", backupPath, err)
+				}
+			}
+			delete(m.Backups, backupPath)
+		}
+
+		sourceGone := m.TargetRoot == "" || !fileutil.PathExists(m.TargetRoot)
+		if sourceGone && len(m.Links) == 0 && len(m.Backups) == 0 && len(m.Dirs) == 0 {
+			result.Manifests++
+			result.Reclaimed += before
+			if !opts.dryRun {
+				if err := fileutil.RemoveAll(filepath.Dir(path)); err != nil {
+					return result, fmt.Errorf("failed to remove manifest %s: %w", path, err)
+				}
+			}
+			continue
+		}
+		if opts.dryRun {
+			continue
+		}
+		if err := m.Save(); err != nil {
+			return result, err
+		}
+		result.Reclaimed += before - pathSize(path)
+	}
+	return result, nil
+}
+
+// pathSize returns the total size of the files at or under path, or 0 if it
+// can't be read.
+func pathSize(path string) int64 {
+	var size int64
+	filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+func NewGCCmd() *cobra.Command {
+	var opts gcOptions
+
+	cmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Remove state and backups that refer to paths which no longer exist",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			result, err := collectGarbage(opts)
+			if err != nil {
+				return err
+			}
+
+			verb := "Removed"
+			if opts.dryRun {
+				verb = "Would remove"
+			}
+			for _, backup := range result.Backups {
+				fmt.Printf("%s backup: %s\n", verb, backup)
+			}
+			fmt.Printf("%s %d stale entries, %d backups, and %d manifests, reclaiming %d KiB\n",
+				verb, result.Entries, len(result.Backups), result.Manifests, result.Reclaimed/1024)
+			if result.KeptBackups > 0 && !opts.backups {
+				fmt.Printf("Kept %d stale backups; delete them with --backups\n", result.KeptBackups)
+			}
+			return nil
+		},
+		Example: `
+			lnk gc --dry-run
+			lnk gc
+			lnk gc --backups
+		`,
+	}
+	cmd.Flags().BoolVar(&opts.dryRun, "dry-run", false, "Only show what would be removed")
+	cmd.Flags().BoolVar(&opts.backups, "backups", false, "Also delete backups of links no longer managed or whose source is gone, unless their original path is empty")
+	cmd.Flags().BoolVar(&opts.force, "force", false, "With --backups, delete them without asking")
+
+	return cmd
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"slices"
+
+	"lnkit/fileutil"
+)
+
+// immutablePaths returns the paths applying plan would have to change that are flagged
+// immutable: whatever is in the way of a link or replaced by one, and the directories
+// links are made in.
+func immutablePaths(plan *Report) ([]string, error) {
+	var candidates []string
+	for _, e := range plan.Entries {
+		switch e.Status {
+		case StatusConflict:
+			candidates = append(candidates, e.LinkPath, filepath.Dir(e.LinkPath))
+		case StatusPending:
+			// Identical files and links to elsewhere in the source are removed first
+			if e.State == LExistsIdentical.String() || e.State == LMislinkedInternal.String() {
+				candidates = append(candidates, e.LinkPath)
+			}
+			dir := filepath.Dir(e.LinkPath)
+			for !fileutil.PathExists(dir) && dir != filepath.Dir(dir) {
+				dir = filepath.Dir(dir)
+			}
+			candidates = append(candidates, dir)
+		}
+	}
+	slices.Sort(candidates)
+
+	var immutable []string
+	for _, path := range slices.Compact(candidates) {
+		if ok, err := fileutil.IsImmutable(path); err != nil {
+			return nil, err
+		} else if ok {
+			immutable = append(immutable, path)
+		}
+	}
+	return immutable, nil
+}
+
+// checkImmutable fails before anything is changed if applying plan would run into
+// immutable paths. With clear, it instead clears their flags, which takes privileges,
+// and returns a function that sets exactly the flags each had again on those still
+// there afterwards.
+func checkImmutable(plan *Report, clear bool) (restore func(), err error) {
+	paths, err := immutablePaths(plan)
+	if err != nil || len(paths) == 0 {
+		return func() {}, err
+	}
+	if !clear {
+		return nil, &fileutil.ImmutableError{Paths: paths}
+	}
+
+	var cleared []string
+	saved := map[string]fileutil.ImmutableFlags{}
+	restore = func() {
+		for _, path := range cleared {
+			if fileutil.IsSymlink(path) || !fileutil.PathExists(path) {
+				sugar.Infof("Not flagging %s immutable again, it was replaced", path)
+				continue
+			}
+			if err := fileutil.RestoreImmutable(path, saved[path]); err != nil {
+				sugar.Warnf("Failed to flag %s immutable again: %v", path, err)
+			}
+		}
+	}
+	for _, path := range paths {
+		flags, err := fileutil.ClearImmutable(path)
+		if err != nil {
+			restore()
+			if errors.Is(err, fs.ErrPermission) {
+				sugar.Errorf("Clearing the immutable flag takes root (or CAP_LINUX_IMMUTABLE)")
+			}
+			return nil, err
+		}
+		sugar.Infof("Cleared the immutable flag of %s", path)
+		saved[path] = flags
+		cleared = append(cleared, path)
+	}
+	return restore, nil
+}
+
+// explainImmutable turns a permission error from changing path into an ImmutableError
+// if path, or the directory holding it, is flagged immutable.
+func explainImmutable(err error, path string) error {
+	if !errors.Is(err, fs.ErrPermission) {
+		return err
+	}
+	for _, p := range []string{path, filepath.Dir(path)} {
+		if ok, _ := fileutil.IsImmutable(p); ok {
+			return &fileutil.ImmutableError{Paths: []string{p}}
+		}
+	}
+	return err
+}
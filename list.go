@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+
+	"lnkit/fileutil"
+	"lnkit/manifest"
+	"lnkit/stringutil"
+
+	"github.com/spf13/cobra"
+)
+
+// formatAge formats a manifest timestamp with how long ago it was, e.g.
+// "2025-03-01 (229d ago)", or "unknown" for links recorded before timestamps were.
+func formatAge(t *time.Time, now time.Time) string {
+	if t == nil {
+		return "unknown"
+	}
+	days := int(now.Sub(*t).Hours() / 24)
+	if days < 1 {
+		return t.Local().Format(time.DateOnly) + " (today)"
+	}
+	return fmt.Sprintf("%s (%dd ago)", t.Local().Format(time.DateOnly), days)
+}
+
+// linkCondition describes whether a recorded link is still as lnkit left it.
+func linkCondition(r manifest.Record) string {
+	switch {
+	case !fileutil.IsSymlink(r.LinkPath):
+		return "gone"
+	case !fileutil.PathExists(r.TargetPath):
+		return "broken"
+	}
+	return "ok"
+}
+
+// foreignFiles returns what is in the directories lnkit created to hold links that
+// lnkit didn't put there, other than names matching ignore.
+func foreignFiles(m *manifest.Manifest, ignore []string) ([]string, error) {
+	var foreign []string
+	for _, dir := range m.Dirs {
+		entries, err := os.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			path := filepath.Join(dir, e.Name())
+			if _, ok := m.Links[path]; ok || slices.Contains(m.Dirs, path) {
+				continue
+			}
+			if ignored, err := fileutil.MatchesPatterns(e.Name(), ignore); err != nil {
+				return nil, err
+			} else if ignored {
+				continue
+			}
+			foreign = append(foreign, path)
+		}
+	}
+	return foreign, nil
+}
+
+func NewListCmd() *cobra.Command {
+	var long, foreign bool
+	var configPath string
+
+	cmd := &cobra.Command{
+		Use:   "list link_path target_path",
+		Short: "List the links lnkit created from link_path to target_path",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			linkPath, err := fileutil.ExpandPath(args[0])
+			if err != nil {
+				return fmt.Errorf("failed to expand link path: %w", err)
+			}
+			targetPath, err := fileutil.ExpandPath(args[1])
+			if err != nil {
+				return fmt.Errorf("failed to expand target path: %w", err)
+			}
+
+			m, err := loadManifest(linkPath, targetPath)
+			if err != nil {
+				return err
+			}
+
+			if foreign {
+				cfg, err := loadConfig(configPath, targetPath)
+				if err != nil {
+					return err
+				}
+				paths, err := foreignFiles(m, cfg.Options.TargetIgnore)
+				if err != nil {
+					return err
+				}
+				for _, path := range paths {
+					fmt.Fprintln(cmd.OutOrStdout(), path)
+				}
+				return nil
+			}
+
+			if len(m.Links) == 0 {
+				fmt.Printf("No links recorded from %s to %s\n", linkPath, targetPath)
+				return nil
+			}
+
+			records := make([]manifest.Record, 0, len(m.Links))
+			for _, r := range m.Links {
+				records = append(records, r)
+			}
+			sort.Slice(records, func(i, j int) bool { return records[i].LinkPath < records[j].LinkPath })
+
+			now := time.Now()
+			rows := make([][2]string, len(records))
+			for i, r := range records {
+				rows[i] = [2]string{r.LinkPath, r.TargetPath}
+				if long {
+					rows[i][1] = fmt.Sprintf("%s [%s] created %s, verified %s",
+						r.TargetPath, linkCondition(r), formatAge(r.Created, now), formatAge(r.Verified, now))
+				}
+			}
+			stringutil.PrintDotTable(rows)
+			return nil
+		},
+		Example: `
+			lnk list ~ ~/dotfiles
+			lnk list --long ~ ~/dotfiles
+			lnk list --foreign ~ ~/dotfiles
+		`,
+	}
+	cmd.Flags().BoolVarP(&long, "long", "l", false, "Also show each link's condition and when it was created and last verified")
+	cmd.Flags().BoolVar(&foreign, "foreign", false, "Instead list files in directories lnkit created that it didn't put there, except target_ignore")
+	cmd.Flags().StringVar(&configPath, "config", "", "Config file (default: lnkit.toml in target_path)")
+
+	return cmd
+}
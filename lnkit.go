@@ -1,14 +1,16 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"lnkit/fileutil"
+	"lnkit/manifest"
 	"lnkit/stringutil"
 
 	"github.com/fatih/color"
@@ -17,55 +19,36 @@ import (
 	"go.uber.org/zap/zapcore"
 )
 
-type Config struct {
-	Options Options           `toml:"options"`
-	Links   map[string]string `toml:"exceptions"` // Custom exceptions as source -> target mappings
-}
-
-type Options struct {
-	Confirm    bool     `toml:"confirm"`
-	Force      bool     `toml:"force"`
-	CreateDirs bool     `toml:"create_dirs"`
-	SourceDir  string   `toml:"source_dir"`
-	TargetDir  string   `toml:"target_dir"`
-	Ignore     []string `toml:"ignore"`
-	LogLevel   string   `toml:"log_level"`
-}
-
-// Default configuration to fall back on if no config file is found
-var defaultConfig = Config{
-	Options: Options{
-		Confirm:    true,
-		Force:      false,
-		CreateDirs: true,
-		SourceDir:  ".",
-		TargetDir:  "~",
-		Ignore:     []string{"lnkit.toml", ".lnkitignore", "*.git"},
-		LogLevel:   "debug",
-	},
-}
-
 // Logging
 var sugar *zap.SugaredLogger
 
+// InitLogger sets the level of program logs. Where they go and whether they are
+// colored is set by logSettings, independently of output meant for the user.
 func InitLogger(logLevel string) error {
-	// Create zap config independently
-	zapCfg := zap.NewProductionConfig()
 	level := zap.InfoLevel
 	if err := level.UnmarshalText([]byte(logLevel)); err != nil {
 		log.Printf("Invalid log level %q, defaulting to info", logLevel)
 	}
-	zapCfg.Level = zap.NewAtomicLevelAt(level)
-	zapCfg.Encoding = "console"
-	zapCfg.EncoderConfig.EncodeTime = func(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
+	logSettings.level = logLevel
+	logSettings.atom.SetLevel(level)
+
+	output, colored, err := openLogOutput()
+	if err != nil {
+		return err
+	}
+	encoderCfg := zap.NewProductionEncoderConfig()
+	encoderCfg.EncodeTime = func(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
 		enc.AppendString(t.Format("15:04:05"))
 	}
-	zapCfg.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
-	zapCfg.EncoderConfig.EncodeCaller = zapcore.ShortCallerEncoder
-	logger, err := zapCfg.Build()
-	if err != nil {
-		log.Fatalf("Failed to build logger: %v", err)
+	encoderCfg.EncodeLevel = zapcore.CapitalLevelEncoder
+	if colored {
+		encoderCfg.EncodeLevel = zapcore.CapitalColorLevelEncoder
 	}
+	encoderCfg.EncodeCaller = zapcore.ShortCallerEncoder
+
+	core := zapcore.NewCore(zapcore.NewConsoleEncoder(encoderCfg), zapcore.AddSync(output), logSettings.atom)
+	core = zapcore.NewSamplerWithOptions(core, time.Second, 100, 100)
+	logger := zap.New(core, zap.AddCaller(), zap.AddStacktrace(zap.ErrorLevel), zap.ErrorOutput(zapcore.Lock(os.Stderr)))
 	defer logger.Sync()
 	sugar = logger.Sugar()
 	sugar.Debug("Initialized logger")
@@ -77,14 +60,6 @@ func linkString(source string, dest string) string {
 	return blue(fmt.Sprintf("%s → %s", source, dest))
 }
 
-// PreviewDiff runs git diff between two files
-func PreviewDiff(source, target string) error {
-	cmd := exec.Command("git", "diff", "--color", "--no-index", source, target)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
-}
-
 // LState represents a higher-level state derived from LinkState,
 // with awareness of source directories, useful for recursive link operations.
 type LState int
@@ -99,21 +74,88 @@ const (
 	LExistsModified                  // A regular file/dir exists and differs from the source; replacement may overwrite changes
 )
 
+func (s LState) String() string {
+	switch s {
+	case LIgnore:
+		return "ignored"
+	case LAlreadyLinked:
+		return "already linked"
+	case LMissing:
+		return "missing"
+	case LMislinkedInternal:
+		return "mislinked (internal)"
+	case LMislinkedExternal:
+		return "mislinked (external)"
+	case LExistsIdentical:
+		return "exists (identical)"
+	case LExistsModified:
+		return "exists (modified)"
+	default:
+		return "unknown"
+	}
+}
+
+// LinkStyle controls how the path stored in a new symlink is written.
+type LinkStyle string
+
+const (
+	LinkAbsolute     LinkStyle = "absolute"      // Absolute path to the target
+	LinkRelative     LinkStyle = "relative"      // Path relative to the link's directory
+	LinkHomeRelative LinkStyle = "home-relative" // Relative if link and target are both under ~, absolute otherwise
+)
+
+func (s LinkStyle) validate() error {
+	switch s {
+	case LinkAbsolute, LinkRelative, LinkHomeRelative:
+		return nil
+	default:
+		return fmt.Errorf("unknown link style %q, expected %q, %q, or %q", s, LinkAbsolute, LinkRelative, LinkHomeRelative)
+	}
+}
+
+// linkTarget returns the string to store in a symlink at linkPath pointing to targetPath.
+//
+// Symlinks can't contain ~, so home-relative links anchor on the home directory
+// by linking relatively within it. Such links keep working when the same home
+// layout lives at a different path (e.g. /home/me vs /Users/me).
+func (s LinkStyle) linkTarget(linkPath, targetPath string) (string, error) {
+	switch s {
+	case LinkRelative:
+		return fileutil.RelativeLinkTarget(linkPath, targetPath)
+	case LinkHomeRelative:
+		home, err := fileutil.ExpandPath("~")
+		if err != nil {
+			return "", err
+		}
+		linkInHome, _ := fileutil.IsChildPath(linkPath, home)
+		targetInHome, _ := fileutil.IsChildPath(targetPath, home)
+		if linkInHome && targetInHome {
+			return fileutil.RelativeLinkTarget(linkPath, targetPath)
+		}
+		return targetPath, nil
+	default:
+		return targetPath, nil
+	}
+}
+
 // MapLinkStateToTargetState maps a basic LinkState to an appropriate TargetState.
 // More advanced versions can incorporate context like source directories.
-func determineTargetState(linkPath, targetPath, targetRoot string, ignoreList []string) (LState, error) {
+// With opts.strictLinks set, only a symlink whose literal target is targetPath counts as already linked.
+func determineTargetState(linkPath, targetPath, targetRoot string, opts linkOptions) (LState, error) {
 
 	sugar.Debugf("Determining link state for: %s", linkString(linkPath, targetPath))
 
-	// Ignore any directories or files in the ignore list
-	if matched, err := fileutil.MatchesPatterns(filepath.Base(targetPath), ignoreList); err != nil {
-		return LIgnore, fmt.Errorf("error checking ignore patterns: %v", err)
-	} else if matched {
-		sugar.Debugf("Ignoring target: %s", targetPath)
+	// Ignore any directories or files the ignore set matches
+	rel, err := filepath.Rel(targetRoot, targetPath)
+	if err != nil {
+		rel = filepath.Base(targetPath)
+	}
+	if why := opts.ignore.Explain(rel, fileutil.IsDir(targetPath)); why != "" {
+		sugar.Debugf("Ignoring target [%s]: %s (%s)", ReasonIgnoredPattern, targetPath, why)
 		return LIgnore, nil
 	}
 
-	ls, _ := fileutil.GetLinkState(linkPath, targetPath)
+	ls, _ := fileutil.GetLinkStateInRoot(linkPath, targetPath, opts.root, opts.strictLinks)
 
 	switch ls {
 	case fileutil.AlreadyLinked:
@@ -127,7 +169,7 @@ func determineTargetState(linkPath, targetPath, targetRoot string, ignoreList []
 	case fileutil.Mislinked:
 
 		// Read the target
-		linkTarget, _ := os.Readlink(linkPath)
+		linkTarget, _ := fileutil.ReadLinkInRoot(linkPath, opts.root)
 		inTarget, _ := fileutil.IsChildPath(linkTarget, targetRoot)
 		if inTarget {
 			sugar.Debugf("Link is internally mislinked: %s", linkString(linkPath, linkTarget))
@@ -150,22 +192,41 @@ func determineTargetState(linkPath, targetPath, targetRoot string, ignoreList []
 	}
 }
 
-type handler func(sourceAbs, targetAbs string, targetState LState) (bool, error)
+// handler processes an entry of a walk, given its state and how long that took to
+// determine, and returns whether to recurse into it.
+type handler func(sourceAbs, targetAbs string, targetState LState, detect time.Duration) (bool, error)
 
-func walkSourceRec(linkRoot, targetRoot string, ignoreList []string, handlerFunc handler) error {
+func walkSourceRec(linkRoot, targetRoot string, opts linkOptions, handlerFunc handler) error {
 
 	// Ensure sourceDir is valid
 	if !filepath.IsAbs(targetRoot) {
 		return fmt.Errorf("walkSourceDir: expected absolute path, got source directory: %s", targetRoot)
 	}
 
+	walkRoot, err := opts.walkRoot(targetRoot)
+	if err != nil {
+		return err
+	}
+	visited := 0
+
 	// Since we guarantee targetRoot to be an absolute path, targetPath will also be absolute
-	return filepath.Walk(targetRoot, func(targetPath string, info os.FileInfo, err error) error {
+	return filepath.Walk(walkRoot, func(targetPath string, info os.FileInfo, err error) error {
 		if err != nil {
-			fmt.Printf("Error walking directory %s: %v\n", targetPath, err)
+			printEntry("Error walking directory %s: %v", targetPath, err)
 			return err
 		}
 
+		// Stay within the limits, so a source set to e.g. / can't run away
+		if opts.maxDepth > 0 && walkDepth(walkRoot, targetPath) > opts.maxDepth {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if visited++; opts.maxEntries > 0 && visited > opts.maxEntries {
+			return fmt.Errorf("%w: more than %d under %s; check it is the right source, or raise max_entries (--max-entries)", errTooManyEntries, opts.maxEntries, walkRoot)
+		}
+
 		// Ignore symlinks in the target directory
 		if fileutil.IsSymlink(targetPath) {
 			return nil
@@ -174,13 +235,21 @@ func walkSourceRec(linkRoot, targetRoot string, ignoreList []string, handlerFunc
 		// Determine the state of the target
 		targetRel, _ := filepath.Rel(targetRoot, targetPath) // Source path relative to target dir
 		linkPath := filepath.Join(linkRoot, targetRel)       // Absolute path of link path
-		linkState, err := determineTargetState(linkPath, targetPath, targetRoot, ignoreList)
-		if err != nil {
-			return err
+		m, isException := opts.exceptions[targetRel]
+		if isException {
+			linkPath = m.Target
+		}
+		start := time.Now()
+		linkState := LIgnore // Exceptions with no target on this OS aren't linked
+		if !isException || linkPath != "" {
+			linkState, err = determineTargetState(linkPath, targetPath, targetRoot, opts)
+			if err != nil {
+				return err
+			}
 		}
 
 		// Handle this element. The handler decides that if this a dir, if we are to skip it
-		shouldRecurse, err := handlerFunc(linkPath, targetPath, linkState)
+		shouldRecurse, err := handlerFunc(linkPath, targetPath, linkState, time.Since(start))
 		if err != nil {
 			return err
 		}
@@ -192,6 +261,61 @@ func walkSourceRec(linkRoot, targetRoot string, ignoreList []string, handlerFunc
 	})
 }
 
+// errTooManyEntries is returned by walks that reach the max_entries limit.
+var errTooManyEntries = errors.New("too many entries")
+
+// stopsRun reports whether planning failed in a way that must stop a run before it
+// changes anything, rather than being left for the run to report.
+func stopsRun(err error) bool {
+	return errors.Is(err, errTooManyEntries) || errors.Is(err, errCollision) || errors.Is(err, errBadException)
+}
+
+// walkDepth returns how deep path is below root: 0 for root itself, 1 for what is
+// directly in it, and so on.
+func walkDepth(root, path string) int {
+	rel, _ := filepath.Rel(root, path)
+	if rel == "." {
+		return 0
+	}
+	return strings.Count(rel, string(filepath.Separator)) + 1
+}
+
+// linkOptions controls how createSymlinks links a tree.
+type linkOptions struct {
+	force        bool                // Replace conflicting files without prompting
+	createDirs   bool                // Create missing parent directories of links
+	recursive    bool                // Descend into directories instead of linking them whole
+	fold         bool                // When recursive, link whole directories where possible
+	batch        int                 // If positive, resolve conflicts this many at a time after the walk
+	group        bool                // Resolve conflicts after the walk, one decision per directory
+	linkStyle    LinkStyle           // Default style of link targets
+	ignore       *fileutil.IgnoreSet // What in the source to skip
+	exceptions   map[string]Mapping  // Custom link paths for specific source paths
+	strictLinks  bool                // Only accept links whose literal target matches, not equivalent paths
+	dryRun       bool                // Report what would be done without changing anything
+	root         string              // Alternate root the links are made inside of, e.g. a mounted system image
+	shadow       string              // Upper layer whose files take precedence over this tree's, when linking a base repo
+	hexdiff      bool                // Preview conflicting binary files as hex dumps rather than a size and hash
+	allowOutside bool                // Link exception targets outside the link root without asking
+	subdir       string              // Only link this subtree of the source, relative to its root
+	maxDepth     int                 // If positive, how deep below the walked root to go
+	maxEntries   int                 // If positive, how many entries a walk may visit before failing
+
+	resolutions map[string]Resolution // If set, how to resolve each conflict by link path, skipping others, without prompting
+}
+
+// walkRoot returns the directory under targetRoot that a run walks: targetRoot
+// itself, or the subtree set with --subdir.
+func (o linkOptions) walkRoot(targetRoot string) (string, error) {
+	if o.subdir == "" {
+		return targetRoot, nil
+	}
+	if filepath.IsAbs(o.subdir) || !filepath.IsLocal(o.subdir) {
+		return "", fmt.Errorf("subdir %s must be a relative path inside the source", o.subdir)
+	}
+	return filepath.Join(targetRoot, o.subdir), nil
+}
+
 // walkSourceRec recursively walks through the directory tree rooted at targetRoot (must be absolute).
 // For each file or directory (excluding symlinks), it determines the corresponding link path under linkRoot,
 // checks the link state, and invokes handlerFunc to process it.
@@ -199,32 +323,53 @@ func walkSourceRec(linkRoot, targetRoot string, ignoreList []string, handlerFunc
 // Parameters:
 //   - linkRoot: the root directory where symlinks will be created or checked.
 //   - targetRoot: the root directory to walk through; must be an absolute path.
-//   - ignoreList: list of paths or patterns to ignore during the walk.
+//   - opts: link options; the walk uses the ignore list, the (resolved) exceptions, and strict link matching.
 //   - handlerFunc: a callback function that handles each file or directory and returns whether to recurse further.
 //
-// The function skips symlinks in targetRoot, respects the ignoreList, and skips directories
+// The function skips symlinks in targetRoot, respects the ignore set, and skips directories
 // based on the handlerFunc's decision or if the link state is ignored.
-func createSymlinks(linkRoot, targetRoot string, force, createDirs, confirm, recursive, fold bool, ignoreList []string) error {
+func createSymlinks(linkRoot, targetRoot string, opts linkOptions) (*Report, error) {
 
 	// Ensure linkPath and targetPath are valid
 	if !filepath.IsAbs(linkRoot) {
-		return fmt.Errorf("createSymlinks: expected absolute path, got source directory: %s", linkRoot)
+		return nil, fmt.Errorf("createSymlinks: expected absolute path, got source directory: %s", linkRoot)
 	}
 	if !filepath.IsAbs(targetRoot) {
-		return fmt.Errorf("createSymlinks: expected absolute path, got target directory: %s", targetRoot)
+		return nil, fmt.Errorf("createSymlinks: expected absolute path, got target directory: %s", targetRoot)
 	}
 
-	link := func(linkPath string, targetPath string, createDirs bool) {
-		if err := fileutil.CreateSymlink(linkPath, targetPath, createDirs); err != nil {
-			sugar.Infof("Error creating symlink %s: %v", linkString(linkPath, targetPath), err)
-		} else {
-			sugar.Infof("Linked: %s", linkString(linkPath, targetPath))
-		}
+	exceptions, err := resolveExceptions(opts.exceptions, linkRoot, opts.root)
+	if err != nil {
+		return nil, err
+	}
+	opts.exceptions = exceptions
+
+	// Know up front which source wins where several would be linked to one place,
+	// rather than leaving it to whichever the walk reaches last
+	losers, err := findCollisions(linkRoot, targetRoot, opts)
+	if err != nil {
+		return nil, err
 	}
 
-	handler := func(linkPath, targetPath string, linkState LState) (bool, error) {
+	walkRoot, err := opts.walkRoot(targetRoot)
+	if err != nil {
+		return nil, err
+	}
 
-		isRoot, _ := fileutil.PathsEqual(targetPath, targetRoot)
+	report := &Report{}
+	var pending []conflict // Conflicts deferred to batch resolution
+
+	// Remote files are linked from the cache as if they were at their source path. A
+	// dry run doesn't fetch anything
+	remotes, err := cacheRemotes(report, targetRoot, opts, !opts.dryRun)
+	if err != nil {
+		return nil, err
+	}
+
+	handler := func(linkPath, targetPath string, linkState LState, detect time.Duration) (bool, error) {
+		defer report.timeEntries(len(report.Entries), detect, time.Now(), stringutil.Waited())
+
+		isRoot, _ := fileutil.PathsEqual(targetPath, walkRoot)
 
 		// If performing a recursive link, allow walking into subdirectories.
 		// Otherwise, skip walking deeper after processing the current item.
@@ -236,76 +381,122 @@ func createSymlinks(linkRoot, targetRoot string, force, createDirs, confirm, rec
 		//
 		// Effectively, this controls whether we recurse beyond the root directory.
 		shouldRecurse := false // Whether we should recurse into the dir
-		if recursive && (isRoot || !fold) {
+		if opts.recursive && (isRoot || !opts.fold) {
 			shouldRecurse = true
 		}
 
+		// Exceptions link the entry as a whole, with their own link style if set
+		style := opts.linkStyle
+		targetRel, _ := filepath.Rel(targetRoot, targetPath)
+		if source, ok := remotes[targetPath]; ok {
+			targetRel = source
+		}
+		m, isException := exceptions[targetRel]
+		if isException {
+			shouldRecurse = false
+			if m.LinkStyle != "" {
+				style = m.LinkStyle
+			}
+		}
+
 		// Skip and don't recurse into ignored elements
 		if linkState == LIgnore {
+			reason, message := ReasonIgnoredPattern, opts.ignore.Explain(targetRel, fileutil.IsDir(targetPath))
+			if isException && m.Target == "" {
+				reason, message = ReasonNoTarget, ""
+			}
+			report.add(linkPath, targetPath, linkState, StatusSkipped, reason, message)
 			shouldRecurse = false
 			return shouldRecurse, nil
 		}
 
+		// Leave sources that collide with one of a higher priority alone
+		if winner, lost := losers[targetRel]; lost {
+			report.add(linkPath, targetPath, linkState, StatusSkipped, ReasonCollision, "linked from "+winner+" instead, which has a higher priority")
+			return false, nil
+		}
+
+		// Leave entries of a base repo that the personal repo overrides alone
+		if opts.shadow != "" {
+			if upper, shadowed := shadowedBy(opts.shadow, targetRoot, targetPath, opts); shadowed {
+				printEntry("Skipped [%s]: %s is overridden by %s", ReasonShadowed, targetPath, upper)
+				report.add(linkPath, targetPath, linkState, StatusSkipped, ReasonShadowed, "overridden by "+upper)
+				return false, nil
+			}
+		}
+
 		// If not folding on recursive run and this a dir, don't link it!
-		if fileutil.IsDir(targetPath) && recursive && !fold {
+		if fileutil.IsDir(targetPath) && opts.recursive && !opts.fold && !isException {
 			return shouldRecurse, nil
 		}
 
+		// Only report what would happen
+		if opts.dryRun {
+			planEntry(report, linkPath, targetPath, linkState)
+			// Links outside the link root will need their own confirmation
+			if e := &report.Entries[len(report.Entries)-1]; isException && m.outside && e.Status == StatusPending {
+				e.Reason = ReasonOutsideRoot
+			}
+			return shouldRecurse, nil
+		}
+
+		// Links outside the link root need write access there, and are confirmed on their
+		// own: --force is about what's in the way, not where links go
+		if isException && m.outside && linkState != LAlreadyLinked {
+			if !fileutil.CanCreate(linkPath) {
+				printEntry("Failed [%s]: no write access to %s", ReasonPermissionDenied, filepath.Dir(linkPath))
+				report.add(linkPath, targetPath, linkState, StatusFailed, ReasonPermissionDenied, "no write access to "+filepath.Dir(linkPath)+", run as a user with it")
+				return false, nil
+			}
+			prompt := fmt.Sprintf("Link %s, outside of %s?", linkString(linkPath, targetPath), linkRoot)
+			if !opts.allowOutside && !stringutil.AskForConfirmation(prompt) {
+				printEntry("Skipped [%s]: %s", ReasonOutsideRoot, linkPath)
+				report.add(linkPath, targetPath, linkState, StatusSkipped, ReasonOutsideRoot, "not confirmed")
+				return false, nil
+			}
+		}
+
 		// TODO: factor this out to be more reusable
 		switch linkState {
-		case LIgnore, LAlreadyLinked:
+		case LIgnore:
+		case LAlreadyLinked:
+			report.add(linkPath, targetPath, linkState, StatusUnchanged, "", "")
+
 		case LMissing:
-			link(linkPath, targetPath, createDirs)
+			createLink(report, linkPath, targetPath, linkState, style, opts.root, opts.createDirs)
 
 		case LMislinkedInternal:
 			sugar.Debugf("Target file is broken. Creating correct symlink...")
-			if err := os.RemoveAll(linkPath); err != nil {
-				return shouldRecurse, fmt.Errorf("failed to remove existing file %s: %w", linkPath, err)
-			}
-			link(linkPath, targetPath, createDirs)
-
-		case LMislinkedExternal:
-			if force {
-				sugar.Infof("Overwriting existing file at: ", linkPath)
-				if err := os.RemoveAll(linkPath); err != nil {
-					return shouldRecurse, fmt.Errorf("failed to remove existing file %s: %w", linkPath, err)
-				}
-			} else {
-				if stringutil.AskForConfirmation("Preview diff of existing file at " + linkPath + "?") {
-					PreviewDiff(linkPath, targetPath)
-				}
-				if stringutil.AskForConfirmation("Delete existing file at " + linkPath + "?") {
-					if err := os.RemoveAll(linkPath); err != nil {
-						return shouldRecurse, fmt.Errorf("failed to remove existing file %s: %w", linkPath, err)
-					}
-				} else {
-					fmt.Printf("Skipped linking: %s\n", linkPath)
-				}
+			oldTarget := readOldTarget(linkPath)
+			if err := fileutil.RemoveAll(linkPath); err != nil {
+				return shouldRecurse, fmt.Errorf("failed to remove existing file %s: %w", linkPath, explainImmutable(err, linkPath))
 			}
+			createLink(report, linkPath, targetPath, linkState, style, opts.root, opts.createDirs)
+			report.Entries[len(report.Entries)-1].OldTarget = oldTarget
 
 		case LExistsIdentical:
 			sugar.Debugf("Target file has the same content. Creating correct symlink...")
-			if err := os.RemoveAll(linkPath); err != nil {
-				return shouldRecurse, fmt.Errorf("failed to remove existing file %s: %w", linkPath, err)
+			if err := fileutil.RemoveAll(linkPath); err != nil {
+				return shouldRecurse, fmt.Errorf("failed to remove existing file %s: %w", linkPath, explainImmutable(err, linkPath))
 			}
-			link(linkPath, targetPath, createDirs)
-
-		case LExistsModified:
-			if force {
-				sugar.Infof("Overwriting existing file at: ", linkPath)
-				if err := os.RemoveAll(linkPath); err != nil {
-					return shouldRecurse, fmt.Errorf("failed to remove existing file %s: %w", linkPath, err)
+			createLink(report, linkPath, targetPath, linkState, style, opts.root, opts.createDirs)
+
+		case LMislinkedExternal, LExistsModified:
+			c := conflict{linkPath: linkPath, targetPath: targetPath, state: linkState, style: style, root: opts.root, hexdiff: opts.hexdiff}
+			switch {
+			case opts.resolutions != nil:
+				if err := resolveConflict(report, c, opts.resolutions[linkPath], opts.createDirs); err != nil {
+					return shouldRecurse, err
 				}
-			} else {
-				if stringutil.AskForConfirmation("Preview diff of existing file at " + linkPath + "?") {
-					PreviewDiff(linkPath, targetPath)
+			case opts.force:
+				if err := resolveConflict(report, c, ResolveOverwrite, opts.createDirs); err != nil {
+					return shouldRecurse, err
 				}
-				if stringutil.AskForConfirmation("Delete existing file at " + linkPath + "?") {
-					if err := os.RemoveAll(linkPath); err != nil {
-						return shouldRecurse, fmt.Errorf("failed to remove existing file %s: %w", linkPath, err)
-					}
-				} else {
-					fmt.Printf("Skipped: %s\n", linkPath)
+			case opts.batch > 0 || opts.group:
+				pending = append(pending, c)
+			default:
+				if err := resolveConflict(report, c, promptConflict(c), opts.createDirs); err != nil {
+					return shouldRecurse, err
 				}
 			}
 
@@ -316,7 +507,18 @@ func createSymlinks(linkRoot, targetRoot string, force, createDirs, confirm, rec
 		return shouldRecurse, nil
 	}
 
-	return walkSourceRec(linkRoot, targetRoot, ignoreList, handler)
+	if err := walkSourceRec(linkRoot, targetRoot, opts, handler); err != nil {
+		return report, err
+	}
+	if err := walkRemotes(remotes, opts, handler); err != nil {
+		return report, err
+	}
+
+	// Conflicts deferred in batch mode are resolved once the whole tree is known
+	if opts.group {
+		return report, resolveInGroups(report, pending, linkRoot, opts.createDirs)
+	}
+	return report, resolveInBatches(report, pending, opts.batch, opts.createDirs)
 }
 
 const ignoreFile = ".lnkitignore"
@@ -325,41 +527,204 @@ func main() {
 
 	InitLogger("Debug")
 
+	if err := NewRootCmd().Execute(); err != nil {
+		var exitErr *exitError
+		if errors.As(err, &exitErr) {
+			if exitErr.msg != "" {
+				fmt.Fprintln(os.Stderr, exitErr.msg)
+			}
+			os.Exit(exitErr.code)
+		}
+		log.Fatal(err)
+	}
+}
+
+func NewRootCmd() *cobra.Command {
+	var readOnly bool
+	var logLevel, logFile, logColor, userColorMode string
+
 	rootCmd := &cobra.Command{
 		Use:   "lnk",
 		Short: "Modern symlink manager",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			fileutil.SetReadOnly(readOnly)
+			entryOut = cmd.ErrOrStderr()
+
+			if err := validColorMode("color", userColorMode); err != nil {
+				return err
+			}
+			if err := validColorMode("log-color", logColor); err != nil {
+				return err
+			}
+			setUserColor(userColorMode)
+
+			flags := cmd.Flags()
+			logSettings.userLevel = flags.Changed("log-level")
+			if !flags.Changed("log-level") && !flags.Changed("log-file") && !flags.Changed("log-color") {
+				return nil
+			}
+			if !flags.Changed("log-level") {
+				logLevel = logSettings.level
+			}
+			logSettings.output = "stderr"
+			if logFile != "" {
+				logSettings.output = logFile
+			}
+			logSettings.color = logColor
+			return InitLogger(logLevel)
+		},
 	}
 
-	// Global flags can be defined here if needed
+	// Global flags
+	rootCmd.PersistentFlags().BoolVar(&readOnly, "read-only", false, "Refuse to modify anything on disk, whatever the command")
+	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "debug", "Level of program logs: debug, info, warn, error, or fatal")
+	rootCmd.PersistentFlags().StringVar(&logFile, "log-file", "", "Write program logs to this file instead of stderr")
+	rootCmd.PersistentFlags().StringVar(&logColor, "log-color", "auto", "Color program logs: auto (if stderr is a terminal), always, or never")
+	rootCmd.PersistentFlags().StringVar(&userColorMode, "color", "auto", "Color results and prompts: auto (if stdout is a terminal), always, or never")
 
 	rootCmd.AddCommand(NewLinkCmd())
-	if err := rootCmd.Execute(); err != nil {
-		log.Fatal(err)
-	}
+	rootCmd.AddCommand(NewUnlinkCmd())
+	rootCmd.AddCommand(NewStateCmd())
+	rootCmd.AddCommand(NewRepoCmd())
+	rootCmd.AddCommand(NewBenchCmd())
+	rootCmd.AddCommand(NewSelftestCmd())
+	rootCmd.AddCommand(NewDoctorCmd())
+	rootCmd.AddCommand(NewOverlayCmd())
+	rootCmd.AddCommand(NewReplayCmd())
+	rootCmd.AddCommand(NewGCCmd())
+	rootCmd.AddCommand(NewEnsureCmd())
+	rootCmd.AddCommand(NewListCmd())
+	rootCmd.AddCommand(NewLogCmd())
+	rootCmd.AddCommand(NewReviewCmd())
+	rootCmd.AddCommand(NewStatsCmd())
+	rootCmd.AddCommand(NewPlanCmd())
+	rootCmd.AddCommand(NewApproveCmd())
+	rootCmd.AddCommand(NewApplyCmd())
+	rootCmd.AddCommand(NewUpdateRemotesCmd())
+	return rootCmd
 }
 
 func NewLinkCmd() *cobra.Command {
 
-	var recursive, fold, force, createDirs bool
+	var opts linkOptions
+	var configPath, linkStyle, recordPath string
+	var jsonOut, redact, clearImmutable bool
+	var slowReport int
 
 	runLink := func(cmd *cobra.Command, args []string) error {
 
-		linkPath, err := fileutil.ExpandPath(args[0])
+		linkPath, targetPath := args[0], args[1]
+		if err := expandArgs(&opts, &linkPath, &targetPath); err != nil {
+			return err
+		}
+		if subtree, err := opts.walkRoot(targetPath); err != nil {
+			return err
+		} else if opts.subdir != "" && !fileutil.PathExists(subtree) {
+			return fmt.Errorf("subdir %s doesn't exist in %s", opts.subdir, targetPath)
+		}
+
+		sugar.Debugf("linkPath: %s", linkPath)
+		sugar.Debugf("TargetPath: %s", targetPath)
+
+		cfg, err := loadOptions(cmd, configPath, targetPath, &opts)
 		if err != nil {
-			return fmt.Errorf("failed to expand link path: %w", err)
+			return err
+		}
+		if opts.force {
+			if err := checkCleanSource(targetPath, cfg.Options.RequireCleanSource); err != nil {
+				return err
+			}
+		}
+
+		// Errors planning are left for the run itself to report, unless planning is all
+		// there is to do or the walk ran away
+		plan, err := planLinks(linkPath, targetPath, cfg, opts)
+		if stopsRun(err) {
+			return err
+		}
+		if opts.dryRun {
+			if err != nil {
+				return err
+			}
+			if err := printPlan(cmd.OutOrStdout(), plan, jsonOut); err != nil {
+				return err
+			}
+			if hasChanges(plan) {
+				cmd.SilenceUsage, cmd.SilenceErrors = true, true
+				return &exitError{code: exitPending}
+			}
+			return nil
+		}
+		if err == nil {
+			if err := checkSpace(linkPath, plan); err != nil {
+				return err
+			}
+			restore, err := checkImmutable(plan, clearImmutable)
+			if err != nil {
+				return err
+			}
+			defer restore()
+		}
+
+		var rec *recording
+		if recordPath != "" {
+			if rec, err = startRecording(cmd, linkPath, targetPath, configPath, opts, redact); err != nil {
+				return fmt.Errorf("failed to start recording: %w", err)
+			}
 		}
 
-		targetPath, err := fileutil.ExpandPath(args[1])
+		report, err := createSymlinks(linkPath, targetPath, opts)
+		if rec != nil {
+			if err := rec.finish(recordPath, report, err); err != nil {
+				sugar.Errorf("Failed to write record bundle: %v", err)
+			}
+		}
+
+		// A run that fails partway is reported as a failed entry with a reason, like
+		// the entries it failed on; whatever was linked before is still recorded
+		started := report != nil
 		if err != nil {
-			return fmt.Errorf("failed to expand target path: %w", err)
+			sugar.Errorf("Linking failed: %v", err)
+			if !started {
+				report = &Report{}
+			}
+			reason := errorReason(err)
+			printEntry("Failed [%s]: %s: %v", reason, targetPath, err)
+			report.add(linkPath, targetPath, LMissing, StatusFailed, reason, err.Error())
 		}
 
-		sugar.Debugf("linkPath: %s", linkPath)
-		sugar.Debugf("TargetPath: %s", targetPath)
+		if started {
+			if err := recordLinks(report, linkPath, targetPath, cfg.Options.ManagedMarker); err != nil {
+				sugar.Errorf("Failed to record links in manifest: %v", err)
+			}
 
-		createSymlinks(linkPath, targetPath, force, createDirs, false, recursive, fold, []string{".git"})
+			baseReport, err := linkBase(linkPath, targetPath, cfg, opts)
+			if err != nil {
+				sugar.Errorf("Linking base repo failed: %v", err)
+			}
+			if baseReport != nil {
+				report.Entries = append(report.Entries, baseReport.Entries...)
+			}
+			if cfg.Options.SELinuxRestorecon && selinuxEnabled() {
+				if err := restoreContexts(createdPaths(report)); err != nil {
+					sugar.Errorf("Failed to restore SELinux contexts: %v", err)
+				}
+			}
+			report.addFollowUps(targetPath, cfg.FollowUps)
+		}
+
+		if slowReport > 0 {
+			w := cmd.OutOrStdout()
+			if jsonOut {
+				w = cmd.ErrOrStderr() // Keep the JSON report parseable
+			}
+			writeSlowReport(w, report, slowReport)
+		}
 
-		// TODO: Call your existing linking functions
+		if jsonOut {
+			return report.WriteJSON(cmd.OutOrStdout())
+		}
+		report.PrintFollowUps(cmd.OutOrStdout())
 		return nil
 	}
 
@@ -377,26 +742,242 @@ func NewLinkCmd() *cobra.Command {
 		Example: `
 			lnk link --rec ~/dotfiles ~/.config
 			lnk link ~/dotfiles/nvim ~/.config/nvim
+			lnk link --rec --root /mnt/newsys /home/me /home/me/dotfiles
+			lnk link --rec --subdir .config/nvim ~ ~/dotfiles
 		`,
 	}
-	cmd.Flags().BoolVar(&recursive, "rec", false, "Recursively process nested directories")
-	cmd.Flags().BoolVar(&fold, "fold", false, "Link whole directories where applicable")
-	cmd.Flags().BoolVar(&force, "force", false, "Force")
-	cmd.Flags().BoolVar(&createDirs, "create-dirs", true, "Create dirs")
+	cmd.Flags().BoolVar(&opts.recursive, "rec", false, "Recursively process nested directories")
+	cmd.Flags().BoolVar(&opts.fold, "fold", false, "Link whole directories where applicable")
+	cmd.Flags().BoolVar(&opts.force, "force", false, "Force")
+	cmd.Flags().BoolVar(&opts.createDirs, "create-dirs", true, "Create dirs")
+	cmd.Flags().IntVar(&opts.batch, "batch", 0, "Resolve conflicts N at a time with one decision per batch")
+	cmd.Flags().BoolVar(&opts.group, "group", false, "Resolve conflicts with one decision per directory")
+	cmd.Flags().BoolVar(&jsonOut, "json", false, "Print a JSON report of every entry, with reason codes for skipped and failed ones")
+	cmd.Flags().StringVar(&linkStyle, "link-style", string(LinkAbsolute), "Link target style: absolute, relative, or home-relative")
+	cmd.Flags().StringVar(&configPath, "config", "", "Config file (default: lnkit.toml in target_path)")
+	cmd.Flags().StringVar(&opts.root, "root", "", "Treat paths as inside this alternate root, e.g. a new system mounted at /mnt")
+	cmd.Flags().BoolVar(&opts.hexdiff, "hexdiff", false, "Preview conflicting binary files byte by byte instead of by size and hash")
+	cmd.Flags().StringVar(&recordPath, "record", "", "Record the config, trees, answers, and outcome of this run to a bundle for replay")
+	cmd.Flags().BoolVar(&redact, "redact", false, "With --record, replace file contents in the bundle with their hashes")
+	cmd.Flags().StringVar(&opts.subdir, "subdir", "", "Only link this subdirectory of target_path, at the same place under link_path")
+	cmd.Flags().IntVar(&opts.maxDepth, "max-depth", 0, "With --rec, go at most this many levels below target_path (0 for no limit)")
+	cmd.Flags().IntVar(&opts.maxEntries, "max-entries", 0, "Fail before changing anything if target_path has more entries than this (default from max_entries)")
+	cmd.Flags().BoolVar(&clearImmutable, "clear-immutable", false, "Clear immutable flags (chattr +i) in the way for the run and set them again after; takes root")
+	cmd.Flags().BoolVarP(&opts.dryRun, "dry-run", "n", false, "Only show what would be done; exits 2 if anything would change, 0 if not")
+	cmd.Flags().BoolVar(&opts.allowOutside, "allow-outside", false, "Link exceptions with absolute targets outside link_path without asking")
+	cmd.Flags().IntVar(&slowReport, "slow-report", 0, fmt.Sprintf("List the N paths that took longest to check and link, with hints (N defaults to %d)", slowReportDefault))
+	cmd.Flags().Lookup("slow-report").NoOptDefVal = fmt.Sprint(slowReportDefault)
 
 	return cmd
 }
 
-func runUnlink(cmd *cobra.Command, args []string) error {
-	// Your unlink logic here
+// loadOptions loads the config for targetPath into opts. Values of flags set on cmd take
+// precedence over the config; flags cmd doesn't define are taken from the config.
+func loadOptions(cmd *cobra.Command, configPath, targetPath string, opts *linkOptions) (Config, error) {
 
-	// TODO: Call your existing unlinking functions
-	return nil
+	// The config lives alongside the files being linked to
+	cfg, err := loadConfig(configPath, targetPath)
+	if err != nil {
+		return cfg, err
+	}
+
+	if !cmd.Flags().Changed("force") {
+		opts.force = cfg.Options.Force
+	}
+	if !cmd.Flags().Changed("create-dirs") {
+		opts.createDirs = cfg.Options.CreateDirs
+	}
+	opts.linkStyle = cfg.Options.LinkStyle
+	if cmd.Flags().Changed("link-style") {
+		style, _ := cmd.Flags().GetString("link-style")
+		opts.linkStyle = LinkStyle(style)
+		if err := opts.linkStyle.validate(); err != nil {
+			return cfg, err
+		}
+	}
+	if opts.ignore, err = cfg.Options.ignoreSet(targetPath); err != nil {
+		return cfg, err
+	}
+	opts.strictLinks = cfg.Options.StrictLinkMatch
+	if !cmd.Flags().Changed("max-depth") {
+		opts.maxDepth = cfg.Options.MaxDepth
+	}
+	if !cmd.Flags().Changed("max-entries") {
+		opts.maxEntries = cfg.Options.MaxEntries
+	}
+	opts.exceptions = cfg.Links
+	fileutil.SetNetworkSafe(cfg.Options.NetworkFS)
+	mode, _ := cfg.Options.dirMode() // Both checked when loading the config
+	fileutil.SetDirMode(mode)
+	differ, _ = cfg.Options.differ()
+
+	return cfg, nil
 }
 
-func runStats(cmd *cobra.Command, args []string) error {
-	// Your stats logic here
+// expandArgs expands opts.root and the paths given on the command line in place.
+// If a root is set, the paths are as they appear inside it (see
+// fileutil.ExpandPathInRoot) and end up under it.
+func expandArgs(opts *linkOptions, linkPath, targetPath *string) error {
+	if opts.root == "" {
+		return expandPaths(linkPath, targetPath, fileutil.ExpandPath)
+	}
+	root, err := fileutil.ExpandPath(opts.root)
+	if err != nil {
+		return fmt.Errorf("failed to expand root: %w", err)
+	}
+	if !fileutil.IsDir(root) {
+		return fmt.Errorf("root %s is not a directory", root)
+	}
 
-	// TODO: Call your existing stats functions
+	opts.root = root
+	return expandPaths(linkPath, targetPath, func(path string) (string, error) {
+		return fileutil.ExpandPathInRoot(path, root)
+	})
+}
+
+// expandPaths expands the link and target paths with expand.
+func expandPaths(linkPath, targetPath *string, expand func(string) (string, error)) error {
+	var err error
+	if *linkPath, err = expand(*linkPath); err != nil {
+		return fmt.Errorf("failed to expand link path: %w", err)
+	}
+	if *targetPath, err = expand(*targetPath); err != nil {
+		return fmt.Errorf("failed to expand target path: %w", err)
+	}
 	return nil
 }
+
+// removeSymlinks removes the links under linkRoot that point back into targetRoot, mirroring
+// how createSymlinks lays them out. Only links recorded in m (or marked as managed) are
+// removed, unless all is set.
+func removeSymlinks(linkRoot, targetRoot string, opts linkOptions, all bool, m *manifest.Manifest) (*Report, error) {
+
+	exceptions, err := resolveExceptions(opts.exceptions, linkRoot, opts.root)
+	if err != nil {
+		return nil, err
+	}
+	opts.exceptions = exceptions
+
+	// Walk once without removing anything first, so the max_entries limit stops the
+	// run before it has unlinked anything, as planLinks does for linking. It goes
+	// where the walk below goes: into everything but ignored entries and links.
+	if opts.maxEntries > 0 {
+		count := func(_, _ string, linkState LState, _ time.Duration) (bool, error) {
+			return linkState != LIgnore && linkState != LAlreadyLinked, nil
+		}
+		if err := walkSourceRec(linkRoot, targetRoot, opts, count); err != nil {
+			return nil, err
+		}
+	}
+
+	report := &Report{}
+
+	handler := func(linkPath, targetPath string, linkState LState, _ time.Duration) (bool, error) {
+		switch linkState {
+		case LIgnore:
+			return false, nil
+
+		case LAlreadyLinked:
+			if !all && !isManaged(m, linkPath, targetPath) {
+				printEntry("Skipped [%s]: %s", ReasonUnmanagedLink, linkPath)
+				report.add(linkPath, targetPath, linkState, StatusSkipped, ReasonUnmanagedLink, "")
+				return false, nil
+			}
+			if err := fileutil.RemoveSymlink(linkPath); err != nil {
+				reason := errorReason(err)
+				sugar.Infof("Error removing symlink %s [%s]: %v", linkPath, reason, err)
+				report.add(linkPath, targetPath, linkState, StatusFailed, reason, err.Error())
+				return false, nil
+			}
+			sugar.Infof("Unlinked: %s", linkString(linkPath, targetPath))
+			m.Remove(linkPath)
+			report.add(linkPath, targetPath, linkState, StatusUnlinked, "", "")
+			return false, nil
+
+		default:
+			// Links may still exist further down
+			return true, nil
+		}
+	}
+
+	if err := walkSourceRec(linkRoot, targetRoot, opts, handler); err != nil {
+		return report, err
+	}
+	remotes, err := cacheRemotes(nil, targetRoot, opts, false)
+	if err != nil {
+		return report, err
+	}
+	if err := walkRemotes(remotes, opts, handler); err != nil {
+		return report, err
+	}
+	if err := removeCreatedDirs(m, linkRoot); err != nil {
+		return report, err
+	}
+	return report, m.Save()
+}
+
+func NewUnlinkCmd() *cobra.Command {
+
+	var opts linkOptions
+	var configPath string
+	var all, jsonOut bool
+
+	runUnlink := func(cmd *cobra.Command, args []string) error {
+
+		linkPath, targetPath := args[0], args[1]
+		if err := expandArgs(&opts, &linkPath, &targetPath); err != nil {
+			return err
+		}
+		if subtree, err := opts.walkRoot(targetPath); err != nil {
+			return err
+		} else if opts.subdir != "" && !fileutil.PathExists(subtree) {
+			return fmt.Errorf("subdir %s doesn't exist in %s", opts.subdir, targetPath)
+		}
+
+		cfg, err := loadOptions(cmd, configPath, targetPath, &opts)
+		if err != nil {
+			return err
+		}
+
+		m, err := loadManifest(linkPath, targetPath)
+		if err != nil {
+			return err
+		}
+
+		report, err := removeSymlinks(linkPath, targetPath, opts, all, m)
+		if err != nil {
+			return err
+		}
+
+		baseReport, err := unlinkBase(linkPath, targetPath, cfg, opts, all)
+		if err != nil {
+			return err
+		}
+		if baseReport != nil {
+			report.Entries = append(report.Entries, baseReport.Entries...)
+		}
+
+		if jsonOut {
+			return report.WriteJSON(cmd.OutOrStdout())
+		}
+		return nil
+	}
+
+	cmd := &cobra.Command{
+		Use:   "unlink link_path target_path",
+		Short: "Remove symlinks in link_path that lnkit created to target_path",
+		Args:  cobra.ExactArgs(2),
+		RunE:  runUnlink,
+		Example: `
+			lnk unlink ~ ~/dotfiles
+			lnk unlink --all ~/.config ~/dotfiles/.config
+		`,
+	}
+	cmd.Flags().BoolVar(&all, "all", false, "Also remove links to target_path that lnkit didn't create")
+	cmd.Flags().BoolVar(&jsonOut, "json", false, "Print a JSON report of every entry, with reason codes for skipped and failed ones")
+	cmd.Flags().StringVar(&configPath, "config", "", "Config file (default: lnkit.toml in target_path)")
+	cmd.Flags().StringVar(&opts.root, "root", "", "Treat paths as inside this alternate root, e.g. a new system mounted at /mnt")
+	cmd.Flags().StringVar(&opts.subdir, "subdir", "", "Only remove links to this subdirectory of target_path")
+
+	return cmd
+}
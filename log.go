@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"lnkit/fileutil"
+
+	"github.com/spf13/cobra"
+)
+
+func NewLogCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "log link_path target_path",
+		Short: "Show the symlinks lnkit replaced from link_path to target_path, and where they used to point",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			linkPath, err := fileutil.ExpandPath(args[0])
+			if err != nil {
+				return fmt.Errorf("failed to expand link path: %w", err)
+			}
+			targetPath, err := fileutil.ExpandPath(args[1])
+			if err != nil {
+				return fmt.Errorf("failed to expand target path: %w", err)
+			}
+
+			m, err := loadManifest(linkPath, targetPath)
+			if err != nil {
+				return err
+			}
+			w := cmd.OutOrStdout()
+			if len(m.Replaced) == 0 {
+				fmt.Fprintf(w, "No symlinks replaced from %s to %s\n", linkPath, targetPath)
+				return nil
+			}
+
+			// Newest first
+			for i := len(m.Replaced) - 1; i >= 0; i-- {
+				r := m.Replaced[i]
+				fmt.Fprintf(w, "%s  %s\n    was: %s\n    now: %s\n", r.Time.Local().Format(time.DateTime), r.LinkPath, r.OldTarget, r.NewTarget)
+			}
+			return nil
+		},
+		Example: `
+			lnk log ~ ~/dotfiles
+		`,
+	}
+
+	return cmd
+}
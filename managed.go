@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"slices"
+
+	"lnkit/fileutil"
+	"lnkit/manifest"
+)
+
+// Ways of marking links as created by lnkit
+const (
+	MarkerManifest = "manifest" // Only record links in the manifest
+	MarkerXattr    = "xattr"    // Also tag the links themselves with an xattr, where supported
+)
+
+// loadManifest loads the manifest for links from linkRoot to targetRoot.
+func loadManifest(linkRoot, targetRoot string) (*manifest.Manifest, error) {
+	return manifest.LoadFor(linkRoot, targetRoot)
+}
+
+// recordLinks adds every link and backup created during a run from linkRoot to
+// targetRoot to their manifest and, if marker is MarkerXattr, tags the links themselves as well.
+// Links left behind by sources renamed since the last run are moved first (see trackRenames),
+// and entries for paths that no longer exist are dropped, as a light version of lnk gc.
+func recordLinks(report *Report, linkRoot, targetRoot, marker string) error {
+	m, err := loadManifest(linkRoot, targetRoot)
+	if err != nil {
+		return err
+	}
+	hashes := targetHashes(report)
+	if err := trackRenames(report, m, hashes); err != nil {
+		return err
+	}
+	addToManifest(m, report, marker, hashes)
+	m.Prune()
+	return m.Save()
+}
+
+// trackRenames finds managed links whose target is gone but whose content was just
+// linked under a new name, i.e. the source was renamed. The old link is removed and
+// the new one reported as renamed from it, instead of leaving a broken link behind.
+// Content that more than one gone or newly linked file has, like that of empty
+// files, says nothing about which was renamed to which, so it's never matched.
+func trackRenames(report *Report, m *manifest.Manifest, hashes map[string]string) error {
+	orphans := map[string][]manifest.Record{}
+	for _, r := range m.Links {
+		if r.Hash != "" && !fileutil.PathExists(r.TargetPath) {
+			orphans[r.Hash] = append(orphans[r.Hash], r)
+		}
+	}
+	if len(orphans) == 0 {
+		return nil
+	}
+
+	linked := map[string][]*Entry{}
+	for i := range report.Entries {
+		e := &report.Entries[i]
+		if hash, ok := hashes[e.TargetPath]; ok && e.Status == StatusLinked {
+			linked[hash] = append(linked[hash], e)
+		}
+	}
+
+	for hash, records := range orphans {
+		if len(records) != 1 || len(linked[hash]) != 1 {
+			continue
+		}
+		r, e := records[0], linked[hash][0]
+		if linked, _ := fileutil.IsSymlinkPointingTo(r.LinkPath, r.TargetPath); linked {
+			if err := fileutil.RemoveSymlink(r.LinkPath); err != nil {
+				return err
+			}
+		}
+		m.Remove(r.LinkPath)
+
+		printEntry("Renamed: %s", linkString(r.LinkPath, e.LinkPath))
+		e.Status = StatusRenamed
+		e.RenamedFrom = r.LinkPath
+	}
+	return nil
+}
+
+// targetHashes returns the content hashes of the regular files linked during a run,
+// by target path, so each is only hashed once.
+func targetHashes(report *Report) map[string]string {
+	hashes := map[string]string{}
+	for _, e := range report.Entries {
+		if e.Status != StatusLinked || !fileutil.IsRegularFile(e.TargetPath) {
+			continue
+		}
+		if hash, err := hashTarget(e.TargetPath); err == nil {
+			hashes[e.TargetPath] = hash
+		}
+	}
+	return hashes
+}
+
+// hashTarget returns the hex content hash of the file at path.
+func hashTarget(path string) (string, error) {
+	hash, err := fileutil.HashFile(path)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash), nil
+}
+
+// addToManifest adds every link, created directory, backup, and replaced symlink in
+// report to m, with the content hashes of linked files from targetHashes, and notes
+// when links already in place were last seen.
+func addToManifest(m *manifest.Manifest, report *Report, marker string, hashes map[string]string) {
+	for _, e := range report.Entries {
+		if e.Backup != "" {
+			m.AddBackup(e.LinkPath, e.Backup)
+		}
+		if e.Status == StatusUnchanged {
+			m.Verify(e.LinkPath)
+			continue
+		}
+		if e.Status != StatusLinked && e.Status != StatusRenamed {
+			continue
+		}
+		m.Add(e.LinkPath, e.TargetPath)
+		if e.OldTarget != "" {
+			m.AddReplacement(e.LinkPath, e.OldTarget, e.TargetPath)
+		}
+		if hash, ok := hashes[e.TargetPath]; ok {
+			m.SetHash(e.LinkPath, hash)
+		}
+		for _, dir := range e.CreatedDirs {
+			m.AddDir(dir)
+		}
+
+		if marker == MarkerXattr {
+			if err := fileutil.SetLinkMarker(e.LinkPath); errors.Is(err, fileutil.ErrMarkerUnsupported) {
+				sugar.Debugf("Can't mark %s, relying on the manifest: %v", e.LinkPath, err)
+			} else if err != nil {
+				sugar.Warnf("Failed to mark %s: %v", e.LinkPath, err)
+			}
+		}
+	}
+}
+
+// removeCreatedDirs removes the directories under linkRoot that lnkit created to
+// hold links, once unlinking has left them empty. Deeper directories go first so
+// their parents can empty out too.
+func removeCreatedDirs(m *manifest.Manifest, linkRoot string) error {
+	dirs := slices.Clone(m.Dirs)
+	slices.Reverse(dirs)
+	for _, dir := range dirs {
+		if inRoot, _ := fileutil.IsChildPath(dir, linkRoot); !inRoot {
+			continue
+		}
+		removed, err := fileutil.RemoveEmptyDir(dir)
+		if err != nil {
+			return err
+		}
+		if removed {
+			sugar.Infof("Removed directory: %s", dir)
+			m.RemoveDir(dir)
+		}
+	}
+	return nil
+}
+
+// isManaged returns true if lnkit created the link at linkPath pointing to targetPath.
+func isManaged(m *manifest.Manifest, linkPath, targetPath string) bool {
+	return m.Owns(linkPath, targetPath) || fileutil.HasLinkMarker(linkPath)
+}
@@ -0,0 +1,326 @@
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"lnkit/fileutil"
+)
+
+const fileName = "manifest.json"
+
+// Version is the manifest format version, bumped on incompatible changes.
+const Version = 1
+
+// Record is a link created by lnkit.
+type Record struct {
+	LinkPath   string `json:"link_path"`
+	TargetPath string `json:"target_path"`
+	Hash       string `json:"hash,omitempty"` // Content hash of the target file when linked, to follow renames
+
+	Created  *time.Time `json:"created,omitempty"`  // When the link was created
+	Verified *time.Time `json:"verified,omitempty"` // When a run last found the link in place
+}
+
+// Backup is a file lnkit moved out of the way to make room for a link.
+type Backup struct {
+	OriginalPath string `json:"original_path"`
+	BackupPath   string `json:"backup_path"`
+}
+
+// Replacement is a symlink lnkit replaced because it pointed somewhere else, kept
+// so where it used to point can still be traced once the link is fixed.
+type Replacement struct {
+	LinkPath  string    `json:"link_path"`
+	OldTarget string    `json:"old_target"` // The old link's target, exactly as it was written
+	NewTarget string    `json:"new_target"`
+	Time      time.Time `json:"time"`
+}
+
+// MaxReplaced is how many replacements a manifest keeps; older ones are dropped.
+const MaxReplaced = 500
+
+// Manifest is the persistent record of every link lnkit has created, used to tell
+// them apart from links made by the user or other tools, and of the backups it made.
+type Manifest struct {
+	path    string
+	Version int `json:"version"`
+
+	// The link and target roots this manifest belongs to, if it was loaded with
+	// LoadFor. Exports carry them so an import lands in the right namespace.
+	LinkRoot   string `json:"link_root,omitempty"`
+	TargetRoot string `json:"target_root,omitempty"`
+
+	Links   map[string]Record `json:"links"`          // Keyed by link path
+	Backups map[string]Backup `json:"backups"`        // Keyed by backup path
+	Dirs    []string          `json:"dirs,omitempty"` // Directories lnkit created to hold links, sorted
+
+	Replaced []Replacement `json:"replaced,omitempty"` // Symlinks lnkit replaced, oldest first
+}
+
+// StateDir returns the directory lnkit keeps its state in:
+// $XDG_STATE_HOME/lnkit, or ~/.local/state/lnkit if that is unset or not absolute.
+func StateDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(fileutil.XDGStateHome(home), "lnkit"), nil
+}
+
+// PathFor returns the path of the manifest for links from linkRoot to targetRoot.
+// Each (link root, target root) pair gets its own directory under StateDir, so
+// managing e.g. ~ and /etc from different sources never mixes their records.
+func PathFor(linkRoot, targetRoot string) (string, error) {
+	dir, err := StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "targets", namespace(linkRoot, targetRoot), fileName), nil
+}
+
+// namespace returns a short, stable directory name for a pair of roots.
+func namespace(linkRoot, targetRoot string) string {
+	sum := sha256.Sum256([]byte(filepath.Clean(linkRoot) + "\x00" + filepath.Clean(targetRoot)))
+	return hex.EncodeToString(sum[:8])
+}
+
+// All returns the paths of the manifests of every link/target pair under StateDir.
+func All() ([]string, error) {
+	dir, err := StateDir()
+	if err != nil {
+		return nil, err
+	}
+	return filepath.Glob(filepath.Join(dir, "targets", "*", fileName))
+}
+
+// LoadFor reads the manifest for links from linkRoot to targetRoot (see PathFor).
+func LoadFor(linkRoot, targetRoot string) (*Manifest, error) {
+	path, err := PathFor(linkRoot, targetRoot)
+	if err != nil {
+		return nil, err
+	}
+	m, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	m.LinkRoot, m.TargetRoot = filepath.Clean(linkRoot), filepath.Clean(targetRoot)
+	return m, nil
+}
+
+// Load reads the manifest at path. A missing file is an empty manifest.
+func Load(path string) (*Manifest, error) {
+	m := newManifest(path)
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return m, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read manifest %s: %w", path, err)
+	}
+
+	if err := m.decode(data); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %s: %w", path, err)
+	}
+	return m, nil
+}
+
+func newManifest(path string) *Manifest {
+	return &Manifest{
+		path:    path,
+		Version: Version,
+		Links:   map[string]Record{},
+		Backups: map[string]Backup{},
+	}
+}
+
+// Parse decodes an exported manifest, e.g. to find out which roots it belongs to
+// before importing it.
+func Parse(data []byte) (*Manifest, error) {
+	m := newManifest("")
+	if err := m.decode(data); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	return m, nil
+}
+
+// decode replaces the contents of m with the JSON manifest in data.
+func (m *Manifest) decode(data []byte) error {
+	decoded := newManifest(m.path)
+	if err := json.Unmarshal(data, decoded); err != nil {
+		return err
+	}
+	if decoded.Version > Version {
+		return fmt.Errorf("manifest version %d is newer than supported version %d", decoded.Version, Version)
+	}
+	if decoded.Links == nil {
+		decoded.Links = map[string]Record{}
+	}
+	if decoded.Backups == nil {
+		decoded.Backups = map[string]Backup{}
+	}
+	decoded.Version = Version
+	*m = *decoded
+	return nil
+}
+
+// Save writes the manifest back to where it was loaded from. The file is
+// replaced atomically (see fileutil.WriteFileAtomic) so an interrupted save
+// never leaves a truncated manifest.
+func (m *Manifest) Save() error {
+	if err := fileutil.CheckWritable("save manifest", m.path); err != nil {
+		return err
+	}
+	if err := fileutil.MkdirAll(filepath.Dir(m.path), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteFileAtomic(m.path, data, 0644)
+}
+
+// Path returns where the manifest is saved.
+func (m *Manifest) Path() string {
+	return m.path
+}
+
+// Prune forgets links, backups, and directories that no longer exist on disk,
+// and returns how many entries it dropped.
+func (m *Manifest) Prune() int {
+	pruned := 0
+	for linkPath := range m.Links {
+		if !fileutil.PathExists(linkPath) {
+			delete(m.Links, linkPath)
+			pruned++
+		}
+	}
+	for backupPath := range m.Backups {
+		if !fileutil.PathExists(backupPath) {
+			delete(m.Backups, backupPath)
+			pruned++
+		}
+	}
+	dirs := m.Dirs[:0]
+	for _, dir := range m.Dirs {
+		if fileutil.IsDir(dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	pruned += len(m.Dirs) - len(dirs)
+	m.Dirs = dirs
+	return pruned
+}
+
+// Add records that lnkit created a link at linkPath pointing to targetPath, just now.
+func (m *Manifest) Add(linkPath, targetPath string) {
+	now := timestamp()
+	m.Links[linkPath] = Record{LinkPath: linkPath, TargetPath: targetPath, Created: &now, Verified: &now}
+}
+
+// Verify records that the link at linkPath was just found in place.
+func (m *Manifest) Verify(linkPath string) {
+	if r, ok := m.Links[linkPath]; ok {
+		now := timestamp()
+		r.Verified = &now
+		m.Links[linkPath] = r
+	}
+}
+
+// timestamp returns the current time as recorded in manifests.
+func timestamp() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+// SetHash records the content hash of the target of the link at linkPath.
+func (m *Manifest) SetHash(linkPath, hash string) {
+	if r, ok := m.Links[linkPath]; ok {
+		r.Hash = hash
+		m.Links[linkPath] = r
+	}
+}
+
+// Remove forgets the link at linkPath.
+func (m *Manifest) Remove(linkPath string) {
+	delete(m.Links, linkPath)
+}
+
+// Owns returns true if lnkit created the link at linkPath pointing to targetPath.
+func (m *Manifest) Owns(linkPath, targetPath string) bool {
+	r, ok := m.Links[linkPath]
+	return ok && r.TargetPath == targetPath
+}
+
+// AddDir records that lnkit created the directory at path.
+func (m *Manifest) AddDir(path string) {
+	if i, found := slices.BinarySearch(m.Dirs, path); !found {
+		m.Dirs = slices.Insert(m.Dirs, i, path)
+	}
+}
+
+// RemoveDir forgets the directory at path.
+func (m *Manifest) RemoveDir(path string) {
+	if i, found := slices.BinarySearch(m.Dirs, path); found {
+		m.Dirs = slices.Delete(m.Dirs, i, i+1)
+	}
+}
+
+// AddBackup records that lnkit moved originalPath to backupPath.
+func (m *Manifest) AddBackup(originalPath, backupPath string) {
+	m.Backups[backupPath] = Backup{OriginalPath: originalPath, BackupPath: backupPath}
+}
+
+// AddReplacement records that lnkit just replaced a symlink at linkPath pointing to
+// oldTarget with one pointing to newTarget.
+func (m *Manifest) AddReplacement(linkPath, oldTarget, newTarget string) {
+	m.Replaced = append(m.Replaced, Replacement{LinkPath: linkPath, OldTarget: oldTarget, NewTarget: newTarget, Time: timestamp()})
+	if len(m.Replaced) > MaxReplaced {
+		m.Replaced = slices.Clone(m.Replaced[len(m.Replaced)-MaxReplaced:])
+	}
+}
+
+// Export writes the manifest as JSON, e.g. to move it to another machine.
+func (m *Manifest) Export(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(m)
+}
+
+// Import reads an exported manifest from r. With merge, its records are added to
+// the ones already in m; otherwise they replace them. Call Save to persist the result.
+func (m *Manifest) Import(r io.Reader, merge bool) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	imported := newManifest(m.path)
+	if err := imported.decode(data); err != nil {
+		return fmt.Errorf("failed to parse imported manifest: %w", err)
+	}
+
+	if !merge {
+		*m = *imported
+		return nil
+	}
+	for k, v := range imported.Links {
+		m.Links[k] = v
+	}
+	for k, v := range imported.Backups {
+		m.Backups[k] = v
+	}
+	for _, dir := range imported.Dirs {
+		m.AddDir(dir)
+	}
+	m.Replaced = append(m.Replaced, imported.Replaced...)
+	return nil
+}
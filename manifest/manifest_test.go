@@ -0,0 +1,176 @@
+package manifest
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMissing(t *testing.T) {
+	m, err := Load(filepath.Join(t.TempDir(), "manifest.json"))
+	require.NoError(t, err)
+	require.Empty(t, m.Links)
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "manifest.json")
+
+	m, err := Load(path)
+	require.NoError(t, err)
+	m.Add("/home/me/.bashrc", "/home/me/dotfiles/.bashrc")
+	m.Add("/home/me/.vimrc", "/home/me/dotfiles/.vimrc")
+	m.Remove("/home/me/.vimrc")
+	require.NoError(t, m.Save())
+
+	loaded, err := Load(path)
+	require.NoError(t, err)
+	require.True(t, loaded.Owns("/home/me/.bashrc", "/home/me/dotfiles/.bashrc"))
+	require.False(t, loaded.Owns("/home/me/.bashrc", "/elsewhere/.bashrc"))
+	require.False(t, loaded.Owns("/home/me/.vimrc", "/home/me/dotfiles/.vimrc"))
+}
+
+func TestStateDir(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", "/xdg/state")
+	dir, err := StateDir()
+	require.NoError(t, err)
+	require.Equal(t, "/xdg/state/lnkit", dir)
+
+	t.Setenv("XDG_STATE_HOME", "")
+	t.Setenv("HOME", "/home/me")
+	dir, err = StateDir()
+	require.NoError(t, err)
+	require.Equal(t, "/home/me/.local/state/lnkit", dir)
+}
+
+func TestPathFor(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	home, err := PathFor("/home/me", "/home/me/dotfiles")
+	require.NoError(t, err)
+	again, err := PathFor("/home/me/", "/home/me/dotfiles")
+	require.NoError(t, err)
+	etc, err := PathFor("/etc", "/home/me/dotfiles")
+	require.NoError(t, err)
+	require.Equal(t, home, again)
+	require.NotEqual(t, home, etc)
+
+	// Records for one pair of roots don't show up in another's manifest
+	m, err := LoadFor("/home/me", "/home/me/dotfiles")
+	require.NoError(t, err)
+	m.Add("/home/me/.bashrc", "/home/me/dotfiles/.bashrc")
+	require.NoError(t, m.Save())
+
+	other, err := LoadFor("/etc", "/home/me/dotfiles")
+	require.NoError(t, err)
+	require.Empty(t, other.Links)
+	require.Equal(t, "/etc", other.LinkRoot)
+}
+
+func TestExportImport(t *testing.T) {
+	dir := t.TempDir()
+
+	src, err := Load(filepath.Join(dir, "old.json"))
+	require.NoError(t, err)
+	src.Add("/home/me/.bashrc", "/home/me/dotfiles/.bashrc")
+	src.AddBackup("/home/me/.zshrc", "/home/me/.zshrc.bak")
+
+	var buf bytes.Buffer
+	require.NoError(t, src.Export(&buf))
+	exported := buf.String()
+
+	// Replace
+	dst, err := Load(filepath.Join(dir, "new.json"))
+	require.NoError(t, err)
+	dst.Add("/home/me/.vimrc", "/home/me/dotfiles/.vimrc")
+	require.NoError(t, dst.Import(strings.NewReader(exported), false))
+	require.True(t, dst.Owns("/home/me/.bashrc", "/home/me/dotfiles/.bashrc"))
+	require.False(t, dst.Owns("/home/me/.vimrc", "/home/me/dotfiles/.vimrc"))
+	require.Contains(t, dst.Backups, "/home/me/.zshrc.bak")
+
+	// Merge
+	dst.Add("/home/me/.vimrc", "/home/me/dotfiles/.vimrc")
+	require.NoError(t, dst.Import(strings.NewReader(exported), true))
+	require.True(t, dst.Owns("/home/me/.vimrc", "/home/me/dotfiles/.vimrc"))
+
+	// Imported state is saved to the importing manifest's path
+	require.NoError(t, dst.Save())
+	require.FileExists(t, filepath.Join(dir, "new.json"))
+}
+
+func TestImportRejectsNewerVersion(t *testing.T) {
+	m, err := Load(filepath.Join(t.TempDir(), "manifest.json"))
+	require.NoError(t, err)
+	require.Error(t, m.Import(strings.NewReader(`{"version": 99, "links": {}}`), false))
+}
+
+func TestDirs(t *testing.T) {
+	m, err := Load(filepath.Join(t.TempDir(), "manifest.json"))
+	require.NoError(t, err)
+
+	m.AddDir("/home/me/.config/nvim")
+	m.AddDir("/home/me/.config")
+	m.AddDir("/home/me/.config/nvim")
+	require.Equal(t, []string{"/home/me/.config", "/home/me/.config/nvim"}, m.Dirs)
+
+	m.RemoveDir("/home/me/.config")
+	m.RemoveDir("/nowhere")
+	require.Equal(t, []string{"/home/me/.config/nvim"}, m.Dirs)
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	m, err := Load(filepath.Join(dir, "manifest.json"))
+	require.NoError(t, err)
+
+	kept := filepath.Join(dir, "kept")
+	require.NoError(t, os.Symlink("/nowhere", kept)) // Broken links are still there
+	m.Add(kept, "/nowhere")
+	m.Add(filepath.Join(dir, "gone"), "/nowhere")
+	m.AddBackup(kept, filepath.Join(dir, "kept.bak"))
+	m.AddDir(dir)
+	m.AddDir(filepath.Join(dir, "removed"))
+
+	require.Equal(t, 3, m.Prune())
+	require.Len(t, m.Links, 1)
+	require.Contains(t, m.Links, kept)
+	require.Empty(t, m.Backups)
+	require.Equal(t, []string{dir}, m.Dirs)
+}
+
+func TestTimestamps(t *testing.T) {
+	m, err := Load(filepath.Join(t.TempDir(), "manifest.json"))
+	require.NoError(t, err)
+
+	before := time.Now().Add(-time.Second)
+	m.Add("/home/me/.bashrc", "/dots/.bashrc")
+	r := m.Links["/home/me/.bashrc"]
+	require.NotNil(t, r.Created)
+	require.True(t, r.Created.After(before))
+	require.Equal(t, r.Created, r.Verified)
+
+	// Verifying later keeps the creation time
+	created := r.Created.Add(-time.Hour)
+	r.Created, r.Verified = &created, &created
+	m.Links["/home/me/.bashrc"] = r
+	m.Verify("/home/me/.bashrc")
+	m.Verify("/nowhere")
+	require.Equal(t, created, *m.Links["/home/me/.bashrc"].Created)
+	require.True(t, m.Links["/home/me/.bashrc"].Verified.After(created))
+	require.NotContains(t, m.Links, "/nowhere")
+}
+
+func TestAddReplacementKeepsTheNewest(t *testing.T) {
+	m := newManifest(filepath.Join(t.TempDir(), "manifest.json"))
+	for i := 0; i < MaxReplaced+2; i++ {
+		m.AddReplacement("/home/me/.bashrc", fmt.Sprintf("/old/%d", i), "/dots/.bashrc")
+	}
+	require.Len(t, m.Replaced, MaxReplaced)
+	require.Equal(t, "/old/2", m.Replaced[0].OldTarget)
+	require.Equal(t, fmt.Sprintf("/old/%d", MaxReplaced+1), m.Replaced[MaxReplaced-1].OldTarget)
+}
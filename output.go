@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"lnkit/stringutil"
+
+	"github.com/fatih/color"
+	"github.com/mattn/go-isatty"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Program logs (zap, on stderr or --log-file) and results meant for the user
+// (tables, trees, prompts, on stdout) are written and colored independently, so
+// redirecting one doesn't drag the other's colors or verbosity along with it.
+
+// colorModes are the values of --color and --log-color.
+var colorModes = []string{"auto", "always", "never"}
+
+// logSettings are where program logs go and how they look.
+var logSettings = struct {
+	level     string
+	userLevel bool            // Whether the level was chosen with --log-level
+	atom      zap.AtomicLevel // The level of the current logger, which can change under it
+	output    string          // A path, or "stderr"
+	color     string
+	close     func()
+}{level: "debug", atom: zap.NewAtomicLevelAt(zap.DebugLevel), output: "stderr", color: "auto"}
+
+// quietLogs raises the level of program logs to logLevel for commands whose own
+// output is all that should be printed, unless the user chose a level with
+// --log-level. Where the logs go is left as it is.
+func quietLogs(logLevel string) {
+	if logSettings.userLevel {
+		return
+	}
+	var level zapcore.Level
+	if err := level.UnmarshalText([]byte(logLevel)); err != nil {
+		return
+	}
+	if level > logSettings.atom.Level() {
+		logSettings.atom.SetLevel(level)
+	}
+}
+
+// userColor is whether output meant for the user is colored when --color is auto,
+// as decided by the color package from stdout and NO_COLOR.
+var userColor = !color.NoColor
+
+// useColor resolves a color mode for output written to f.
+func useColor(mode string, f *os.File) bool {
+	switch mode {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	return isatty.IsTerminal(f.Fd()) || isatty.IsCygwinTerminal(f.Fd())
+}
+
+// validColorMode checks the value of a color flag.
+func validColorMode(flag, mode string) error {
+	for _, m := range colorModes {
+		if mode == m {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid --%s %q, expected auto, always, or never", flag, mode)
+}
+
+// setUserColor applies --color to output meant for the user.
+func setUserColor(mode string) {
+	switch mode {
+	case "always":
+		color.NoColor = false
+	case "never":
+		color.NoColor = true
+	default:
+		color.NoColor = !userColor
+	}
+}
+
+// openLogOutput opens where logs are written. Unless they are colored, escape codes
+// are stripped from them, including those of colored paths in log messages.
+func openLogOutput() (zapWriter, bool, error) {
+	w, closeOutput, err := zap.Open(logSettings.output)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to open log output %s: %w", logSettings.output, err)
+	}
+	if logSettings.close != nil {
+		logSettings.close()
+	}
+	logSettings.close = closeOutput
+
+	colored := logSettings.color == "always" || (logSettings.output == "stderr" && useColor(logSettings.color, os.Stderr))
+	if !colored {
+		return plainWriter{w}, false, nil
+	}
+	return w, true, nil
+}
+
+// zapWriter is where a logger writes.
+type zapWriter interface {
+	io.Writer
+	Sync() error
+}
+
+// plainWriter strips ANSI escape codes from what is written through it.
+type plainWriter struct {
+	zapWriter
+}
+
+func (w plainWriter) Write(p []byte) (int, error) {
+	if _, err := w.zapWriter.Write([]byte(stringutil.StripANSI(string(p)))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
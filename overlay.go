@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"lnkit/fileutil"
+	"lnkit/stringutil"
+
+	"github.com/spf13/cobra"
+)
+
+// resolveBase returns the shared base repo layered under targetRoot, from its
+// config's base option resolved against targetRoot if relative, or "" if it has none.
+func resolveBase(targetRoot string, cfg Config) (string, error) {
+	base := cfg.Options.Base
+	if base == "" {
+		return "", nil
+	}
+	if !filepath.IsAbs(base) && !strings.HasPrefix(base, "~") && !strings.HasPrefix(base, "$") {
+		base = filepath.Join(targetRoot, base)
+	}
+	base, err := fileutil.ExpandPath(base)
+	if err != nil {
+		return "", fmt.Errorf("failed to expand base repo: %w", err)
+	}
+	if !fileutil.IsDir(base) {
+		return "", fmt.Errorf("base repo %s is not a directory", base)
+	}
+	return base, nil
+}
+
+// shadowedBy returns the path in the upper layer that hides targetPath in the lower
+// layer targetRoot, if any. Directories that are walked into rather than linked
+// whole don't shadow each other, only what is in them does.
+func shadowedBy(upper, targetRoot, targetPath string, opts linkOptions) (string, bool) {
+	rel, _ := filepath.Rel(targetRoot, targetPath)
+	if rel == "." {
+		return "", false
+	}
+	upperPath := filepath.Join(upper, rel)
+	if !fileutil.PathExists(upperPath) {
+		return "", false
+	}
+	if opts.recursive && !opts.fold && fileutil.IsDir(targetPath) && fileutil.IsDir(upperPath) {
+		return "", false
+	}
+	return upperPath, true
+}
+
+// linkBase links the base repo layered under targetRoot, if its config has one, into
+// linkRoot, skipping whatever targetRoot shadows. The base's own config isn't used.
+func linkBase(linkRoot, targetRoot string, cfg Config, opts linkOptions) (*Report, error) {
+	base, err := resolveBase(targetRoot, cfg)
+	if err != nil || base == "" {
+		return nil, err
+	}
+
+	opts.shadow = targetRoot
+	opts.exceptions = nil
+	if subtree, _ := opts.walkRoot(base); !fileutil.IsDir(subtree) {
+		return nil, nil // The base has nothing in the subtree being linked
+	}
+	report, err := createSymlinks(linkRoot, base, opts)
+	if err != nil {
+		return report, fmt.Errorf("base repo %s: %w", base, err)
+	}
+	if !opts.dryRun {
+		if err := recordLinks(report, linkRoot, base, cfg.Options.ManagedMarker); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// unlinkBase removes the links lnkit created to the base repo layered under
+// targetRoot, if its config has one.
+func unlinkBase(linkRoot, targetRoot string, cfg Config, opts linkOptions, all bool) (*Report, error) {
+	base, err := resolveBase(targetRoot, cfg)
+	if err != nil || base == "" {
+		return nil, err
+	}
+
+	m, err := loadManifest(linkRoot, base)
+	if err != nil {
+		return nil, err
+	}
+	opts.exceptions = nil
+	if subtree, _ := opts.walkRoot(base); !fileutil.IsDir(subtree) {
+		return nil, nil
+	}
+	return removeSymlinks(linkRoot, base, opts, all, m)
+}
+
+// overlayEntry is a path that differs between the base and personal layers.
+type overlayEntry struct {
+	rel    string
+	status string
+}
+
+// diffOverlay compares the base layer with the personal layer above it, listing
+// files that only one of them has and files the personal layer overrides.
+func diffOverlay(base, personal string, ignore *fileutil.IgnoreSet) ([]overlayEntry, error) {
+	var entries []overlayEntry
+	walk := func(root, other, onlyStatus string, compare bool) error {
+		return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, _ := filepath.Rel(root, path)
+			if rel == "." {
+				return nil
+			}
+			if ignore.Match(rel, info.IsDir()) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if info.IsDir() {
+				return nil
+			}
+
+			otherPath := filepath.Join(other, rel)
+			if !fileutil.PathExists(otherPath) {
+				entries = append(entries, overlayEntry{rel: rel, status: onlyStatus})
+			} else if compare {
+				if same, _ := fileutil.CompareFileHashes(path, otherPath); same {
+					entries = append(entries, overlayEntry{rel: rel, status: "overridden (identical)"})
+				} else {
+					entries = append(entries, overlayEntry{rel: rel, status: "overridden"})
+				}
+			}
+			return nil
+		})
+	}
+
+	if err := walk(base, personal, "shared only", true); err != nil {
+		return nil, err
+	}
+	if err := walk(personal, base, "personal only", false); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func NewOverlayCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "overlay",
+		Short: "Inspect a personal repo layered over a shared base repo",
+	}
+	cmd.AddCommand(newOverlayDiffCmd())
+	return cmd
+}
+
+func newOverlayDiffCmd() *cobra.Command {
+	var opts linkOptions
+	var configPath string
+	var patch bool
+
+	cmd := &cobra.Command{
+		Use:   "diff target_path",
+		Short: "Show where the personal repo at target_path diverges from its base repo",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			targetPath, err := fileutil.ExpandPath(args[0])
+			if err != nil {
+				return fmt.Errorf("failed to expand target path: %w", err)
+			}
+
+			cfg, err := loadOptions(cmd, configPath, targetPath, &opts)
+			if err != nil {
+				return err
+			}
+			base, err := resolveBase(targetPath, cfg)
+			if err != nil {
+				return err
+			}
+			if base == "" {
+				return fmt.Errorf("%s has no base repo, set options.base in its config", targetPath)
+			}
+
+			entries, err := diffOverlay(base, targetPath, opts.ignore)
+			if err != nil {
+				return err
+			}
+			w := cmd.OutOrStdout()
+			if len(entries) == 0 {
+				fmt.Fprintln(w, "No divergence from the base repo")
+				return nil
+			}
+
+			rows := make([][2]string, len(entries))
+			for i, e := range entries {
+				rows[i] = [2]string{e.rel, e.status}
+			}
+			stringutil.FprintDotTable(w, rows)
+
+			if patch {
+				for _, e := range entries {
+					if e.status == "overridden" {
+						previewFiles(w, filepath.Join(base, e.rel), filepath.Join(targetPath, e.rel), opts.hexdiff)
+					}
+				}
+			}
+			return nil
+		},
+		Example: `
+			lnk overlay diff ~/dotfiles
+			lnk overlay diff --patch ~/dotfiles
+		`,
+	}
+	cmd.Flags().BoolVar(&patch, "patch", false, "Also show the diff of every overridden file")
+	cmd.Flags().BoolVar(&opts.hexdiff, "hexdiff", false, "With --patch, diff binary files byte by byte instead of by size and hash")
+	cmd.Flags().StringVar(&configPath, "config", "", "Config file (default: lnkit.toml in target_path)")
+
+	return cmd
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"lnkit/fileutil"
+)
+
+// pkgManagers are the package managers asked whether a package is installed, each
+// only if it is on the PATH. Nothing is ever installed.
+var pkgManagers = []struct {
+	name      string
+	installed func(out string, err error) bool // Interprets the output of the query
+	args      []string                         // Query, followed by the package name
+}{
+	{"brew", func(out string, err error) bool { return err == nil && out != "" }, []string{"list", "--versions"}},
+	{"dpkg-query", func(out string, err error) bool { return err == nil && strings.Contains(out, "install ok installed") }, []string{"-W", "-f=${Status}"}},
+	{"rpm", func(out string, err error) bool { return err == nil }, []string{"-q"}},
+	{"pacman", func(out string, err error) bool { return err == nil }, []string{"-Q"}},
+}
+
+// pkgInstalled reports whether pkg is installed according to any available
+// package manager, or else whether a command of the same name is on the PATH.
+var pkgInstalled = func(pkg string) bool {
+	for _, pm := range pkgManagers {
+		if _, err := exec.LookPath(pm.name); err != nil {
+			continue
+		}
+		out, err := exec.Command(pm.name, append(pm.args, pkg)...).Output()
+		if pm.installed(strings.TrimSpace(string(out)), err) {
+			return true
+		}
+	}
+	_, err := exec.LookPath(pkg)
+	return err == nil
+}
+
+// pkgHint is an exception whose link disagrees with whether its package is installed.
+type pkgHint struct {
+	Source    string `json:"source"`
+	Package   string `json:"package"`
+	LinkPath  string `json:"link_path"`
+	Linked    bool   `json:"linked"`
+	Installed bool   `json:"installed"`
+}
+
+func (h pkgHint) String() string {
+	if h.Linked {
+		return fmt.Sprintf("linked, but package %s isn't installed", h.Package)
+	}
+	return fmt.Sprintf("package %s is installed, but its config isn't linked", h.Package)
+}
+
+// checkPackages compares, for every exception from targetRoot that declares the
+// package it configures, whether it is linked into linkRoot with whether the
+// package is installed, and returns the ones that disagree, sorted by source.
+func checkPackages(linkRoot, targetRoot string, opts linkOptions) ([]pkgHint, error) {
+	exceptions, err := resolveExceptions(opts.exceptions, linkRoot, opts.root)
+	if err != nil {
+		return nil, err
+	}
+
+	var hints []pkgHint
+	for source, m := range exceptions {
+		targetPath := filepath.Join(targetRoot, source)
+		if m.RequiresPkg == "" || m.Target == "" || !fileutil.PathExists(targetPath) {
+			continue
+		}
+		state, err := determineTargetState(m.Target, targetPath, targetRoot, opts)
+		if err != nil {
+			return nil, err
+		}
+		h := pkgHint{
+			Source:    source,
+			Package:   m.RequiresPkg,
+			LinkPath:  m.Target,
+			Linked:    state == LAlreadyLinked,
+			Installed: pkgInstalled(m.RequiresPkg),
+		}
+		if h.Linked != h.Installed {
+			hints = append(hints, h)
+		}
+	}
+	sort.Slice(hints, func(i, j int) bool { return hints[i].Source < hints[j].Source })
+	return hints, nil
+}
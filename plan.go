@@ -0,0 +1,288 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	api "lnkit/api/v1"
+	"lnkit/fileutil"
+
+	"github.com/spf13/cobra"
+)
+
+// approvalKeyEnv names the environment variable holding the key plans are signed
+// with. If it is set, lnk apply refuses plans without a valid signature.
+const approvalKeyEnv = "LNKIT_APPROVAL_KEY"
+
+// planFile is a plan written for approval, with what is needed to apply it later:
+// the roots and options it was made with, how conflicts are to be resolved, and a
+// hash of the state of every path involved, so it is only applied to what was reviewed.
+type planFile struct {
+	LinkRoot   string   `json:"link_root"`
+	TargetRoot string   `json:"target_root"`
+	Recursive  bool     `json:"recursive"`
+	Fold       bool     `json:"fold"`
+	Config     string   `json:"config,omitempty"` // The --config it was planned with, if any
+	Resolve    string   `json:"resolve"`          // What to do with conflicts: skip, backup, or overwrite
+	StateHash  string   `json:"state_hash"`
+	Plan       api.Plan `json:"plan"`
+}
+
+// fingerprint describes what is at path, as far as applying a plan is concerned.
+func fingerprint(path string) string {
+	switch {
+	case fileutil.IsSymlink(path):
+		target, _ := os.Readlink(path)
+		return "link:" + target
+	case fileutil.IsRegularFile(path):
+		hash, err := fileutil.HashFile(path)
+		if err != nil {
+			return "unreadable"
+		}
+		return "file:" + hex.EncodeToString(hash)
+	case fileutil.IsDir(path):
+		return "dir"
+	}
+	return "none"
+}
+
+// stateHash hashes the state of both ends of every entry of plan, so a plan can
+// be checked against the filesystem it is applied to.
+func stateHash(plan *Report) string {
+	lines := make([]string, len(plan.Entries))
+	for i, e := range plan.Entries {
+		lines[i] = strings.Join([]string{e.LinkPath, fingerprint(e.LinkPath), e.TargetPath, fingerprint(e.TargetPath), string(e.Status)}, "\x00")
+	}
+	sort.Strings(lines)
+	sum := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// signPlan returns the hex HMAC-SHA256 of a plan file's contents under key.
+func signPlan(data []byte, key string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyPlan checks the signature of a plan file's contents. Without a key in
+// approvalKeyEnv there is nothing to check against, so a signature is an error.
+func verifyPlan(data []byte, signature string) error {
+	key := os.Getenv(approvalKeyEnv)
+	switch {
+	case key == "" && signature != "":
+		return fmt.Errorf("can't check the signature, %s isn't set", approvalKeyEnv)
+	case key == "":
+		return nil
+	case signature == "":
+		return fmt.Errorf("%s is set, so the plan must be signed (see lnk approve)", approvalKeyEnv)
+	}
+	if !hmac.Equal([]byte(strings.TrimSpace(signature)), []byte(signPlan(data, key))) {
+		return errors.New("the plan's signature doesn't match, it may have been changed since it was approved")
+	}
+	return nil
+}
+
+// applyLinks links targetRoot, and the base repo under it, into linkRoot and records the links.
+func applyLinks(linkRoot, targetRoot string, cfg Config, opts linkOptions) (*Report, error) {
+	report, err := createSymlinks(linkRoot, targetRoot, opts)
+	if err == nil {
+		err = recordLinks(report, linkRoot, targetRoot, cfg.Options.ManagedMarker)
+	}
+	if err == nil {
+		var baseReport *Report
+		baseReport, err = linkBase(linkRoot, targetRoot, cfg, opts)
+		if baseReport != nil {
+			report.Entries = append(report.Entries, baseReport.Entries...)
+		}
+	}
+	return report, err
+}
+
+var resolveNames = map[string]Resolution{
+	"skip":      ResolveSkip,
+	"backup":    ResolveBackup,
+	"overwrite": ResolveOverwrite,
+}
+
+func NewPlanCmd() *cobra.Command {
+	var opts linkOptions
+	var configPath, output, resolve string
+
+	cmd := &cobra.Command{
+		Use:   "plan link_path target_path",
+		Short: "Write what linking target_path into link_path would do to a file, for approval elsewhere",
+		Long: `Write what linking target_path into link_path would do, and a hash of the current
+state of every path involved, to a JSON file. Review it anywhere, optionally sign it
+with lnk approve, and apply it with lnk apply --approved, which refuses to if anything
+changed in the meantime. No terminal is needed on the machine being changed.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if _, ok := resolveNames[resolve]; !ok {
+				return fmt.Errorf("unknown resolution %q, expected skip, backup, or overwrite", resolve)
+			}
+			linkPath, err := fileutil.ExpandPath(args[0])
+			if err != nil {
+				return fmt.Errorf("failed to expand link path: %w", err)
+			}
+			targetPath, err := fileutil.ExpandPath(args[1])
+			if err != nil {
+				return fmt.Errorf("failed to expand target path: %w", err)
+			}
+			// The plan may be applied from anywhere, so its config must be too
+			if configPath != "" {
+				if configPath, err = fileutil.ExpandPath(configPath); err != nil {
+					return fmt.Errorf("failed to expand config path: %w", err)
+				}
+			}
+
+			cfg, err := loadOptions(cmd, configPath, targetPath, &opts)
+			if err != nil {
+				return err
+			}
+			plan, err := planLinks(linkPath, targetPath, cfg, opts)
+			if err != nil {
+				return err
+			}
+
+			f := planFile{
+				LinkRoot:   linkPath,
+				TargetRoot: targetPath,
+				Recursive:  opts.recursive,
+				Fold:       opts.fold,
+				Config:     configPath,
+				Resolve:    resolve,
+				StateHash:  stateHash(plan),
+				Plan:       api.NewPlan(plan.Entries),
+			}
+			data, err := json.MarshalIndent(f, "", "  ")
+			if err != nil {
+				return err
+			}
+			data = append(data, '\n')
+			if output == "" || output == "-" {
+				_, err = cmd.OutOrStdout().Write(data)
+				return err
+			}
+			if err := fileutil.CheckWritable("write plan", output); err != nil {
+				return err
+			}
+			return fileutil.WriteFileAtomic(output, data, 0644)
+		},
+		Example: `
+			lnk plan --rec -o plan.json ~ ~/dotfiles
+		`,
+	}
+	cmd.Flags().BoolVar(&opts.recursive, "rec", false, "Recursively process nested directories")
+	cmd.Flags().BoolVar(&opts.fold, "fold", false, "Link whole directories where applicable")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "File to write the plan to (default: stdout)")
+	cmd.Flags().StringVar(&resolve, "resolve", "backup", "What applying does with conflicts: skip, backup, or overwrite")
+	cmd.Flags().StringVar(&configPath, "config", "", "Config file (default: lnkit.toml in target_path)")
+
+	return cmd
+}
+
+func NewApproveCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "approve plan_file",
+		Short: "Print the signature of a reviewed plan, signed with the key in " + approvalKeyEnv,
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key := os.Getenv(approvalKeyEnv)
+			if key == "" {
+				return fmt.Errorf("%s must be set to the key shared with the machine applying the plan", approvalKeyEnv)
+			}
+			data, err := os.ReadFile(args[0])
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), signPlan(data, key))
+			return nil
+		},
+		Example: `
+			lnk approve plan.json > plan.sig
+		`,
+	}
+}
+
+func NewApplyCmd() *cobra.Command {
+	var approved, signaturePath string
+
+	cmd := &cobra.Command{
+		Use:   "apply --approved plan_file",
+		Short: "Apply a plan written by lnk plan, if nothing it covers has changed since",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			data, err := os.ReadFile(approved)
+			if err != nil {
+				return err
+			}
+			signature := ""
+			if signaturePath != "" {
+				sig, err := os.ReadFile(signaturePath)
+				if err != nil {
+					return err
+				}
+				signature = string(sig)
+			}
+			if err := verifyPlan(data, signature); err != nil {
+				return err
+			}
+
+			var f planFile
+			if err := json.Unmarshal(data, &f); err != nil {
+				return fmt.Errorf("failed to parse plan %s: %w", approved, err)
+			}
+			if f.Plan.Version != api.Version {
+				return fmt.Errorf("plan %s is version %d, expected %d", approved, f.Plan.Version, api.Version)
+			}
+			resolution, ok := resolveNames[f.Resolve]
+			if !ok {
+				return fmt.Errorf("unknown resolution %q in plan %s", f.Resolve, approved)
+			}
+
+			// Links outside the link root were approved with the rest of the plan
+			opts := linkOptions{recursive: f.Recursive, fold: f.Fold, allowOutside: true}
+			cfg, err := loadOptions(cmd, f.Config, f.TargetRoot, &opts)
+			if err != nil {
+				return err
+			}
+			plan, err := planLinks(f.LinkRoot, f.TargetRoot, cfg, opts)
+			if err != nil {
+				return err
+			}
+			if stateHash(plan) != f.StateHash {
+				return fmt.Errorf("refusing to apply %s: %s or %s changed since it was planned; plan again", approved, f.LinkRoot, f.TargetRoot)
+			}
+
+			opts.resolutions = map[string]Resolution{}
+			for _, a := range f.Plan.Actions {
+				if a.Kind == api.ActionReplace {
+					opts.resolutions[a.LinkPath] = resolution
+				}
+			}
+			report, err := applyLinks(f.LinkRoot, f.TargetRoot, cfg, opts)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Applied: %s\n", report.Summary())
+			return nil
+		},
+		Example: `
+			lnk apply --approved plan.json
+			lnk apply --approved plan.json --signature plan.sig
+		`,
+	}
+	cmd.Flags().StringVar(&approved, "approved", "", "The approved plan file to apply")
+	cmd.Flags().StringVar(&signaturePath, "signature", "", "File with the plan's signature from lnk approve (required if "+approvalKeyEnv+" is set)")
+	cmd.MarkFlagRequired("approved")
+
+	return cmd
+}
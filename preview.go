@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"lnkit/fileutil"
+	"lnkit/stringutil"
+)
+
+// previewConflict shows how what is in the way of a link differs from its target,
+// next to the prompt asking what to do about it.
+func previewConflict(c conflict) {
+	previewFiles(os.Stdout, c.linkPath, c.targetPath, c.hexdiff)
+}
+
+// previewFiles writes to w how target differs from source: key by key for structured
+// config files, then line by line. Binary files are summarized by size and hash
+// instead, or compared as hex dumps with hexdiff.
+func previewFiles(w io.Writer, source, target string, hexdiff bool) {
+	if isBinary(source) || isBinary(target) {
+		if hexdiff {
+			previewHexDiff(w, source, target)
+		} else {
+			previewBinary(w, source, target)
+		}
+		return
+	}
+	PreviewSemanticDiff(w, source, target)
+	PreviewDiff(w, source, target)
+}
+
+// isBinary reports whether path is a regular file with binary content.
+func isBinary(path string) bool {
+	if !fileutil.IsRegularFile(path) {
+		return false
+	}
+	binary, err := fileutil.IsBinary(path)
+	return err == nil && binary
+}
+
+// previewBinary writes the size and hash of each of two files to w.
+func previewBinary(w io.Writer, source, target string) {
+	fmt.Fprintln(w, "Binary files differ (use --hexdiff to compare bytes):")
+	rows := make([][2]string, 0, 2)
+	for _, path := range []string{source, target} {
+		summary := "not a regular file"
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			hash, err := fileutil.HashFile(path)
+			if err != nil {
+				summary = err.Error()
+			} else {
+				summary = fmt.Sprintf("%d bytes, sha256 %x", info.Size(), hash)
+			}
+		}
+		rows = append(rows, [2]string{path, summary})
+	}
+	stringutil.FprintDotTable(w, rows)
+}
+
+// previewHexDiff writes the diff of hex dumps of two files to w.
+func previewHexDiff(w io.Writer, source, target string) {
+	if err := fileutil.CheckWritable("write hex dumps to", os.TempDir()); err != nil {
+		sugar.Errorf("Failed to prepare hex diff: %v", err)
+		return
+	}
+	dir, err := os.MkdirTemp("", "lnkit-hexdiff-")
+	if err != nil {
+		sugar.Errorf("Failed to prepare hex diff: %v", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	dumps := make([]string, 2)
+	for i, path := range []string{source, target} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			sugar.Errorf("Failed to prepare hex diff: %v", err)
+			return
+		}
+		dumps[i] = filepath.Join(dir, fmt.Sprintf("%d-%s.hex", i, filepath.Base(path)))
+		if err := os.WriteFile(dumps[i], []byte(hex.Dump(data)), 0600); err != nil {
+			sugar.Errorf("Failed to prepare hex diff: %v", err)
+			return
+		}
+	}
+	PreviewDiff(w, dumps[0], dumps[1])
+}
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"lnkit/fileutil"
+	"lnkit/stringutil"
+	"lnkit/ymlfs"
+
+	"github.com/BurntSushi/toml"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// Files in a record bundle
+const (
+	bundleMeta     = "meta.json"     // recordMeta
+	bundleConfig   = "lnkit.toml"    // The config the run used, if any
+	bundleIgnore   = ".lnkitignore"  // The source's ignore file, if any
+	bundleSource   = "source.yml"    // ymlfs snapshot of target_path
+	bundleLinks    = "links.yml"     // ymlfs snapshot of link_path, where the source has something
+	bundleInput    = "input.txt"     // Answers given to prompts
+	bundleReport   = "report.json"   // What the run did
+	bundleManifest = "manifest.json" // The manifest before the run
+)
+
+// bundleFiles are the names of the files readBundle reads; anything else is skipped.
+var bundleFiles = map[string]bool{
+	bundleMeta: true, bundleConfig: true, bundleIgnore: true, bundleSource: true,
+	bundleLinks: true, bundleInput: true, bundleReport: true, bundleManifest: true,
+}
+
+// maxBundleFile bounds the size of each file read from a bundle.
+const maxBundleFile = 64 << 20
+
+// replayFlags are the flags of a recorded run that a replay passes on. Others, such
+// as the persistent --log-file and --root, could make a replay of an untrusted
+// bundle write where it pleases, so they are neither recorded nor replayed.
+var replayFlags = map[string]bool{
+	"rec": true, "fold": true, "force": true, "create-dirs": true, "batch": true, "group": true,
+	"link-style": true, "hexdiff": true, "subdir": true, "max-depth": true, "max-entries": true,
+	"dry-run": true, "allow-outside": true,
+}
+
+// replayIgnored are the config options a replay drops, options and exception
+// fields, because they run a tool, fetch a URL, or reach outside the bundle.
+var replayIgnored = map[string][]string{
+	"options":    {"diff_tool", "diff_args", "base"},
+	"exceptions": {"source_url", "sha256"},
+}
+
+// recordMeta describes a recorded link run.
+type recordMeta struct {
+	LinkPath   string   `json:"link_path"`   // As given, i.e. inside Root if it is set
+	TargetPath string   `json:"target_path"` // As given
+	Root       string   `json:"root,omitempty"`
+	Args       []string `json:"args"` // Flags the run was given that a replay passes on
+	Redacted   bool     `json:"redacted"`
+	OS         string   `json:"os"`
+	Error      string   `json:"error,omitempty"` // Why the run failed, if it did
+}
+
+// recording captures a link run as it happens, to be written out as a bundle.
+type recording struct {
+	meta  recordMeta
+	files map[string][]byte
+	input bytes.Buffer
+}
+
+// startRecording snapshots what a link run from linkPath to targetPath starts from:
+// the config, the source tree, and whatever is in the way in linkPath. It then
+// records every answer given to a prompt. A base repo isn't captured.
+func startRecording(cmd *cobra.Command, linkPath, targetPath, configPath string, opts linkOptions, redact bool) (*recording, error) {
+	rec := &recording{
+		meta: recordMeta{
+			LinkPath:   fileutil.StripRoot(opts.root, linkPath),
+			TargetPath: fileutil.StripRoot(opts.root, targetPath),
+			Root:       opts.root,
+			Redacted:   redact,
+			OS:         runtime.GOOS,
+		},
+		files: map[string][]byte{},
+	}
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		if replayFlags[f.Name] {
+			rec.meta.Args = append(rec.meta.Args, fmt.Sprintf("--%s=%s", f.Name, f.Value))
+		}
+	})
+
+	if configPath == "" {
+		configPath = filepath.Join(targetPath, configFile)
+	}
+	for name, path := range map[string]string{bundleConfig: configPath, bundleIgnore: filepath.Join(targetPath, ignoreFile)} {
+		if !fileutil.IsRegularFile(path) {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		rec.files[name] = data
+	}
+
+	// Ignored directories such as .git are kept, but not what is in them
+	source, err := ymlfs.Snapshot(targetPath, ymlfs.SnapshotOptions{
+		Include: func(rel string, isDir bool) bool {
+			return !isDir || !opts.ignore.Match(rel, isDir)
+		},
+		Redact: redact,
+		Keep:   []string{configFile, ignoreFile},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to snapshot %s: %w", targetPath, err)
+	}
+	rec.files[bundleSource] = source
+
+	if fileutil.IsDir(linkPath) {
+		links, err := ymlfs.Snapshot(linkPath, ymlfs.SnapshotOptions{
+			Include: func(rel string, isDir bool) bool {
+				return fileutil.PathExists(filepath.Join(targetPath, rel))
+			},
+			Redact: redact,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to snapshot %s: %w", linkPath, err)
+		}
+		rec.files[bundleLinks] = links
+	}
+
+	m, err := loadManifest(linkPath, targetPath)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := m.Export(&buf); err != nil {
+		return nil, err
+	}
+	rec.files[bundleManifest] = buf.Bytes()
+
+	stringutil.RecordInput(&rec.input)
+	return rec, nil
+}
+
+// finish writes the recording, with the report of the run and the error it failed
+// with if any, to a gzipped tarball at path.
+func (rec *recording) finish(path string, report *Report, runErr error) error {
+	if report == nil {
+		report = &Report{}
+	}
+	if runErr != nil {
+		rec.meta.Error = runErr.Error()
+	}
+
+	var buf bytes.Buffer
+	if err := report.WriteJSON(&buf); err != nil {
+		return err
+	}
+	rec.files[bundleReport] = buf.Bytes()
+	rec.files[bundleInput] = rec.input.Bytes()
+
+	meta, err := json.MarshalIndent(rec.meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	rec.files[bundleMeta] = meta
+
+	data, err := writeBundle(rec.files)
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteFileAtomic(path, data, 0644)
+}
+
+// writeBundle packs files into a gzipped tarball, in name order so the same
+// files always give the same bundle.
+func writeBundle(files map[string][]byte) ([]byte, error) {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, name := range names {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(files[name]))}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(files[name]); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// readBundle unpacks the gzipped tarball at path.
+func readBundle(path string) (map[string][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundle %s: %w", path, err)
+	}
+	tr := tar.NewReader(gz)
+	files := map[string][]byte{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bundle %s: %w", path, err)
+		}
+		if !bundleFiles[hdr.Name] {
+			continue
+		}
+		data, err := io.ReadAll(io.LimitReader(tr, maxBundleFile+1))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bundle %s: %w", path, err)
+		}
+		if len(data) > maxBundleFile {
+			return nil, fmt.Errorf("failed to read bundle %s: %s is larger than %d MiB", path, hdr.Name, maxBundleFile>>20)
+		}
+		files[hdr.Name] = data
+	}
+	if _, ok := files[bundleMeta]; !ok {
+		return nil, fmt.Errorf("%s is not a record bundle", path)
+	}
+	return files, nil
+}
+
+// bundleDir returns where a tree recorded at path is recreated inside root. The
+// path comes from the bundle, so it must be absolute without leaving root through
+// ".." or a symlink recreated before it.
+func bundleDir(root, path string) (string, error) {
+	rel := strings.TrimLeft(path[len(filepath.VolumeName(path)):], `/\`)
+	if !filepath.IsAbs(path) || !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("invalid path %q in bundle", path)
+	}
+	dir := root
+	for _, name := range strings.Split(filepath.Clean(rel), string(filepath.Separator)) {
+		dir = filepath.Join(dir, name)
+		if fileutil.IsSymlink(dir) {
+			return "", fmt.Errorf("invalid path %q in bundle: %s is a symlink", path, dir)
+		}
+	}
+	return dir, nil
+}
+
+// replayConfig returns the config of a bundle without the options in replayIgnored.
+func replayConfig(data []byte) ([]byte, error) {
+	var config map[string]any
+	if err := toml.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	if options, ok := config["options"].(map[string]any); ok {
+		for _, key := range replayIgnored["options"] {
+			delete(options, key)
+		}
+	}
+	if exceptions, ok := config["exceptions"].(map[string]any); ok {
+		for _, m := range exceptions {
+			if table, ok := m.(map[string]any); ok {
+				for _, key := range replayIgnored["exceptions"] {
+					delete(table, key)
+				}
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(config); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// replayDiff is an entry whose outcome differs between the recorded run and its replay.
+type replayDiff struct {
+	linkPath string
+	recorded string
+	replayed string
+}
+
+// replayBundle recreates the run recorded in bundle inside dir, with the same config,
+// flags, and answers to prompts, and compares what it does with what the recorded
+// run did. Paths are compared as they were given, i.e. inside the run's root. The
+// bundle isn't trusted: nothing is written outside dir, only replayFlags are passed
+// on, and the options in replayIgnored are dropped from the config.
+func replayBundle(bundle, dir string) ([]replayDiff, error) {
+	if err := fileutil.CheckWritable("replay a bundle in", dir); err != nil {
+		return nil, err
+	}
+	files, err := readBundle(bundle)
+	if err != nil {
+		return nil, err
+	}
+	var meta recordMeta
+	if err := json.Unmarshal(files[bundleMeta], &meta); err != nil {
+		return nil, fmt.Errorf("failed to read bundle %s: %w", bundle, err)
+	}
+	var recorded Report
+	if err := json.Unmarshal(files[bundleReport], &recorded); err != nil {
+		return nil, fmt.Errorf("failed to read bundle %s: %w", bundle, err)
+	}
+
+	// The trees are recreated at the paths they had, inside a root of their own
+	root := filepath.Join(dir, "root")
+	trees := map[string]string{bundleSource: meta.TargetPath, bundleLinks: meta.LinkPath}
+	for _, name := range []string{bundleSource, bundleLinks} {
+		data, ok := files[name]
+		if !ok {
+			continue
+		}
+		path, err := bundleDir(root, trees[name])
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return nil, err
+		}
+		if err := ymlfs.FromYml(path, data); err != nil {
+			return nil, fmt.Errorf("failed to recreate %s: %w", trees[name], err)
+		}
+	}
+
+	// A config is always given, so one in the recreated source is never read as is
+	config, err := replayConfig(files[bundleConfig])
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundle %s: %w", bundle, err)
+	}
+	configPath := filepath.Join(dir, bundleConfig)
+	if err := os.WriteFile(configPath, config, 0644); err != nil {
+		return nil, err
+	}
+	args := []string{"link", "--json", "--root", root, "--config", configPath, meta.LinkPath, meta.TargetPath}
+	for _, arg := range meta.Args {
+		name, _, _ := strings.Cut(strings.TrimPrefix(arg, "--"), "=")
+		if strings.HasPrefix(arg, "--") && replayFlags[name] {
+			args = append(args, arg)
+		}
+	}
+
+	// The replay keeps its manifest to itself and answers prompts as the user did
+	stateDir := filepath.Join(dir, "state")
+	prevState, hadState := os.LookupEnv("XDG_STATE_HOME")
+	os.Setenv("XDG_STATE_HOME", stateDir)
+	defer func() {
+		if hadState {
+			os.Setenv("XDG_STATE_HOME", prevState)
+		} else {
+			os.Unsetenv("XDG_STATE_HOME")
+		}
+	}()
+	stringutil.SetInput(bytes.NewReader(files[bundleInput]))
+	defer stringutil.SetInput(os.Stdin)
+
+	var out bytes.Buffer
+	cmd := NewRootCmd()
+	cmd.SetOut(&out)
+	cmd.SetArgs(args)
+	if err := cmd.Execute(); err != nil {
+		return nil, fmt.Errorf("replay: %w", err)
+	}
+	var replayed Report
+	if err := json.Unmarshal(out.Bytes(), &replayed); err != nil {
+		return nil, fmt.Errorf("replay: failed to read its report: %w", err)
+	}
+
+	return compareRuns(recorded.Entries, meta.Root, replayed.Entries, root), nil
+}
+
+// compareRuns lists the link paths whose outcome differs between two runs made
+// inside roots recordedRoot and replayedRoot.
+func compareRuns(recorded []Entry, recordedRoot string, replayed []Entry, replayedRoot string) []replayDiff {
+	outcome := func(e Entry) string {
+		if e.Reason != "" {
+			return fmt.Sprintf("%s [%s]", e.Status, e.Reason)
+		}
+		return string(e.Status)
+	}
+	collect := func(entries []Entry, root string) map[string]string {
+		outcomes := make(map[string]string, len(entries))
+		for _, e := range entries {
+			outcomes[fileutil.StripRoot(root, e.LinkPath)] = outcome(e)
+		}
+		return outcomes
+	}
+	before := collect(recorded, recordedRoot)
+	after := collect(replayed, replayedRoot)
+
+	var diffs []replayDiff
+	for path, o := range before {
+		if after[path] != o {
+			diffs = append(diffs, replayDiff{linkPath: path, recorded: o, replayed: after[path]})
+		}
+	}
+	for path, o := range after {
+		if _, ok := before[path]; !ok {
+			diffs = append(diffs, replayDiff{linkPath: path, replayed: o})
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].linkPath < diffs[j].linkPath })
+	return diffs
+}
+
+func NewReplayCmd() *cobra.Command {
+	var dir string
+
+	cmd := &cobra.Command{
+		Use:   "replay bundle",
+		Short: "Rerun a link run recorded with --record and show where the outcome differs",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if dir == "" {
+				if err := fileutil.CheckWritable("replay a bundle in", os.TempDir()); err != nil {
+					return err
+				}
+				tmp, err := os.MkdirTemp("", "lnkit-replay-")
+				if err != nil {
+					return err
+				}
+				defer os.RemoveAll(tmp)
+				dir = tmp
+			}
+
+			diffs, err := replayBundle(args[0], dir)
+			if err != nil {
+				return err
+			}
+			w := cmd.OutOrStdout()
+			if len(diffs) == 0 {
+				fmt.Fprintln(w, "Replay matches the recorded run")
+				return nil
+			}
+
+			rows := make([][2]string, len(diffs))
+			for i, d := range diffs {
+				recorded, replayed := d.recorded, d.replayed
+				if recorded == "" {
+					recorded = "-"
+				}
+				if replayed == "" {
+					replayed = "-"
+				}
+				rows[i] = [2]string{d.linkPath, fmt.Sprintf("recorded %s, replayed %s", recorded, replayed)}
+			}
+			stringutil.FprintDotTable(w, rows)
+			return fmt.Errorf("%d entries differ from the recorded run", len(diffs))
+		},
+		Example: `
+			lnk link --rec --record bug.tar.gz --redact ~ ~/dotfiles
+			lnk replay bug.tar.gz
+			lnk replay --dir /tmp/bug bug.tar.gz
+		`,
+	}
+	cmd.Flags().StringVar(&dir, "dir", "", "Recreate the recorded trees here and keep them (default: a temp dir)")
+
+	return cmd
+}
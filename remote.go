@@ -0,0 +1,283 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"lnkit/fileutil"
+
+	"github.com/spf13/cobra"
+)
+
+// remoteTimeout is how long fetching a remote file may take.
+const remoteTimeout = 30 * time.Second
+
+// maxRemoteBytes is the largest remote file lnkit fetches; configs are far smaller.
+const maxRemoteBytes = 10 << 20
+
+var remoteClient = &http.Client{Timeout: remoteTimeout}
+
+// validateRemote checks the source_url and sha256 of an exception.
+func (m Mapping) validateRemote() error {
+	if m.SourceURL == "" {
+		if m.SHA256 != "" {
+			return errors.New("sha256 is only used with source_url")
+		}
+		return nil
+	}
+	u, err := url.Parse(m.SourceURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("source_url %q must be an http or https URL", m.SourceURL)
+	}
+	if sum, err := hex.DecodeString(m.SHA256); m.SHA256 != "" && (err != nil || len(sum) != sha256.Size) {
+		return fmt.Errorf("sha256 %q must be 64 hex digits", m.SHA256)
+	}
+	return nil
+}
+
+// remoteCacheDir returns where remote files are kept once fetched:
+// $XDG_CACHE_HOME/lnkit/remote, or ~/.cache/lnkit/remote.
+func remoteCacheDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(fileutil.XDGCacheHome(home), "lnkit", "remote"), nil
+}
+
+// remoteCachePath returns where the content of m's remote file with the given
+// SHA-256 is cached. Each version gets its own directory, named after its hash,
+// so a link keeps pointing at what it was pinned to.
+func remoteCachePath(cacheDir string, m Mapping, sum string) string {
+	name := "file"
+	if u, err := url.Parse(m.SourceURL); err == nil && path.Base(u.Path) != "/" && path.Base(u.Path) != "." {
+		name = path.Base(u.Path)
+	}
+	return filepath.Join(cacheDir, strings.ToLower(sum), name)
+}
+
+// fetchRemote downloads rawURL, returning its content and SHA-256.
+func fetchRemote(rawURL string) ([]byte, string, error) {
+	resp, err := remoteClient.Get(rawURL)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("fetching %s: %s", rawURL, resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxRemoteBytes+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("fetching %s: %w", rawURL, err)
+	}
+	if len(data) > maxRemoteBytes {
+		return nil, "", fmt.Errorf("%s is larger than %d MiB", rawURL, maxRemoteBytes>>20)
+	}
+	sum := sha256.Sum256(data)
+	return data, hex.EncodeToString(sum[:]), nil
+}
+
+// cacheRemote writes the content of m's remote file to the cache and returns its path.
+func cacheRemote(cacheDir string, m Mapping, data []byte, sum string) (string, error) {
+	cached := remoteCachePath(cacheDir, m, sum)
+	if err := fileutil.MkdirAll(filepath.Dir(cached), 0755); err != nil {
+		return "", err
+	}
+	return cached, fileutil.WriteFileAtomic(cached, data, 0644)
+}
+
+// cachedPinned returns the cached file of m's remote file if it's there and still
+// has the pinned SHA-256; a cache entry that was changed since doesn't count.
+func cachedPinned(cacheDir string, m Mapping) (string, bool) {
+	if m.SHA256 == "" {
+		return "", false
+	}
+	cached := remoteCachePath(cacheDir, m, m.SHA256)
+	if !fileutil.IsRegularFile(cached) {
+		return "", false
+	}
+	sum, err := fileutil.HashFile(cached)
+	if err != nil || !strings.EqualFold(hex.EncodeToString(sum), m.SHA256) {
+		return "", false
+	}
+	return cached, true
+}
+
+// fetchPinned makes sure the content m's remote file is pinned to is in the cache,
+// fetching it again if it isn't, and returns its path.
+func fetchPinned(cacheDir string, m Mapping) (string, error) {
+	if m.SHA256 == "" {
+		return "", fmt.Errorf("%s isn't pinned; add its sha256, see lnk update-remotes", m.SourceURL)
+	}
+	if cached, ok := cachedPinned(cacheDir, m); ok {
+		return cached, nil
+	}
+
+	data, sum, err := fetchRemote(m.SourceURL)
+	if err != nil {
+		return "", err
+	}
+	if !strings.EqualFold(sum, m.SHA256) {
+		return "", fmt.Errorf("%s has SHA-256 %s, not the pinned %s; check what changed upstream, then run lnk update-remotes", m.SourceURL, sum, m.SHA256)
+	}
+	return cacheRemote(cacheDir, m, data, sum)
+}
+
+// cacheRemotes returns the cached files of the remote exceptions a run links, mapped
+// to the exceptions' source paths. With fetch set, files missing from the cache are
+// fetched, and those that can't be are reported as failed; otherwise they are left
+// out, and reported as pending if report isn't nil, so a plan still shows them.
+// opts.exceptions must already be resolved.
+func cacheRemotes(report *Report, targetRoot string, opts linkOptions, fetch bool) (map[string]string, error) {
+	remotes := map[string]string{}
+	cacheDir, err := remoteCacheDir()
+	if err != nil {
+		return nil, err
+	}
+
+	for source, m := range opts.exceptions {
+		if m.SourceURL == "" || m.Target == "" {
+			continue
+		}
+		if opts.subdir != "" {
+			if inSubdir, _ := fileutil.IsChildPath(filepath.Join(targetRoot, source), filepath.Join(targetRoot, opts.subdir)); !inSubdir {
+				continue
+			}
+		}
+		if fileutil.PathExists(filepath.Join(targetRoot, source)) {
+			return nil, fmt.Errorf("%w: %s is both in %s and fetched from %s", errBadException, source, targetRoot, m.SourceURL)
+		}
+
+		if !fetch {
+			if cached, ok := cachedPinned(cacheDir, m); ok {
+				remotes[cached] = source
+			} else if report != nil {
+				report.add(m.Target, m.SourceURL, LMissing, StatusPending, ReasonFetchPending, "would fetch "+m.SourceURL)
+			}
+			continue
+		}
+		cached, err := fetchPinned(cacheDir, m)
+		if err != nil {
+			printEntry("Failed [%s]: %s: %v", ReasonFetchFailed, m.Target, err)
+			report.add(m.Target, m.SourceURL, LMissing, StatusFailed, ReasonFetchFailed, err.Error())
+			continue
+		}
+		remotes[cached] = source
+	}
+	return remotes, nil
+}
+
+// walkRemotes calls handlerFunc for each of the cached remote files from
+// cacheRemotes, as walkSourceRec does for the files in the source.
+func walkRemotes(remotes map[string]string, opts linkOptions, handlerFunc handler) error {
+	cached := make([]string, 0, len(remotes))
+	for path := range remotes {
+		cached = append(cached, path)
+	}
+	sort.Strings(cached)
+
+	for _, path := range cached {
+		linkPath := opts.exceptions[remotes[path]].Target
+		start := time.Now()
+
+		// Links to other versions in the cache count as mislinked internally, so
+		// updating a pin relinks without asking
+		cacheDir := filepath.Dir(filepath.Dir(path))
+		linkState, err := determineTargetState(linkPath, path, cacheDir, opts)
+		if err != nil {
+			return err
+		}
+		if _, err := handlerFunc(linkPath, path, linkState, time.Since(start)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func NewUpdateRemotesCmd() *cobra.Command {
+	var configPath string
+
+	cmd := &cobra.Command{
+		Use:   "update-remotes target_path",
+		Short: "Fetch the remote files exceptions link to and update their pinned SHA-256s in the config",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			targetPath, err := fileutil.ExpandPath(args[0])
+			if err != nil {
+				return fmt.Errorf("failed to expand target path: %w", err)
+			}
+			cfg, err := loadConfig(configPath, targetPath)
+			if err != nil {
+				return err
+			}
+			if configPath == "" {
+				configPath = filepath.Join(targetPath, configFile)
+			}
+			cacheDir, err := remoteCacheDir()
+			if err != nil {
+				return err
+			}
+
+			sources := make([]string, 0, len(cfg.Links))
+			for source, m := range cfg.Links {
+				if m.SourceURL != "" {
+					sources = append(sources, source)
+				}
+			}
+			sort.Strings(sources)
+			if len(sources) == 0 {
+				fmt.Fprintln(cmd.OutOrStdout(), "No exceptions with a source_url")
+				return nil
+			}
+
+			var pins []string // Old and new pins, for strings.NewReplacer
+			for _, source := range sources {
+				m := cfg.Links[source]
+				data, sum, err := fetchRemote(m.SourceURL)
+				if err != nil {
+					return err
+				}
+				if _, err := cacheRemote(cacheDir, m, data, sum); err != nil {
+					return err
+				}
+				switch {
+				case strings.EqualFold(sum, m.SHA256):
+					fmt.Fprintf(cmd.OutOrStdout(), "%s: up to date\n", source)
+				case m.SHA256 == "":
+					fmt.Fprintf(cmd.OutOrStdout(), "%s: not pinned, add sha256 = %q\n", source, sum)
+				default:
+					fmt.Fprintf(cmd.OutOrStdout(), "%s: %s → %s\n", source, m.SHA256, sum)
+					pins = append(pins, m.SHA256, sum)
+				}
+			}
+			if len(pins) == 0 {
+				return nil
+			}
+
+			data, err := os.ReadFile(configPath)
+			if err != nil {
+				return err
+			}
+			updated := strings.NewReplacer(pins...).Replace(string(data))
+			return fileutil.WriteFileAtomic(configPath, []byte(updated), 0644)
+		},
+		Example: `
+			lnk update-remotes ~/dotfiles
+		`,
+	}
+	cmd.Flags().StringVar(&configPath, "config", "", "Config file (default: lnkit.toml in target_path)")
+
+	return cmd
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	api "lnkit/api/v1"
+	"lnkit/fileutil"
+	"lnkit/stringutil"
+)
+
+// entryOut is where lines about single entries go as a run makes progress, e.g.
+// "Skipped [...]: path". They are kept off stdout so they don't mix with results
+// such as a JSON report; the root command points it at its stderr.
+var entryOut io.Writer = os.Stderr
+
+// printEntry writes a line about a single entry to entryOut.
+func printEntry(format string, args ...any) {
+	fmt.Fprintf(entryOut, format+"\n", args...)
+}
+
+// The types of a report are lnkit's stable API, so they are defined in api/v1.
+type (
+	EntryStatus = api.EntryStatus
+	ReasonCode  = api.ReasonCode
+	Entry       = api.Entry
+)
+
+const (
+	StatusLinked    = api.StatusLinked
+	StatusUnchanged = api.StatusUnchanged
+	StatusSkipped   = api.StatusSkipped
+	StatusFailed    = api.StatusFailed
+	StatusUnlinked  = api.StatusUnlinked
+	StatusRenamed   = api.StatusRenamed
+	StatusPending   = api.StatusPending
+	StatusConflict  = api.StatusConflict
+)
+
+const (
+	ReasonIgnoredPattern    = api.ReasonIgnoredPattern
+	ReasonConflictModified  = api.ReasonConflictModified
+	ReasonConflictMislinked = api.ReasonConflictMislinked
+	ReasonPermissionDenied  = api.ReasonPermissionDenied
+	ReasonLinkFailed        = api.ReasonLinkFailed
+	ReasonUnmanagedLink     = api.ReasonUnmanagedLink
+	ReasonReadOnly          = api.ReasonReadOnly
+	ReasonNoTarget          = api.ReasonNoTarget
+	ReasonShadowed          = api.ReasonShadowed
+	ReasonImmutable         = api.ReasonImmutable
+	ReasonCollision         = api.ReasonCollision
+	ReasonOutsideRoot       = api.ReasonOutsideRoot
+	ReasonFetchFailed       = api.ReasonFetchFailed
+	ReasonFetchPending      = api.ReasonFetchPending
+)
+
+// conflictReason returns the reason code for skipping a conflict in the given state.
+func conflictReason(state LState) ReasonCode {
+	if state == LMislinkedExternal {
+		return ReasonConflictMislinked
+	}
+	return ReasonConflictModified
+}
+
+// errorReason classifies an error from a filesystem operation.
+func errorReason(err error) ReasonCode {
+	if errors.Is(err, fileutil.ErrImmutable) {
+		return ReasonImmutable
+	}
+	if errors.Is(err, fs.ErrPermission) {
+		return ReasonPermissionDenied
+	}
+	if errors.Is(err, fileutil.ErrReadOnly) {
+		return ReasonReadOnly
+	}
+	return ReasonLinkFailed
+}
+
+// Report collects the entries processed during a run.
+type Report struct {
+	Entries   []Entry  `json:"entries"`
+	FollowUps []string `json:"follow_ups,omitempty"` // Things the user may need to do next
+}
+
+// addFollowUps queues the follow-ups configured for source paths under targetRoot
+// that were linked during the run, each once, in the order of their source paths.
+func (r *Report) addFollowUps(targetRoot string, followUps map[string]string) {
+	sources := make([]string, 0, len(followUps))
+	for source := range followUps {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	for _, source := range sources {
+		prefix := filepath.Clean(source)
+		for _, e := range r.Entries {
+			if e.Status != StatusLinked && e.Status != StatusRenamed {
+				continue
+			}
+			rel, err := filepath.Rel(targetRoot, e.TargetPath)
+			if err != nil || (rel != prefix && !strings.HasPrefix(rel, prefix+string(filepath.Separator))) {
+				continue
+			}
+			if msg := followUps[source]; !slices.Contains(r.FollowUps, msg) {
+				r.FollowUps = append(r.FollowUps, msg)
+			}
+			break
+		}
+	}
+}
+
+// PrintFollowUps prints the queued follow-ups, if there are any.
+func (r *Report) PrintFollowUps(w io.Writer) {
+	if len(r.FollowUps) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "Next steps:")
+	for _, msg := range r.FollowUps {
+		fmt.Fprintf(w, "  - %s\n", msg)
+	}
+}
+
+func (r *Report) add(linkPath, targetPath string, state LState, status EntryStatus, reason ReasonCode, message string) {
+	r.Entries = append(r.Entries, Entry{
+		LinkPath:   linkPath,
+		TargetPath: targetPath,
+		State:      state.String(),
+		Status:     status,
+		Reason:     reason,
+		Message:    message,
+	})
+}
+
+// timeEntries records on the entries added since the first from, all for one path,
+// how long its state took to detect and how long it has taken to act on since start,
+// less any time waiting on prompts since waited was taken.
+func (r *Report) timeEntries(from int, detect time.Duration, start time.Time, waited time.Duration) {
+	apply := time.Since(start) - (stringutil.Waited() - waited)
+	for i := from; i < len(r.Entries); i++ {
+		r.Entries[i].Detect, r.Entries[i].Apply = detect, apply
+	}
+}
+
+// planEntry records what linking an entry in the given state would do, without doing it.
+func planEntry(report *Report, linkPath, targetPath string, state LState) {
+	switch state {
+	case LAlreadyLinked:
+		report.add(linkPath, targetPath, state, StatusUnchanged, "", "")
+	case LMislinkedExternal, LExistsModified:
+		report.add(linkPath, targetPath, state, StatusConflict, conflictReason(state), "")
+	default:
+		report.add(linkPath, targetPath, state, StatusPending, "", "")
+	}
+}
+
+// Summary counts the entries by status, e.g. "2 linked, 1 conflict". Ignored entries aren't counted.
+func (r *Report) Summary() string {
+	counts := map[EntryStatus]int{}
+	var statuses []EntryStatus
+	for _, e := range r.Entries {
+		if e.Reason == ReasonIgnoredPattern {
+			continue
+		}
+		if counts[e.Status] == 0 {
+			statuses = append(statuses, e.Status)
+		}
+		counts[e.Status]++
+	}
+
+	if len(statuses) == 0 {
+		return "nothing to do"
+	}
+	parts := make([]string, len(statuses))
+	for i, status := range statuses {
+		parts[i] = fmt.Sprintf("%d %s", counts[status], status)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// API returns the report in the stable form of api/v1.
+func (r *Report) API() api.Report {
+	return api.NewReport(r.Entries, r.FollowUps)
+}
+
+// WriteJSON writes the report as indented JSON, in the form of api/v1.
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r.API())
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"lnkit/fileutil"
+	"lnkit/manifest"
+	"lnkit/stringutil"
+
+	"github.com/spf13/cobra"
+)
+
+const reposFile = "repos.json"
+
+// reposPath returns where the list of registered source repos is kept.
+func reposPath() (string, error) {
+	dir, err := manifest.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, reposFile), nil
+}
+
+// loadRepos returns the registered source repos, in the order they were added.
+func loadRepos() ([]string, error) {
+	path, err := reposPath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var repos []string
+	if err := json.Unmarshal(data, &repos); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return repos, nil
+}
+
+func saveRepos(repos []string) error {
+	path, err := reposPath()
+	if err != nil {
+		return err
+	}
+	if err := fileutil.CheckWritable("save repos to", path); err != nil {
+		return err
+	}
+	if err := fileutil.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(repos, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteFileAtomic(path, data, 0644)
+}
+
+// repoLinkRoot returns where a repo's files are linked to: its config's target_dir,
+// resolved against the repo if relative.
+func repoLinkRoot(repo string, cfg Config) (string, error) {
+	dir := cfg.Options.TargetDir
+	if !filepath.IsAbs(dir) && !strings.HasPrefix(dir, "~") && !strings.HasPrefix(dir, "$") {
+		dir = filepath.Join(repo, dir)
+	}
+	return fileutil.ExpandPath(dir)
+}
+
+// repoReport is the result of running over a single registered repo.
+type repoReport struct {
+	Repo     string  `json:"repo"`
+	LinkRoot string  `json:"link_root"`
+	Report   *Report `json:"report,omitempty"`
+	Error    string  `json:"error,omitempty"`
+
+	Packages []pkgHint `json:"packages,omitempty"` // With dryRun, exceptions whose package disagrees with their link
+}
+
+// forEachRepo links (or with dryRun, checks) every registered repo into its target_dir,
+// recursively, and returns a report per repo. A failing repo doesn't stop the others.
+func forEachRepo(cmd *cobra.Command, dryRun bool) ([]repoReport, error) {
+	repos, err := loadRepos()
+	if err != nil {
+		return nil, err
+	}
+	if len(repos) == 0 {
+		return nil, fmt.Errorf("no repos registered, add one with: lnk repo add <dir>")
+	}
+
+	results := make([]repoReport, 0, len(repos))
+	for _, repo := range repos {
+		result := repoReport{Repo: repo}
+
+		opts := linkOptions{recursive: true, dryRun: dryRun}
+		cfg, err := loadOptions(cmd, "", repo, &opts)
+		if err == nil {
+			result.LinkRoot, err = repoLinkRoot(repo, cfg)
+		}
+		if err == nil {
+			result.Report, err = createSymlinks(result.LinkRoot, repo, opts)
+		}
+		if err == nil && !dryRun {
+			err = recordLinks(result.Report, result.LinkRoot, repo, cfg.Options.ManagedMarker)
+		}
+		if err == nil && dryRun {
+			result.Packages, err = checkPackages(result.LinkRoot, repo, opts)
+		}
+		if err != nil {
+			sugar.Errorf("Repo %s: %v", repo, err)
+			result.Error = err.Error()
+		}
+
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// printRepoReports prints one summary line per repo.
+func printRepoReports(cmd *cobra.Command, results []repoReport, jsonOut bool) error {
+	if jsonOut {
+		enc := json.NewEncoder(cmd.OutOrStdout())
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	}
+
+	rows := make([][2]string, len(results))
+	for i, r := range results {
+		switch {
+		case r.Error != "":
+			rows[i] = [2]string{r.Repo, "error: " + r.Error}
+		default:
+			rows[i] = [2]string{r.Repo, r.Report.Summary()}
+		}
+	}
+	for _, r := range results {
+		for _, h := range r.Packages {
+			rows = append(rows, [2]string{filepath.Join(r.Repo, h.Source), h.String()})
+		}
+	}
+	stringutil.PrintDotTable(rows)
+	return nil
+}
+
+func NewRepoCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "repo",
+		Short: "Manage several source repos at once",
+	}
+
+	add := &cobra.Command{
+		Use:   "add dir...",
+		Short: "Register source repos",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repos, err := loadRepos()
+			if err != nil {
+				return err
+			}
+			for _, arg := range args {
+				repo, err := fileutil.ExpandPath(arg)
+				if err != nil {
+					return fmt.Errorf("failed to expand repo path: %w", err)
+				}
+				if !fileutil.IsDir(repo) {
+					return fmt.Errorf("not a directory: %s", repo)
+				}
+				if !slices.Contains(repos, repo) {
+					repos = append(repos, repo)
+				}
+			}
+			return saveRepos(repos)
+		},
+		Example: `
+			lnk repo add ~/dotfiles ~/work-dotfiles
+		`,
+	}
+
+	remove := &cobra.Command{
+		Use:   "remove dir...",
+		Short: "Unregister source repos (their links are left alone)",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repos, err := loadRepos()
+			if err != nil {
+				return err
+			}
+			for _, arg := range args {
+				repo, err := fileutil.ExpandPath(arg)
+				if err != nil {
+					return fmt.Errorf("failed to expand repo path: %w", err)
+				}
+				repos = slices.DeleteFunc(repos, func(r string) bool { return r == repo })
+			}
+			return saveRepos(repos)
+		},
+	}
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List registered source repos",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repos, err := loadRepos()
+			if err != nil {
+				return err
+			}
+			for _, repo := range repos {
+				fmt.Fprintln(cmd.OutOrStdout(), repo)
+			}
+			return nil
+		},
+	}
+
+	var jsonOut bool
+
+	link := &cobra.Command{
+		Use:   "link",
+		Short: "Recursively link every registered repo into its target_dir",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			results, err := forEachRepo(cmd, false)
+			if err != nil {
+				return err
+			}
+			return printRepoReports(cmd, results, jsonOut)
+		},
+	}
+
+	status := &cobra.Command{
+		Use:   "status",
+		Short: "Show what linking every registered repo would do, without changing anything",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			results, err := forEachRepo(cmd, true)
+			if err != nil {
+				return err
+			}
+			return printRepoReports(cmd, results, jsonOut)
+		},
+	}
+
+	for _, c := range []*cobra.Command{link, status} {
+		c.Flags().BoolVar(&jsonOut, "json", false, "Print a JSON report per repo")
+	}
+
+	cmd.AddCommand(add, remove, list, link, status)
+	return cmd
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"lnkit/fileutil"
+	"lnkit/manifest"
+	"lnkit/stringutil"
+
+	"github.com/spf13/cobra"
+)
+
+// reviewFile is where the choices of a review that wasn't applied are kept, next
+// to the manifest of the link/target pair they are about, until they are.
+const reviewFile = "review.json"
+
+// reviewChoices maps the review prompt's choices that resolve a conflict.
+var reviewChoices = map[string]Resolution{
+	"s": ResolveSkip,
+	"b": ResolveBackup,
+	"o": ResolveOverwrite,
+}
+
+var resolutionNames = map[Resolution]string{
+	ResolveSkip:      "skip",
+	ResolveBackup:    "backup",
+	ResolveOverwrite: "overwrite",
+}
+
+// reviewPath returns where the pending review of links from linkRoot to targetRoot is kept.
+func reviewPath(linkRoot, targetRoot string) (string, error) {
+	path, err := manifest.PathFor(linkRoot, targetRoot)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(path), reviewFile), nil
+}
+
+// loadReview returns the resolutions kept at path by link path, if any.
+func loadReview(path string) (map[string]Resolution, error) {
+	resolutions := map[string]Resolution{}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return resolutions, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var names map[string]string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return nil, fmt.Errorf("failed to parse pending review %s: %w", path, err)
+	}
+	for linkPath, name := range names {
+		if resolution, ok := resolveNames[name]; ok {
+			resolutions[linkPath] = resolution
+		}
+	}
+	return resolutions, nil
+}
+
+// saveReview keeps resolutions at path for the next review.
+func saveReview(path string, resolutions map[string]Resolution) error {
+	names := make(map[string]string, len(resolutions))
+	for linkPath, resolution := range resolutions {
+		names[linkPath] = resolutionNames[resolution]
+	}
+	data, err := json.MarshalIndent(names, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := fileutil.CheckWritable("save pending review", path); err != nil {
+		return err
+	}
+	if err := fileutil.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	return fileutil.WriteFileAtomic(path, data, 0644)
+}
+
+// plannedConflicts returns the conflicts found by a dry run.
+func plannedConflicts(plan *Report, hexdiff bool) []conflict {
+	var conflicts []conflict
+	for _, e := range plan.Entries {
+		if e.Status != StatusConflict {
+			continue
+		}
+		state := LExistsModified
+		if e.Reason == ReasonConflictMislinked {
+			state = LMislinkedExternal
+		}
+		conflicts = append(conflicts, conflict{linkPath: e.LinkPath, targetPath: e.TargetPath, state: state, hexdiff: hexdiff})
+	}
+	return conflicts
+}
+
+// undecided is shown for conflicts the user hasn't chosen a resolution for yet.
+// They aren't in the resolutions, so applying skips them.
+const undecided = "undecided"
+
+// choiceName returns the name of the resolution chosen for linkPath, or undecided.
+func choiceName(resolutions map[string]Resolution, linkPath string) string {
+	if resolution, ok := resolutions[linkPath]; ok {
+		return resolutionNames[resolution]
+	}
+	return undecided
+}
+
+// reviewConflicts steps through conflicts one at a time, letting the user move
+// between them, preview their diffs, and choose how to resolve each, without
+// changing anything, and writes where it is to w. The choices are recorded in
+// resolutions by link path. It returns false if the user quit instead of applying
+// them; no answer at all, as when stdin is closed, quits too.
+func reviewConflicts(w io.Writer, conflicts []conflict, resolutions map[string]Resolution) bool {
+	for i := 0; ; {
+		c := conflicts[i]
+		fmt.Fprintf(w, "[%d/%d] %s (%s): %s\n", i+1, len(conflicts), c.linkPath, c.state, choiceName(resolutions, c.linkPath))
+
+		choice := stringutil.AskForChoice("Next, prev, diff, skip, backup, overwrite, apply all, or quit?", []string{"n", "p", "d", "s", "b", "o", "a", "q"}, "q")
+		switch choice {
+		case "n":
+			i = min(i+1, len(conflicts)-1)
+		case "p":
+			i = max(i-1, 0)
+		case "d":
+			previewConflict(c)
+		case "s", "b", "o":
+			resolutions[c.linkPath] = reviewChoices[choice]
+			i = min(i+1, len(conflicts)-1)
+		case "a":
+			return true
+		case "q":
+			return false
+		}
+	}
+}
+
+func NewReviewCmd() *cobra.Command {
+	var opts linkOptions
+	var configPath string
+
+	cmd := &cobra.Command{
+		Use:   "review link_path target_path",
+		Short: "Step through every conflict, choosing how to resolve each, then link everything at once",
+		Long: `Plan linking target_path into link_path, then step through every conflict: move
+between them, preview their diffs, and choose to skip, back up, or overwrite each.
+Nothing is changed until you apply. Quitting changes nothing but keeps your choices
+as a pending review, which the next review of the same paths picks up. Conflicts
+left undecided are skipped. Without conflicts, the plan is shown and applied once
+you confirm it.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			linkPath, err := fileutil.ExpandPath(args[0])
+			if err != nil {
+				return fmt.Errorf("failed to expand link path: %w", err)
+			}
+			targetPath, err := fileutil.ExpandPath(args[1])
+			if err != nil {
+				return fmt.Errorf("failed to expand target path: %w", err)
+			}
+
+			cfg, err := loadOptions(cmd, configPath, targetPath, &opts)
+			if err != nil {
+				return err
+			}
+			plan, err := planLinks(linkPath, targetPath, cfg, opts)
+			if err != nil {
+				return err
+			}
+
+			pendingPath, err := reviewPath(linkPath, targetPath)
+			if err != nil {
+				return err
+			}
+			opts.resolutions, err = loadReview(pendingPath)
+			if err != nil {
+				return err
+			}
+			w := cmd.OutOrStdout()
+			if conflicts := plannedConflicts(plan, opts.hexdiff); len(conflicts) > 0 {
+				if !reviewConflicts(w, conflicts, opts.resolutions) {
+					if err := saveReview(pendingPath, opts.resolutions); err != nil {
+						return fmt.Errorf("failed to save pending review: %w", err)
+					}
+					fmt.Fprintln(w, "Nothing was changed; your choices are kept for the next review")
+					return nil
+				}
+				left := 0
+				for _, c := range conflicts {
+					if choiceName(opts.resolutions, c.linkPath) == undecided {
+						left++
+					}
+				}
+				if left > 0 {
+					fmt.Fprintf(w, "Skipping %d undecided conflicts\n", left)
+				}
+			} else {
+				if !hasChanges(plan) {
+					fmt.Fprintln(w, "Nothing to link")
+					return nil
+				}
+				fmt.Fprintln(w, "No conflicts to review")
+				if err := printPlan(w, plan, false); err != nil {
+					return err
+				}
+				if !stringutil.AskForConfirmation("Apply this plan?") {
+					fmt.Fprintln(w, "Nothing was changed")
+					return nil
+				}
+			}
+
+			report, err := applyLinks(linkPath, targetPath, cfg, opts)
+			if err != nil {
+				return err
+			}
+			if err := fileutil.RemoveAll(pendingPath); err != nil {
+				sugar.Warnf("Failed to remove pending review %s: %v", pendingPath, err)
+			}
+			fmt.Fprintf(w, "Applied: %s\n", report.Summary())
+			return nil
+		},
+		Example: `
+			lnk review --rec ~ ~/dotfiles
+		`,
+	}
+	cmd.Flags().BoolVar(&opts.recursive, "rec", false, "Recursively process nested directories")
+	cmd.Flags().BoolVar(&opts.fold, "fold", false, "Link whole directories where applicable")
+	cmd.Flags().BoolVar(&opts.createDirs, "create-dirs", true, "Create dirs")
+	cmd.Flags().BoolVar(&opts.hexdiff, "hexdiff", false, "Preview conflicting binary files byte by byte instead of by size and hash")
+	cmd.Flags().StringVar(&configPath, "config", "", "Config file (default: lnkit.toml in target_path)")
+
+	return cmd
+}
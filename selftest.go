@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"lnkit/fileutil"
+	"lnkit/manifest"
+	"lnkit/stringutil"
+	"lnkit/ymlfs"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// checkRoundTrip checks that linking a generated source tree into a generated,
+// already populated link directory and then unlinking it through the manifest
+// leaves the link directory exactly as it was, down to file contents.
+func checkRoundTrip(seed int64, files, depth int) error {
+	dir, err := os.MkdirTemp("", "lnkit-selftest-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	linkRoot := filepath.Join(dir, "home")
+	targetRoot := filepath.Join(dir, "dotfiles")
+
+	source := ymlfs.Random(seed, files, depth)
+	existing := ymlfs.Random(seed+1, files/2, depth)
+	dropCollisions(existing, source)
+
+	for root, tree := range map[string]map[string]interface{}{linkRoot: existing, targetRoot: source} {
+		data, err := yaml.Marshal(tree)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(root, 0755); err != nil {
+			return err
+		}
+		if err := ymlfs.FromYml(root, data); err != nil {
+			return fmt.Errorf("failed to generate fixture: %w", err)
+		}
+	}
+
+	before, err := ymlfs.ToYml(linkRoot)
+	if err != nil {
+		return err
+	}
+
+	m, err := manifest.Load(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		return err
+	}
+
+	opts := linkOptions{recursive: true, createDirs: true, linkStyle: LinkAbsolute}
+	report, err := createSymlinks(linkRoot, targetRoot, opts)
+	if err != nil {
+		return fmt.Errorf("link: %w", err)
+	}
+	for _, e := range report.Entries {
+		if e.Status != StatusLinked {
+			return fmt.Errorf("link: expected %s to be linked, got %s %s", e.LinkPath, e.Status, e.Reason)
+		}
+	}
+	addToManifest(m, report, MarkerManifest, targetHashes(report))
+
+	if _, err := removeSymlinks(linkRoot, targetRoot, opts, false, m); err != nil {
+		return fmt.Errorf("unlink: %w", err)
+	}
+
+	if _, err := ymlfs.AssertStructure(linkRoot, string(before)); err != nil {
+		return fmt.Errorf("link then unlink didn't restore %s: %w", linkRoot, err)
+	}
+	return nil
+}
+
+// dropCollisions removes entries from existing that would conflict with source,
+// i.e. anything sharing a name with a source entry unless both are directories.
+func dropCollisions(existing, source map[string]interface{}) {
+	for name, s := range source {
+		e, ok := existing[name]
+		if !ok {
+			continue
+		}
+		sDir, sIsDir := s.(map[string]interface{})
+		eDir, eIsDir := e.(map[string]interface{})
+		if sIsDir && eIsDir && sDir["type"] == nil && eDir["type"] == nil {
+			dropCollisions(eDir, sDir)
+			continue
+		}
+		delete(existing, name)
+	}
+}
+
+func NewSelftestCmd() *cobra.Command {
+	var runs, files, depth int
+	var seed int64
+
+	cmd := &cobra.Command{
+		Use:   "selftest",
+		Short: "Check that linking then unlinking restores generated trees exactly on this platform",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			quietLogs("Error")
+			if err := fileutil.CheckWritable("generate test trees in", os.TempDir()); err != nil {
+				return err
+			}
+
+			rows := make([][2]string, runs)
+			failed := 0
+			for i := range runs {
+				err := checkRoundTrip(seed+int64(i), files, depth)
+				rows[i] = [2]string{fmt.Sprintf("seed %d", seed+int64(i)), "ok"}
+				if err != nil {
+					rows[i][1] = "FAILED"
+					sugar.Errorf("Seed %d: %v", seed+int64(i), err)
+					failed++
+				}
+			}
+			stringutil.PrintDotTable(rows)
+
+			if failed > 0 {
+				return fmt.Errorf("%d of %d round trips failed", failed, runs)
+			}
+			return nil
+		},
+		Example: `
+			lnk selftest
+			lnk selftest --runs 100 --files 500 --seed 42
+		`,
+	}
+	cmd.Flags().IntVar(&runs, "runs", 10, "Number of generated trees to check")
+	cmd.Flags().IntVar(&files, "files", 100, "Number of files in each generated tree")
+	cmd.Flags().IntVar(&depth, "depth", 4, "Maximum directory depth of each generated tree")
+	cmd.Flags().Int64Var(&seed, "seed", 1, "Seed of the first tree; each run uses the next one")
+
+	return cmd
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"lnkit/fileutil"
+	"lnkit/manifest"
+)
+
+// selinuxEnabled reports whether SELinux is enabled, i.e. whether files have
+// contexts that confined services depend on (overridable in tests). AppArmor
+// confines by path, so links need nothing for it.
+var selinuxEnabled = func() bool {
+	return fileutil.PathExists("/sys/fs/selinux/enforce")
+}
+
+// restorecon is the tool that sets the default SELinux context of files.
+const restorecon = "restorecon"
+
+// restoreContexts gives paths the SELinux contexts the policy expects for them, so
+// links and directories made in labeled system paths like /etc don't get the
+// context of wherever lnk ran from.
+func restoreContexts(paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	out, err := exec.Command(restorecon, append([]string{"-F", "--"}, paths...)...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s failed: %w: %s", restorecon, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// createdPaths returns the links and directories created during a run.
+func createdPaths(report *Report) []string {
+	var paths []string
+	for _, e := range report.Entries {
+		if e.Status != StatusLinked && e.Status != StatusRenamed {
+			continue
+		}
+		paths = append(paths, e.CreatedDirs...)
+		paths = append(paths, e.LinkPath)
+	}
+	return paths
+}
+
+// contextMismatches returns, for each managed link and directory in m whose SELinux
+// context isn't the one the policy expects, what restorecon would change, sorted.
+func contextMismatches(m *manifest.Manifest) ([]string, error) {
+	var paths []string
+	for linkPath := range m.Links {
+		if fileutil.PathExists(linkPath) || fileutil.IsSymlink(linkPath) {
+			paths = append(paths, linkPath)
+		}
+	}
+	for _, dir := range m.Dirs {
+		if fileutil.IsDir(dir) {
+			paths = append(paths, dir)
+		}
+	}
+	if len(paths) == 0 {
+		return nil, nil
+	}
+
+	// -n changes nothing, -v prints what would be relabeled
+	out, err := exec.Command(restorecon, append([]string{"-n", "-v", "-F", "--"}, paths...)...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %w", restorecon, err)
+	}
+	var mismatches []string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			mismatches = append(mismatches, line)
+		}
+	}
+	sort.Strings(mismatches)
+	return mismatches, scanner.Err()
+}
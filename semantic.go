@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// structuredFormat returns the config format of path judging by its extension:
+// "json", "toml", or "yaml", or "" if it isn't one lnkit understands.
+func structuredFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return "json"
+	case ".toml":
+		return "toml"
+	case ".yaml", ".yml":
+		return "yaml"
+	}
+	return ""
+}
+
+// parseStructured decodes the file at path in the given format.
+func parseStructured(path, format string) (any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var v any
+	switch format {
+	case "json":
+		err = json.Unmarshal(data, &v)
+	case "toml":
+		var m map[string]any
+		_, err = toml.Decode(string(data), &m)
+		v = m
+	case "yaml":
+		err = yaml.Unmarshal(data, &v)
+	default:
+		return nil, fmt.Errorf("unsupported format %q", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s as %s: %w", path, format, err)
+	}
+	return v, nil
+}
+
+// semanticDiff lists the key-level differences between two decoded documents, one
+// line per added (+), removed (-), or changed (~) key, sorted by key path. Key order
+// and formatting don't count as differences.
+func semanticDiff(old, new any) []string {
+	var lines []string
+	diffValues(&lines, "", old, new)
+	return lines
+}
+
+func diffValues(lines *[]string, path string, old, new any) {
+	oldMap, oldIsMap := asMap(old)
+	newMap, newIsMap := asMap(new)
+	if oldIsMap && newIsMap {
+		keys := map[string]bool{}
+		for k := range oldMap {
+			keys[k] = true
+		}
+		for k := range newMap {
+			keys[k] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+
+		for _, k := range sorted {
+			child := k
+			if path != "" {
+				child = path + "." + k
+			}
+			o, inOld := oldMap[k]
+			n, inNew := newMap[k]
+			switch {
+			case !inOld:
+				*lines = append(*lines, fmt.Sprintf("+ %s = %s", child, formatValue(n)))
+			case !inNew:
+				*lines = append(*lines, fmt.Sprintf("- %s = %s", child, formatValue(o)))
+			default:
+				diffValues(lines, child, o, n)
+			}
+		}
+		return
+	}
+
+	oldList, oldIsList := old.([]any)
+	newList, newIsList := new.([]any)
+	if oldIsList && newIsList && len(oldList) == len(newList) {
+		for i := range oldList {
+			diffValues(lines, fmt.Sprintf("%s[%d]", path, i), oldList[i], newList[i])
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(old, new) {
+		*lines = append(*lines, fmt.Sprintf("~ %s: %s → %s", path, formatValue(old), formatValue(new)))
+	}
+}
+
+// asMap returns v as a map with string keys, whichever decoder produced it.
+func asMap(v any) (map[string]any, bool) {
+	switch m := v.(type) {
+	case map[string]any:
+		return m, true
+	case map[any]any:
+		converted := make(map[string]any, len(m))
+		for k, v := range m {
+			converted[fmt.Sprint(k)] = v
+		}
+		return converted, true
+	}
+	return nil, false
+}
+
+func formatValue(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
+
+// PreviewSemanticDiff writes the key-level differences between two structured
+// config files to w, if they are in a format lnkit understands. It returns false
+// if they aren't, or can't be parsed.
+func PreviewSemanticDiff(w io.Writer, source, target string) bool {
+	format := structuredFormat(target)
+	if format == "" {
+		return false
+	}
+	old, err := parseStructured(source, format)
+	if err != nil {
+		sugar.Debugf("No semantic diff: %v", err)
+		return false
+	}
+	new, err := parseStructured(target, format)
+	if err != nil {
+		sugar.Debugf("No semantic diff: %v", err)
+		return false
+	}
+
+	lines := semanticDiff(old, new)
+	if len(lines) == 0 {
+		fmt.Fprintf(w, "No semantic differences in %s (only formatting or key order)\n", format)
+		return true
+	}
+	fmt.Fprintf(w, "Semantic diff (%s):\n", format)
+	for _, line := range lines {
+		fmt.Fprintln(w, "  "+line)
+	}
+	return true
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"time"
+
+	"lnkit/fileutil"
+)
+
+// slowReportDefault is how many paths --slow-report lists if not given a number.
+const slowReportDefault = 10
+
+// largeFileBytes is the size from which a file is called out as slow to hash.
+const largeFileBytes = 16 << 20
+
+// slowHint suggests what to do about a path that was slow to process.
+func slowHint(e Entry) string {
+	if e.Apply > e.Detect {
+		return "slow to change, e.g. on a network filesystem"
+	}
+	if info, err := os.Stat(e.TargetPath); err == nil && info.Mode().IsRegular() && info.Size() >= largeFileBytes {
+		return fmt.Sprintf("%d MiB hashed to compare it with what is at the link; ignore it, or link its directory whole with --fold", info.Size()>>20)
+	}
+	if fileutil.IsDir(e.TargetPath) {
+		return "slow to read, e.g. on a network filesystem; ignore it, or link it whole with --fold"
+	}
+	return "slow to compare with what is at the link, e.g. on a network filesystem; consider ignoring it"
+}
+
+// writeSlowReport writes the n paths that took the longest to detect and act on,
+// with a hint for each.
+func writeSlowReport(w io.Writer, report *Report, n int) {
+	entries := slices.Clone(report.Entries)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Detect+entries[i].Apply > entries[j].Detect+entries[j].Apply
+	})
+	n = min(n, len(entries))
+
+	fmt.Fprintf(w, "Slowest %d of %d paths:\n", n, len(entries))
+	for _, e := range entries[:n] {
+		fmt.Fprintf(w, "  %8s  %s (detect %s, apply %s): %s\n", round(e.Detect+e.Apply), e.TargetPath,
+			round(e.Detect), round(e.Apply), slowHint(e))
+	}
+}
+
+// round rounds d for display, keeping short durations readable.
+func round(d time.Duration) time.Duration {
+	if d >= time.Second {
+		return d.Round(time.Millisecond)
+	}
+	return d.Round(time.Microsecond)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+
+	"lnkit/fileutil"
+	"lnkit/manifest"
+)
+
+// manifestEntryBytes is a generous estimate of what each link adds to its manifest.
+const manifestEntryBytes = 512
+
+// lowSpacePercent is how little room, as a percentage of the filesystem, may be left
+// after a run before lnkit warns that it is nearly full or near its quota.
+const lowSpacePercent = 5
+
+// diskSpace returns the space on the filesystem holding a path (overridable in tests).
+var diskSpace = fileutil.DiskSpace
+
+// planLinks returns what linking targetRoot, and the base repo under it, into
+// linkRoot would do, without doing it.
+func planLinks(linkRoot, targetRoot string, cfg Config, opts linkOptions) (*Report, error) {
+	opts.dryRun = true
+	report, err := createSymlinks(linkRoot, targetRoot, opts)
+	if err != nil {
+		return report, err
+	}
+	baseReport, err := linkBase(linkRoot, targetRoot, cfg, opts)
+	if baseReport != nil {
+		report.Entries = append(report.Entries, baseReport.Entries...)
+	}
+	return report, err
+}
+
+// spaceNeeded estimates the blocks and inodes applying plan takes on the link side:
+// a symlink for every link it would create or that is in the way, and every directory
+// created to hold them. Backups are renames and take no extra room.
+func spaceNeeded(plan *Report) (links, dirs int) {
+	created := map[string]bool{}
+	for _, e := range plan.Entries {
+		if e.Status != StatusPending && e.Status != StatusConflict {
+			continue
+		}
+		links++
+		for _, dir := range fileutil.MissingParents(e.LinkPath) {
+			created[dir] = true
+		}
+	}
+	return links, len(created)
+}
+
+// checkSpace fails if the filesystems holding linkRoot and lnkit's state don't have
+// room for everything plan would write, so a run doesn't run out of space halfway
+// through. It warns if they would be left nearly full.
+func checkSpace(linkRoot string, plan *Report) error {
+	links, dirs := spaceNeeded(plan)
+	if links == 0 {
+		return nil
+	}
+
+	space, ok, err := diskSpace(linkRoot)
+	if err != nil || !ok {
+		return err
+	}
+	inodes := uint64(links + dirs)
+	if err := checkRoom(linkRoot, space, inodes*space.BlockSize, inodes); err != nil {
+		return err
+	}
+
+	stateDir, err := manifest.StateDir()
+	if err != nil {
+		return err
+	}
+	space, ok, err = diskSpace(stateDir)
+	if err != nil || !ok {
+		return err
+	}
+	return checkRoom(stateDir, space, uint64(links)*manifestEntryBytes, 0)
+}
+
+// checkRoom fails if space doesn't have bytes and inodes free, and warns if it would
+// be left with less than lowSpacePercent of it.
+func checkRoom(path string, space fileutil.Space, bytes, inodes uint64) error {
+	if space.Available < bytes {
+		return fmt.Errorf("not enough space for %s: need %d KiB, %d KiB available", path, bytes/1024, space.Available/1024)
+	}
+	if space.TotalInodes > 0 && space.FreeInodes < inodes {
+		return fmt.Errorf("not enough inodes for %s: need %d, %d free", path, inodes, space.FreeInodes)
+	}
+	if left := space.Available - bytes; left*100 < space.Total*lowSpacePercent {
+		sugar.Warnf("The filesystem holding %s will be nearly full or near its quota: %d KiB left", path, left/1024)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"lnkit/fileutil"
+	"lnkit/manifest"
+
+	"github.com/spf13/cobra"
+)
+
+func NewStateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "state",
+		Short: "Export or import lnkit's record of managed links and backups",
+	}
+	cmd.AddCommand(newStateExportCmd(), newStateImportCmd())
+	return cmd
+}
+
+func newStateExportCmd() *cobra.Command {
+	var output string
+
+	cmd := &cobra.Command{
+		Use:   "export link_path target_path",
+		Short: "Write the manifest of links from link_path to target_path, and their backups, as JSON",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			linkPath, err := fileutil.ExpandPath(args[0])
+			if err != nil {
+				return fmt.Errorf("failed to expand link path: %w", err)
+			}
+			targetPath, err := fileutil.ExpandPath(args[1])
+			if err != nil {
+				return fmt.Errorf("failed to expand target path: %w", err)
+			}
+
+			m, err := loadManifest(linkPath, targetPath)
+			if err != nil {
+				return err
+			}
+
+			w := cmd.OutOrStdout()
+			if output != "" && output != "-" {
+				if err := fileutil.CheckWritable("write", output); err != nil {
+					return err
+				}
+				f, err := os.Create(output)
+				if err != nil {
+					return fmt.Errorf("failed to create %s: %w", output, err)
+				}
+				defer f.Close()
+				w = f
+			}
+			return m.Export(w)
+		},
+		Example: `
+			lnk state export ~ ~/dotfiles > state.json
+			lnk state export -o state.json ~ ~/dotfiles
+		`,
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "", "File to write to (default: stdout)")
+
+	return cmd
+}
+
+func newStateImportCmd() *cobra.Command {
+	var merge bool
+
+	cmd := &cobra.Command{
+		Use:   "import [file]",
+		Short: "Replace (or merge into) the manifest for the exported link and target paths",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var r io.Reader = cmd.InOrStdin()
+			if len(args) == 1 && args[0] != "-" {
+				f, err := os.Open(args[0])
+				if err != nil {
+					return fmt.Errorf("failed to open %s: %w", args[0], err)
+				}
+				defer f.Close()
+				r = f
+			}
+
+			data, err := io.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			imported, err := manifest.Parse(data)
+			if err != nil {
+				return err
+			}
+			if imported.LinkRoot == "" || imported.TargetRoot == "" {
+				return fmt.Errorf("exported manifest doesn't say which link and target paths it is for")
+			}
+
+			m, err := loadManifest(imported.LinkRoot, imported.TargetRoot)
+			if err != nil {
+				return err
+			}
+			if err := m.Import(bytes.NewReader(data), merge); err != nil {
+				return err
+			}
+			if err := m.Save(); err != nil {
+				return err
+			}
+
+			sugar.Infof("Imported %d links and %d backups", len(m.Links), len(m.Backups))
+			return nil
+		},
+		Example: `
+			lnk state import state.json
+			lnk state import --merge < state.json
+		`,
+	}
+	cmd.Flags().BoolVar(&merge, "merge", false, "Add to the existing records instead of replacing them")
+
+	return cmd
+}
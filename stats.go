@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	api "lnkit/api/v1"
+	"lnkit/fileutil"
+	"lnkit/manifest"
+	"lnkit/stringutil"
+
+	"github.com/spf13/cobra"
+)
+
+// historyFile is where the results of lnk stats are kept, next to the manifest of
+// the link/target pair they are about, one JSON object per line.
+const historyFile = "history.jsonl"
+
+// maxHistory is how many results are kept; older ones are dropped.
+const maxHistory = 1000
+
+// statsRecord is the result of one run of lnk stats.
+type statsRecord struct {
+	Time  time.Time `json:"time"`
+	Host  string    `json:"host"`
+	Stats api.Stats `json:"stats"`
+}
+
+// drift is how far from linked the pair was: links missing or with something in the way.
+func (r statsRecord) drift() int {
+	return r.Stats.Pending + r.Stats.Conflict
+}
+
+// historyPath returns where the stats history of links from linkRoot to targetRoot is kept.
+func historyPath(linkRoot, targetRoot string) (string, error) {
+	path, err := manifest.PathFor(linkRoot, targetRoot)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(path), historyFile), nil
+}
+
+// readHistory returns the recorded stats at path, oldest first.
+func readHistory(path string) ([]statsRecord, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var records []statsRecord
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var r statsRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			sugar.Debugf("Skipping bad line in %s: %v", path, err)
+			continue
+		}
+		records = append(records, r)
+	}
+	return records, scanner.Err()
+}
+
+// appendHistory adds r to the history at path, dropping the oldest records beyond maxHistory.
+func appendHistory(path string, r statsRecord) error {
+	records, err := readHistory(path)
+	if err != nil {
+		return err
+	}
+	records = append(records, r)
+	if len(records) > maxHistory {
+		records = records[len(records)-maxHistory:]
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	if err := fileutil.CheckWritable("save stats history", path); err != nil {
+		return err
+	}
+	if err := fileutil.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	return fileutil.WriteFileAtomic(path, buf.Bytes(), 0644)
+}
+
+// sparkline draws values as a row of bars, scaled to the largest.
+func sparkline(values []int) string {
+	bars := []rune("▁▂▃▄▅▆▇█")
+	top := 0
+	for _, v := range values {
+		top = max(top, v)
+	}
+	var b strings.Builder
+	for _, v := range values {
+		i := 0
+		if top > 0 {
+			i = v * (len(bars) - 1) / top
+		}
+		b.WriteRune(bars[i])
+	}
+	return b.String()
+}
+
+// writeHistory writes, for each machine, its drift over its last n records as a
+// sparkline, followed by a table of those records.
+func writeHistory(w io.Writer, records []statsRecord, n int) {
+	byHost := map[string][]statsRecord{}
+	for _, r := range records {
+		byHost[r.Host] = append(byHost[r.Host], r)
+	}
+	hosts := make([]string, 0, len(byHost))
+	for host := range byHost {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	for _, host := range hosts {
+		last := byHost[host]
+		if len(last) > n {
+			last = last[len(last)-n:]
+		}
+		drifts := make([]int, len(last))
+		for i, r := range last {
+			drifts[i] = r.drift()
+		}
+		fmt.Fprintf(w, "%s  drift %s\n", host, sparkline(drifts))
+		for _, r := range last {
+			fmt.Fprintf(w, "  %s  %d in place, %d missing, %d conflicts\n",
+				r.Time.Local().Format(time.DateTime), r.Stats.Unchanged, r.Stats.Pending, r.Stats.Conflict)
+		}
+	}
+}
+
+func NewStatsCmd() *cobra.Command {
+	var opts linkOptions
+	var configPath string
+	var history bool
+	var last int
+
+	cmd := &cobra.Command{
+		Use:   "stats link_path target_path",
+		Short: "Count how much of target_path is linked into link_path, and keep a history to spot drift",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if last < 1 {
+				return fmt.Errorf("--last must be at least 1")
+			}
+			linkPath, err := fileutil.ExpandPath(args[0])
+			if err != nil {
+				return fmt.Errorf("failed to expand link path: %w", err)
+			}
+			targetPath, err := fileutil.ExpandPath(args[1])
+			if err != nil {
+				return fmt.Errorf("failed to expand target path: %w", err)
+			}
+			path, err := historyPath(linkPath, targetPath)
+			if err != nil {
+				return err
+			}
+
+			if history {
+				records, err := readHistory(path)
+				if err != nil {
+					return err
+				}
+				if len(records) == 0 {
+					fmt.Fprintf(cmd.OutOrStdout(), "No stats recorded from %s to %s yet\n", linkPath, targetPath)
+					return nil
+				}
+				writeHistory(cmd.OutOrStdout(), records, last)
+				return nil
+			}
+
+			cfg, err := loadOptions(cmd, configPath, targetPath, &opts)
+			if err != nil {
+				return err
+			}
+			plan, err := planLinks(linkPath, targetPath, cfg, opts)
+			if err != nil {
+				return err
+			}
+			host, _ := os.Hostname()
+			record := statsRecord{Time: time.Now().UTC().Truncate(time.Second), Host: host, Stats: api.CountStats(plan.Entries)}
+
+			stringutil.PrintDotTable([][2]string{
+				{"in place", fmt.Sprint(record.Stats.Unchanged)},
+				{"missing", fmt.Sprint(record.Stats.Pending)},
+				{"conflicts", fmt.Sprint(record.Stats.Conflict)},
+				{"ignored", fmt.Sprint(record.Stats.Ignored)},
+			})
+			// A read-only query still shows the stats, it just isn't kept
+			if err := fileutil.CheckWritable("save stats history", path); err != nil {
+				sugar.Infof("Not recording stats history: %v", err)
+				return nil
+			}
+			return appendHistory(path, record)
+		},
+		Example: `
+			lnk stats --rec ~ ~/dotfiles
+			lnk stats --history ~ ~/dotfiles
+		`,
+	}
+	cmd.Flags().BoolVar(&opts.recursive, "rec", false, "Recursively process nested directories")
+	cmd.Flags().BoolVar(&opts.fold, "fold", false, "Link whole directories where applicable")
+	cmd.Flags().StringVar(&configPath, "config", "", "Config file (default: lnkit.toml in target_path)")
+	cmd.Flags().BoolVar(&history, "history", false, "Instead show how the counts changed over time, per machine")
+	cmd.Flags().IntVar(&last, "last", 10, "With --history, how many of the latest results to show per machine")
+
+	return cmd
+}
@@ -3,27 +3,82 @@ package stringutil
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"os"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/fatih/color"
 	"github.com/mattn/go-runewidth"
 )
 
+// stdin is shared between prompts so buffered input isn't lost between calls.
+var stdin = bufio.NewReader(os.Stdin)
+
+// waited is how long prompts have spent waiting for answers in total.
+var waited time.Duration
+
+// Waited returns how long prompts have spent waiting for answers in total, so
+// timings can leave out time spent waiting on the user.
+func Waited() time.Duration {
+	return waited
+}
+
+// readAnswer reads a line of input, keeping track of how long that took.
+func readAnswer() string {
+	start := time.Now()
+	answer, _ := stdin.ReadString('\n')
+	waited += time.Since(start)
+	return strings.ToLower(strings.TrimSpace(answer))
+}
+
+// SetInput replaces the reader prompts read answers from (os.Stdin by default).
+func SetInput(r io.Reader) {
+	stdin = bufio.NewReader(r)
+}
+
+// RecordInput copies the input prompts read from now on to w as well, so the
+// same answers can be given again later with SetInput.
+func RecordInput(w io.Writer) {
+	stdin = bufio.NewReader(io.TeeReader(stdin, w))
+}
+
 // AskForConfirmation prompts the user with the given message and expects y/n input.
 // Returns true if user types 'y' (case-insensitive).
 func AskForConfirmation(prompt string) bool {
 	bold := color.New(color.Bold).SprintFunc()
 	fmt.Printf("%s [y/%s]: ", prompt, bold("N"))
 
-	reader := bufio.NewReader(os.Stdin)
-	answer, _ := reader.ReadString('\n')
-	answer = strings.ToLower(strings.TrimSpace(answer))
+	answer := readAnswer()
 
 	return answer == "y"
 }
 
+// AskForChoice prompts the user to pick one of choices (single letters, e.g. "s", "b", "o").
+// Returns the chosen letter, or defaultChoice if the answer is empty or not one of choices.
+func AskForChoice(prompt string, choices []string, defaultChoice string) string {
+	bold := color.New(color.Bold).SprintFunc()
+	shown := make([]string, len(choices))
+	for i, c := range choices {
+		if c == defaultChoice {
+			shown[i] = bold(strings.ToUpper(c))
+		} else {
+			shown[i] = c
+		}
+	}
+	fmt.Printf("%s [%s]: ", prompt, strings.Join(shown, "/"))
+
+	answer := readAnswer()
+
+	for _, c := range choices {
+		if answer == c {
+			return c
+		}
+	}
+	return defaultChoice
+}
+
 var ansiRegex = regexp.MustCompile(`\x1b\[[0-9;]*m`)
 
 // StripANSI removes ANSI escape codes from the input string.
@@ -34,6 +89,11 @@ func StripANSI(str string) string {
 // PrintDotTable prints rows of left/right strings with dots filling the gap.
 // Each row is [2]string: left column and right column.
 func PrintDotTable(rows [][2]string) {
+	FprintDotTable(os.Stdout, rows)
+}
+
+// FprintDotTable is PrintDotTable writing to w.
+func FprintDotTable(w io.Writer, rows [][2]string) {
 	maxLeftLen := 0
 	for _, row := range rows {
 		if runewidth.StringWidth(row[0]) > maxLeftLen {
@@ -55,7 +115,7 @@ func PrintDotTable(rows [][2]string) {
 	totalPadding := spacingLeft + spacingRight + extraDots
 
 	divider := strings.Repeat("⎯", maxLeftLen+totalPadding+maxRightLen)
-	fmt.Println(divider)
+	fmt.Fprintln(w, divider)
 
 	leftSpace := strings.Repeat(" ", spacingLeft)
 	rightSpace := strings.Repeat(" ", spacingRight)
@@ -64,7 +124,58 @@ func PrintDotTable(rows [][2]string) {
 		left, right := row[0], row[1]
 		numDots := maxLeftLen - runewidth.StringWidth(left) + extraDots
 		dots := strings.Repeat(".", numDots)
-		fmt.Printf("%s%s%s%s%s\n", left, leftSpace, dots, rightSpace, right)
+		fmt.Fprintf(w, "%s%s%s%s%s\n", left, leftSpace, dots, rightSpace, right)
+	}
+	fmt.Fprintln(w, divider)
+}
+
+// maxDiffCells bounds the work LineDiff does: the product of the line counts compared.
+const maxDiffCells = 4_000_000
+
+// LineDiff compares a and b line by line and returns every line of both in order,
+// prefixed with "-" if it is only in a, "+" if only in b, or " " if in both. It
+// returns false if the inputs are too large to compare.
+func LineDiff(a, b []string) ([]string, bool) {
+	if len(a)*len(b) > maxDiffCells {
+		return nil, false
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	lines := make([]string, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			lines = append(lines, " "+a[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, "-"+a[i])
+			i++
+		default:
+			lines = append(lines, "+"+b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		lines = append(lines, "-"+a[i])
+	}
+	for ; j < len(b); j++ {
+		lines = append(lines, "+"+b[j])
 	}
-	fmt.Println(divider)
+	return lines, true
 }
@@ -45,3 +45,37 @@ func TestPrintDotTable(t *testing.T) {
 		t.Errorf("printDotTable output missing expected content")
 	}
 }
+
+func TestAskForChoice(t *testing.T) {
+	SetInput(strings.NewReader("o\n\nx\n"))
+	defer SetInput(os.Stdin)
+
+	choices := []string{"s", "b", "o"}
+	if got := AskForChoice("Resolve?", choices, "s"); got != "o" {
+		t.Errorf("AskForChoice() = %q; want %q", got, "o")
+	}
+	if got := AskForChoice("Resolve?", choices, "s"); got != "s" {
+		t.Errorf("AskForChoice() on empty answer = %q; want default %q", got, "s")
+	}
+	if got := AskForChoice("Resolve?", choices, "s"); got != "s" {
+		t.Errorf("AskForChoice() on unknown answer = %q; want default %q", got, "s")
+	}
+}
+
+func TestLineDiff(t *testing.T) {
+	a := []string{"one", "two", "three"}
+	b := []string{"one", "2", "three", "four"}
+
+	got, ok := LineDiff(a, b)
+	if !ok {
+		t.Fatal("expected small inputs to be compared")
+	}
+	want := []string{" one", "-two", "+2", " three", "+four"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("LineDiff() = %q; want %q", got, want)
+	}
+
+	if _, ok := LineDiff(make([]string, 3000), make([]string, 3000)); ok {
+		t.Errorf("expected inputs over the size limit to be refused")
+	}
+}
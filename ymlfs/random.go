@@ -0,0 +1,51 @@
+package ymlfs
+
+import (
+	"fmt"
+	"math/rand"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Random returns a synthetic tree of files files, nested at most depth directories
+// deep, in the same form FromYml reads. The same seed always gives the same tree.
+func Random(seed int64, files, depth int) map[string]interface{} {
+	r := rand.New(rand.NewSource(seed))
+	root := map[string]interface{}{}
+	if files <= 0 {
+		return root
+	}
+
+	// Spread files over roughly one directory per ten files, each under a random
+	// existing directory that still has room to nest
+	type dir struct {
+		node  map[string]interface{}
+		depth int
+	}
+	dirs := []dir{{node: root}}
+	open := []int{0} // Indexes of dirs shallower than depth
+	for i := 0; i < files/10 && depth > 0; i++ {
+		parent := dirs[open[r.Intn(len(open))]]
+		node := map[string]interface{}{}
+		parent.node[fmt.Sprintf("d%d", i)] = node
+
+		dirs = append(dirs, dir{node: node, depth: parent.depth + 1})
+		if parent.depth+1 < depth {
+			open = append(open, len(dirs)-1)
+		}
+	}
+
+	for i := 0; i < files; i++ {
+		d := dirs[r.Intn(len(dirs))]
+		d.node[fmt.Sprintf("f%d.txt", i)] = map[string]interface{}{
+			"type":    "file",
+			"content": fmt.Sprintf("%x\n", r.Int63()),
+		}
+	}
+	return root
+}
+
+// RandomYml is Random serialized as YAML.
+func RandomYml(seed int64, files, depth int) ([]byte, error) {
+	return yaml.Marshal(Random(seed, files, depth))
+}
@@ -0,0 +1,116 @@
+package ymlfs
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SnapshotOptions controls what Snapshot captures of a tree.
+type SnapshotOptions struct {
+	// Include reports whether the entry at rel, relative to the snapshot root, is
+	// captured. Directories that aren't included are captured empty, files that aren't
+	// are left out. Nil includes everything.
+	Include func(rel string, isDir bool) bool
+
+	// Redact replaces the content of every file not listed in Keep (relative paths)
+	// with a hash of it, so a tree can be shared without its contents while files
+	// that were equal stay equal.
+	Redact bool
+	Keep   []string
+}
+
+// Snapshot serializes the tree at rootDir in the form FromYml reads, like ToYml,
+// but keeps symlink targets exactly as they are so links pointing outside the
+// tree still point to the same place when it is recreated elsewhere.
+func Snapshot(rootDir string, opts SnapshotOptions) ([]byte, error) {
+	info, err := os.Stat(rootDir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("rootDir must be a directory")
+	}
+
+	keep := make(map[string]bool, len(opts.Keep))
+	for _, rel := range opts.Keep {
+		keep[filepath.Clean(rel)] = true
+	}
+
+	tree, err := snapshotTree(rootDir, "", opts, keep)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(tree)
+}
+
+func snapshotTree(base, rel string, opts SnapshotOptions, keep map[string]bool) (map[string]interface{}, error) {
+	entries, err := os.ReadDir(filepath.Join(base, rel))
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]interface{}, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		entryRel := filepath.Join(rel, name)
+		path := filepath.Join(base, entryRel)
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		isDir := info.IsDir()
+		included := opts.Include == nil || opts.Include(entryRel, isDir)
+
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			if !included {
+				continue
+			}
+			target, err := os.Readlink(path)
+			if err != nil {
+				return nil, err
+			}
+			result[name] = map[string]interface{}{
+				"type":   "symlink",
+				"target": target,
+			}
+
+		case isDir:
+			if !included {
+				result[name] = nil
+				continue
+			}
+			subtree, err := snapshotTree(base, entryRel, opts, keep)
+			if err != nil {
+				return nil, err
+			}
+			if len(subtree) == 0 {
+				result[name] = nil
+			} else {
+				result[name] = subtree
+			}
+
+		default:
+			if !included {
+				continue
+			}
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			if opts.Redact && !keep[entryRel] {
+				content = []byte(fmt.Sprintf("redacted %x\n", sha256.Sum256(content)))
+			}
+			result[name] = map[string]interface{}{
+				"type":    "file",
+				"content": string(content),
+			}
+		}
+	}
+	return result, nil
+}
@@ -20,15 +20,32 @@ func FromYml(rootDir string, yamlData []byte) error {
 	return createStructure(rootDir, root)
 }
 
+// entryPath returns the path of the entry name in base. Names must be single path
+// elements, and nothing is created through a symlink already there, so a structure
+// can't reach outside rootDir.
+func entryPath(base, name string) (string, error) {
+	if !filepath.IsLocal(name) || filepath.Base(name) != name {
+		return "", fmt.Errorf("invalid entry name %q in %s", name, base)
+	}
+	path := filepath.Join(base, name)
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		return "", fmt.Errorf("refusing to create %s through an existing symlink", path)
+	}
+	return path, nil
+}
+
 func createStructure(base string, node map[string]interface{}) error {
 	for name, val := range node {
+		path, err := entryPath(base, name)
+		if err != nil {
+			return err
+		}
 		switch typed := val.(type) {
 		case map[string]interface{}:
 			typ, _ := typed["type"].(string)
 
 			switch typ {
 			case "file":
-				path := filepath.Join(base, name)
 				content, ok := typed["content"].(string)
 				if !ok {
 					return fmt.Errorf("file %s missing 'content'", name)
@@ -48,18 +65,16 @@ func createStructure(base string, node map[string]interface{}) error {
 				if !ok {
 					return fmt.Errorf("symlink %s missing 'target'", name)
 				}
-				linkPath := filepath.Join(base, name)
-				if err := os.Symlink(target, linkPath); err != nil {
+				if err := os.Symlink(target, path); err != nil {
 					return err
 				}
 
 			case "":
 				// No "type" key → treat as directory
-				dirPath := filepath.Join(base, name)
-				if err := os.MkdirAll(dirPath, 0755); err != nil {
+				if err := os.MkdirAll(path, 0755); err != nil {
 					return err
 				}
-				if err := createStructure(dirPath, typed); err != nil {
+				if err := createStructure(path, typed); err != nil {
 					return err
 				}
 
@@ -69,8 +84,7 @@ func createStructure(base string, node map[string]interface{}) error {
 
 		case nil:
 			// nil means empty directory
-			dirPath := filepath.Join(base, name)
-			if err := os.MkdirAll(dirPath, 0755); err != nil {
+			if err := os.MkdirAll(path, 0755); err != nil {
 				return err
 			}
 
@@ -3,6 +3,7 @@ package ymlfs
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -123,3 +124,49 @@ second_link: {type: symlink, target: first_link}
 	requireSymlink(t, filepath.Join(tmpDir, "first_link"), "file1.txt")
 	requireSymlink(t, filepath.Join(tmpDir, "second_link"), "first_link")
 }
+
+func TestFromYml_StaysInRoot(t *testing.T) {
+	outside := t.TempDir()
+	tmpDir := filepath.Join(t.TempDir(), "root")
+	require.NoError(t, os.Mkdir(tmpDir, 0755))
+
+	for _, yamlData := range []string{
+		`"..": {escaped.txt: {type: file, content: "x"}}`,
+		`"a/../../escaped.txt": {type: file, content: "x"}`,
+	} {
+		require.Error(t, FromYml(tmpDir, []byte(yamlData)), yamlData)
+	}
+
+	// Nor through a symlink created before
+	require.NoError(t, FromYml(tmpDir, []byte("out: {type: symlink, target: "+outside+"}")))
+	require.Error(t, FromYml(tmpDir, []byte(`out: {escaped.txt: {type: file, content: "x"}}`)))
+	require.NoFileExists(t, filepath.Join(outside, "escaped.txt"))
+}
+
+func TestRandom(t *testing.T) {
+	data, err := RandomYml(1, 200, 3)
+	require.NoError(t, err)
+	applyAndCheckRoundTrip(t, data, t.TempDir())
+
+	again, err := RandomYml(1, 200, 3)
+	require.NoError(t, err)
+	require.Equal(t, data, again)
+
+	tmpDir := t.TempDir()
+	require.NoError(t, FromYml(tmpDir, data))
+	files := 0
+	err = filepath.Walk(tmpDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(tmpDir, path)
+		if info.IsDir() {
+			require.LessOrEqual(t, strings.Count(rel, string(filepath.Separator)), 2, "dir %s is nested too deep", rel)
+		} else {
+			files++
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 200, files)
+}